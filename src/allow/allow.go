@@ -0,0 +1,227 @@
+package allow
+
+import (
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig/cache"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	confKey = "conf"
+
+	SourceConfig  = "config"
+	SourceRuntime = "runtime"
+)
+
+var serv *Serv
+
+type Serv struct {
+	storage cache.Cache[AllowConf]
+
+	mu      sync.RWMutex
+	conf    AllowConf
+	source  string
+	allowed []*net.IPNet
+	proxies []*net.IPNet
+}
+
+func S() *Serv {
+	if serv == nil {
+		serv = &Serv{
+			storage: cache.New[AllowConf](cache.Sqlite, "om_allow"),
+		}
+		serv.load(context.Background())
+	}
+	return serv
+}
+
+// load reads om.allow_cidrs and om.trusted_proxies, a list saved at runtime replaces om.allow_cidrs.
+func (s *Serv) load(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	proxies, err := ParseCIDRs(splitList(configure.GetString("om.trusted_proxies", "")))
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("Invalid om.trusted_proxies, ignored: %v", err))
+	}
+	s.proxies = proxies
+
+	s.conf = AllowConf{CIDRs: splitList(configure.GetString("om.allow_cidrs", ""))}
+	s.source = SourceConfig
+	if saved, e := s.storage.Get(confKey); e == nil && saved.UpdateAt > 0 {
+		s.conf = saved
+		s.source = SourceRuntime
+	}
+	allowed, err := ParseCIDRs(s.conf.CIDRs)
+	if err != nil {
+		// Save refuses invalid entries, so only om.allow_cidrs can get here. A typo must not
+		// open the panel: keep the valid entries, and with none left only this host gets in.
+		logger.Error(ctx, fmt.Sprintf("Invalid OM allowlist entry, ignored: %v", err))
+		if len(allowed) == 0 {
+			logger.Error(ctx, "OM allowlist has no valid entry, only loopback is allowed until om.allow_cidrs is fixed")
+			allowed, _ = ParseCIDRs([]string{"127.0.0.0/8", "::1"})
+		}
+	}
+	s.allowed = allowed
+}
+
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == ';' || r == '\n'
+	}) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ParseCIDRs parses IPs and CIDRs, a bare IP matches only itself.
+// Invalid entries are reported but the valid ones are still returned.
+func ParseCIDRs(items []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(items))
+	var invalid []string
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				invalid = append(invalid, item)
+				continue
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			invalid = append(invalid, item)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	if len(invalid) > 0 {
+		return nets, fmt.Errorf("invalid IP or CIDR: %s", strings.Join(invalid, ", "))
+	}
+	return nets, nil
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		host = strings.TrimSpace(r.RemoteAddr)
+	}
+	return net.ParseIP(host)
+}
+
+// IsLocal reports whether the TCP peer is a loopback address, whatever the forwarded headers say.
+func IsLocal(r *http.Request) bool {
+	ip := remoteIP(r)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ClientIP resolves the caller's address. X-Forwarded-For is only followed through
+// hops that are trusted proxies, walking from the nearest hop to the farthest, so a
+// client cannot pick its own address by sending the header itself.
+func (s *Serv) ClientIP(r *http.Request) net.IP {
+	s.mu.RLock()
+	proxies := s.proxies
+	s.mu.RUnlock()
+
+	ip := remoteIP(r)
+	if ip == nil || !contains(proxies, ip) {
+		return ip
+	}
+	hops := make([]string, 0)
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// A malformed hop cannot be trusted, stop at the last proxy we know.
+			return ip
+		}
+		ip = hop
+		if !contains(proxies, hop) {
+			return hop
+		}
+	}
+	return ip
+}
+
+// Allowed reports whether the IP may reach OM. An empty allowlist allows everyone.
+func (s *Serv) Allowed(ip net.IP) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.conf.CIDRs) == 0 {
+		return true
+	}
+	return ip != nil && contains(s.allowed, ip)
+}
+
+func (s *Serv) Get(ctx context.Context, r *http.Request) *AllowOut {
+	s.mu.RLock()
+	out := &AllowOut{AllowConf: s.conf, Source: s.source, TrustedProxies: make([]string, 0, len(s.proxies))}
+	for _, p := range s.proxies {
+		out.TrustedProxies = append(out.TrustedProxies, p.String())
+	}
+	s.mu.RUnlock()
+	if out.CIDRs == nil {
+		out.CIDRs = make([]string, 0)
+	}
+	if ip := s.ClientIP(r); ip != nil {
+		out.ClientIP = ip.String()
+	}
+	return out
+}
+
+// Save replaces the allowlist at runtime. It refuses a list that would lock out the caller.
+func (s *Serv) Save(ctx context.Context, r *http.Request, username string, cidrs []string) *errors.Error {
+	items := make([]string, 0, len(cidrs))
+	for _, c := range cidrs {
+		items = append(items, splitList(c)...)
+	}
+	nets, err := ParseCIDRs(items)
+	if err != nil {
+		return errors.Verify(err.Error())
+	}
+	if len(items) > 0 {
+		ip := s.ClientIP(r)
+		if ip == nil || !contains(nets, ip) {
+			return errors.Verify(fmt.Sprintf("The list does not include your address %s, saving it would lock you out", ip))
+		}
+	}
+	conf := AllowConf{CIDRs: items, UpdateAt: time.Now().Unix(), UpdateBy: username}
+	if e := s.storage.Set(confKey, conf, 0); e != nil {
+		return errors.Sys("Save OM allowlist failed", e)
+	}
+	s.load(ctx)
+	logger.Info(ctx, fmt.Sprintf("OM allowlist saved by %s: %v", username, items))
+	return nil
+}
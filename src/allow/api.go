@@ -0,0 +1,25 @@
+package allow
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig-om/src/omuser"
+	"github.com/jom-io/gorig/apix"
+	"github.com/jom-io/gorig/global/consts"
+)
+
+func Get(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, S().Get(ctx, ctx.Request), nil)
+}
+
+func Save(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	req := AllowConf{}
+	e := apix.BindParams(ctx, &req, true)
+	if e != nil {
+		return
+	}
+	username, _ := omuser.Current(ctx)
+	err := S().Save(ctx, ctx.Request, username, req.CIDRs)
+	apix.HandleData(ctx, consts.CurdUpdateFailCode, nil, err)
+}
@@ -0,0 +1,14 @@
+package allow
+
+type AllowConf struct {
+	CIDRs    []string `json:"cidrs" form:"cidrs"`       // IPs or CIDRs allowed to reach OM, empty allows everyone
+	UpdateAt int64    `json:"updateAt" form:"updateAt"` // Last update time
+	UpdateBy string   `json:"updateBy" form:"updateBy"` // OM account that made the last update
+}
+
+type AllowOut struct {
+	AllowConf
+	Source         string   `json:"source"`         // config or runtime
+	TrustedProxies []string `json:"trustedProxies"` // from om.trusted_proxies
+	ClientIP       string   `json:"clientIp"`       // caller's IP as resolved by the allowlist
+}
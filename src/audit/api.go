@@ -0,0 +1,34 @@
+package audit
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig/apix"
+	"github.com/jom-io/gorig/global/consts"
+)
+
+func Page(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	req := PageReq{}
+	e := apix.BindParams(ctx, &req, true)
+	if e != nil {
+		return
+	}
+	result, err := S().Page(ctx, req)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+func GetRetention(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, S().GetRetention(ctx), nil)
+}
+
+func SetRetention(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	req := Retention{}
+	e := apix.BindParams(ctx, &req, true)
+	if e != nil {
+		return
+	}
+	err := S().SetRetention(ctx, req)
+	apix.HandleData(ctx, consts.CurdUpdateFailCode, nil, err)
+}
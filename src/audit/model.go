@@ -0,0 +1,42 @@
+package audit
+
+type Result string
+
+const (
+	ResultSuccess Result = "success"
+	ResultFailed  Result = "failed"
+)
+
+func (r Result) String() string {
+	return string(r)
+}
+
+type AuditLog struct {
+	At       int64  `json:"at"`                // Unix seconds when the call started
+	Actor    string `json:"actor"`             // OM account that made the call
+	Token    string `json:"token,omitempty"`   // ID of the API token used, empty for interactive sessions
+	Role     string `json:"role,omitempty"`    // Role of the actor at call time
+	IP       string `json:"ip"`                // Client IP
+	Method   string `json:"method"`            // HTTP method
+	Route    string `json:"route"`             // Route path, e.g. /om/deploy/task/start
+	Params   string `json:"params,omitempty"`  // Request parameters with secrets masked
+	Result   Result `json:"result"`            // success or failed
+	Status   int    `json:"status"`            // HTTP status of the response
+	Message  string `json:"message,omitempty"` // Error message when failed
+	Duration int64  `json:"duration"`          // Duration in milliseconds
+	TraceID  string `json:"traceId,omitempty"` // Trace ID of the request
+}
+
+type PageReq struct {
+	Start  int64  `json:"start" form:"start"`
+	End    int64  `json:"end" form:"end"`
+	Actor  string `json:"actor" form:"actor"`
+	Route  string `json:"route" form:"route"`
+	Result Result `json:"result" form:"result"`
+	Page   int64  `json:"page" form:"page"`
+	Size   int64  `json:"size" form:"size"`
+}
+
+type Retention struct {
+	MaxPeriod string `json:"maxPeriod" form:"maxPeriod" binding:"required"` // e.g. 2160h
+}
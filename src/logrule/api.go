@@ -0,0 +1,133 @@
+package logrule
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig-om/src/logtool"
+	"github.com/jom-io/gorig-om/src/omuser"
+	"github.com/jom-io/gorig/apix"
+	"github.com/jom-io/gorig/global/consts"
+	"time"
+)
+
+func SearchPage(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	pageReq, e := apix.GetPageReq(ctx)
+	if e != nil {
+		return
+	}
+	owner, e := apix.GetParamStr(ctx, "owner", "")
+	if e != nil {
+		return
+	}
+	result, err := S().SearchPage(ctx, owner, pageReq.Page, pageReq.Size)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+func SearchSave(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	req := SaveSearchReq{}
+	e := apix.BindParams(ctx, &req, true)
+	if e != nil {
+		return
+	}
+	username, role := omuser.Current(ctx)
+	result, err := S().SaveSearch(ctx, username, role, req)
+	apix.HandleData(ctx, consts.CurdUpdateFailCode, result, err)
+}
+
+func SearchDelete(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	id, e := apix.GetParamForce(ctx, "id")
+	if e != nil {
+		return
+	}
+	username, role := omuser.Current(ctx)
+	err := S().DeleteSearch(ctx, username, role, id)
+	apix.HandleData(ctx, consts.CurdDeleteFailCode, nil, err)
+}
+
+func RulePage(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	pageReq, e := apix.GetPageReq(ctx)
+	if e != nil {
+		return
+	}
+	searchID, e := apix.GetParamStr(ctx, "searchId", "")
+	if e != nil {
+		return
+	}
+	result, err := S().RulePage(ctx, searchID, pageReq.Page, pageReq.Size)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+func RuleSave(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	req := SaveRuleReq{}
+	e := apix.BindParams(ctx, &req, true)
+	if e != nil {
+		return
+	}
+	username, _ := omuser.Current(ctx)
+	result, err := S().SaveRule(ctx, username, req)
+	apix.HandleData(ctx, consts.CurdUpdateFailCode, result, err)
+}
+
+func RuleDelete(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	id, e := apix.GetParamForce(ctx, "id")
+	if e != nil {
+		return
+	}
+	username, _ := omuser.Current(ctx)
+	err := S().DeleteRule(ctx, username, id)
+	apix.HandleData(ctx, consts.CurdDeleteFailCode, nil, err)
+}
+
+// RuleTest runs a rule once over the window ending now, without saving it or recording events.
+func RuleTest(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	req := SaveRuleReq{}
+	e := apix.BindParams(ctx, &req, true)
+	if e != nil {
+		return
+	}
+	rule := Rule{
+		Name:      req.Name,
+		SearchID:  req.SearchID,
+		Kind:      req.Kind,
+		Window:    req.Window,
+		Threshold: req.Threshold,
+		Cooldown:  req.Cooldown,
+		Samples:   req.Samples,
+	}
+	result, err := S().Check(ctx.Request.Context(), rule, time.Now())
+	if result != nil {
+		redactSamples(logtool.ViewRedactor(ctx, false), result.Samples)
+	}
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+func AlertPage(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	req := AlertPageReq{}
+	e := apix.BindParams(ctx, &req, true)
+	if e != nil {
+		return
+	}
+	result, err := S().AlertPage(ctx, req)
+	if result != nil {
+		redact := logtool.ViewRedactor(ctx, req.Unmasked)
+		for _, item := range result.Items {
+			redactSamples(redact, item.Samples)
+		}
+	}
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+// redactSamples masks the sample records the way the log views do. Samples are stored as they
+// were matched, so an account with logs:unmasked can still see them in full.
+func redactSamples(redact *logtool.Redactor, samples []logtool.MatchedRecord) {
+	for i := range samples {
+		samples[i].Record = redact.Record(samples[i].Record)
+	}
+}
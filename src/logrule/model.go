@@ -0,0 +1,112 @@
+package logrule
+
+import "github.com/jom-io/gorig-om/src/logtool"
+
+type RuleKind string
+
+const (
+	KindThreshold RuleKind = "threshold" // fires when the window holds at least Threshold matches
+	KindAbsence   RuleKind = "absence"   // fires when the window holds fewer than Threshold matches, e.g. a missing heartbeat
+)
+
+func (k RuleKind) String() string {
+	return string(k)
+}
+
+func (k RuleKind) Valid() bool {
+	return k == KindThreshold || k == KindAbsence
+}
+
+type AlertStatus string
+
+const (
+	StatusFiring   AlertStatus = "firing"
+	StatusResolved AlertStatus = "resolved"
+)
+
+// SavedSearch is a named set of log search filters shared by every OM account.
+type SavedSearch struct {
+	ID       string                `json:"id"`
+	Name     string                `json:"name"`
+	Owner    string                `json:"owner"`   // account that created it, only it or an admin may change it
+	Options  logtool.SearchOptions `json:"options"` // categories, levels, keyword, query... time range and paging are ignored by rules
+	CreateAt int64                 `json:"createAt"`
+	UpdateAt int64                 `json:"updateAt"`
+}
+
+// Rule evaluates a saved search over a sliding window every minute.
+type Rule struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	SearchID    string   `json:"searchId"`
+	Kind        RuleKind `json:"kind"`
+	Window      string   `json:"window"`    // e.g. 5m, from 1m to 24h
+	Threshold   int64    `json:"threshold"` // 1 by default
+	Cooldown    string   `json:"cooldown"`  // minimum time between two firing events while the rule keeps firing, the window by default
+	Samples     int      `json:"samples"`   // matching records kept with a threshold event, 5 by default
+	Enabled     bool     `json:"enabled"`
+	Owner       string   `json:"owner"`
+	Firing      bool     `json:"firing"`      // state after the last evaluation
+	LastCount   int64    `json:"lastCount"`   // matches found by the last evaluation
+	LastEvalAt  int64    `json:"lastEvalAt"`  // Unix seconds
+	LastFiredAt int64    `json:"lastFiredAt"` // Unix seconds of the last firing event
+	LastError   string   `json:"lastError,omitempty"`
+	CreateAt    int64    `json:"createAt"`
+	UpdateAt    int64    `json:"updateAt"`
+}
+
+// AlertEvent is recorded when a rule starts firing, keeps firing past its cooldown, or resolves.
+type AlertEvent struct {
+	ID        string                  `json:"id"`
+	RuleID    string                  `json:"ruleId"`
+	RuleName  string                  `json:"ruleName"`
+	SearchID  string                  `json:"searchId"`
+	Kind      RuleKind                `json:"kind"`
+	Status    AlertStatus             `json:"status"`
+	At        int64                   `json:"at"`    // Unix seconds of the evaluation
+	From      string                  `json:"from"`  // start of the evaluated window
+	To        string                  `json:"to"`    // end of the evaluated window
+	Count     int64                   `json:"count"` // matches in the window
+	Threshold int64                   `json:"threshold"`
+	Message   string                  `json:"message"`
+	Samples   []logtool.MatchedRecord `json:"samples,omitempty"` // newest matches, threshold events only
+}
+
+// Evaluation is the outcome of running a rule once.
+type Evaluation struct {
+	From      string                  `json:"from"`
+	To        string                  `json:"to"`
+	Count     int64                   `json:"count"`
+	Firing    bool                    `json:"firing"`
+	Truncated bool                    `json:"truncated"` // samples may be incomplete
+	Samples   []logtool.MatchedRecord `json:"samples"`
+}
+
+type SaveSearchReq struct {
+	ID      string                `json:"id" form:"id"` // empty to create
+	Name    string                `json:"name" form:"name" binding:"required"`
+	Options logtool.SearchOptions `json:"options" form:"options"`
+}
+
+type SaveRuleReq struct {
+	ID        string   `json:"id" form:"id"` // empty to create
+	Name      string   `json:"name" form:"name" binding:"required"`
+	SearchID  string   `json:"searchId" form:"searchId" binding:"required"`
+	Kind      RuleKind `json:"kind" form:"kind" binding:"required"`
+	Window    string   `json:"window" form:"window" binding:"required"`
+	Threshold int64    `json:"threshold" form:"threshold"`
+	Cooldown  string   `json:"cooldown" form:"cooldown"`
+	Samples   int      `json:"samples" form:"samples"`
+	Enabled   bool     `json:"enabled" form:"enabled"`
+}
+
+type AlertPageReq struct {
+	RuleID string      `json:"ruleId" form:"ruleId"`
+	Status AlertStatus `json:"status" form:"status"`
+	Start  int64       `json:"start" form:"start"`
+	End    int64       `json:"end" form:"end"`
+	Page   int64       `json:"page" form:"page"`
+	Size   int64       `json:"size" form:"size"`
+
+	Unmasked bool `json:"unmasked" form:"unmasked"` // samples without redaction, for accounts with logs:unmasked
+}
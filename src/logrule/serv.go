@@ -0,0 +1,489 @@
+package logrule
+
+import (
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig-om/src/logtool"
+	"github.com/jom-io/gorig-om/src/omuser"
+	"github.com/jom-io/gorig/cache"
+	"github.com/jom-io/gorig/cronx"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+const (
+	timeLayout = "2006-01-02 15:04:05"
+
+	minWindow     = time.Minute
+	maxWindow     = 24 * time.Hour
+	defSamples    = 5
+	maxSamples    = 20
+	maxRules      = 200
+	maxNameLen    = 64
+	sampleTimeout = 10 * time.Second
+)
+
+var (
+	serv        *Serv
+	maxAlertAge = 30 * 24 * time.Hour
+)
+
+type Serv struct {
+	// RootDir is the application directory whose logs the rules watch, the working directory
+	// when empty. Saved searches never carry one, so a caller cannot point the rules elsewhere.
+	RootDir string
+
+	searches cache.Pager[SavedSearch]
+	rules    cache.Pager[Rule]
+	alerts   cache.Pager[AlertEvent]
+}
+
+func S() *Serv {
+	if serv == nil {
+		serv = &Serv{
+			searches: cache.NewPager[SavedSearch](context.Background(), cache.Sqlite, "om_log_search"),
+			rules:    cache.NewPager[Rule](context.Background(), cache.Sqlite, "om_log_rule"),
+			alerts:   cache.NewPager[AlertEvent](context.Background(), cache.Sqlite, "om_log_alert"),
+		}
+	}
+	return serv
+}
+
+func init() {
+	if d := configure.GetDuration("om.log.alert_max_period", 720*time.Hour); d > 0 {
+		maxAlertAge = d
+	}
+	if !configure.GetBool("om.log.rules", true) {
+		return
+	}
+	cronx.AddCronTask("15 * * * * *", S().Evaluate, 50*time.Second)
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := S().Clear(context.Background()); err != nil {
+				logger.Error(context.Background(), "Clear log alerts failed", zap.Error(err))
+			}
+		}
+	}()
+}
+
+func checkName(name string) (string, *errors.Error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxNameLen {
+		return "", errors.Verify(fmt.Sprintf("Invalid name, use 1-%d characters", maxNameLen))
+	}
+	return name, nil
+}
+
+// searchOptions keeps only the filters of a saved search, the time range and paging are set per run.
+func searchOptions(opts logtool.SearchOptions) logtool.SearchOptions {
+	return logtool.SearchOptions{
+		Categories: opts.Categories,
+		Level:      opts.Level,
+		Levels:     opts.Levels,
+		TraceID:    opts.TraceID,
+		Keyword:    opts.Keyword,
+		Query:      opts.Query,
+	}
+}
+
+func (s *Serv) SearchPage(ctx context.Context, owner string, page, size int64) (*cache.PageCache[SavedSearch], *errors.Error) {
+	if page <= 0 {
+		page = 1
+	}
+	if size <= 0 {
+		size = 20
+	}
+	cond := map[string]any{}
+	if owner != "" {
+		cond["owner"] = owner
+	}
+	result, err := s.searches.Find(page, size, cond, cache.PageSorterAsc("name"))
+	if err != nil {
+		return nil, errors.Sys("Page saved searches failed", err)
+	}
+	return result, nil
+}
+
+func (s *Serv) GetSearch(ctx context.Context, id string) (*SavedSearch, *errors.Error) {
+	item, err := s.searches.Get(map[string]any{"id": id})
+	if err != nil {
+		return nil, errors.Sys("Get saved search failed", err)
+	}
+	if item == nil {
+		return nil, errors.Verify("Saved search not found")
+	}
+	return item, nil
+}
+
+// SaveSearch creates a saved search, or updates one owned by the account. Admins may update any.
+func (s *Serv) SaveSearch(ctx context.Context, username string, role omuser.Role, req SaveSearchReq) (*SavedSearch, *errors.Error) {
+	name, e := checkName(req.Name)
+	if e != nil {
+		return nil, e
+	}
+	opts := searchOptions(req.Options)
+	if _, err := logtool.ParseQuery(opts.Query); err != nil {
+		return nil, errors.Verify(fmt.Sprintf("Invalid query: %v", err))
+	}
+
+	now := time.Now().Unix()
+	if req.ID == "" {
+		item := SavedSearch{ID: xid.New().String(), Name: name, Owner: username, Options: opts, CreateAt: now, UpdateAt: now}
+		if err := s.searches.Put(item); err != nil {
+			return nil, errors.Sys("Save saved search failed", err)
+		}
+		logger.Info(ctx, fmt.Sprintf("Saved search created: %s(%s), owner: %s", item.Name, item.ID, username))
+		return &item, nil
+	}
+
+	item, e := s.GetSearch(ctx, req.ID)
+	if e != nil {
+		return nil, e
+	}
+	if item.Owner != username && !role.Can(omuser.PermUserManage) {
+		return nil, errors.Verify("Only the owner may change this saved search")
+	}
+	item.Name = name
+	item.Options = opts
+	item.UpdateAt = now
+	if err := s.searches.Update(map[string]any{"id": item.ID}, item); err != nil {
+		return nil, errors.Sys("Save saved search failed", err)
+	}
+	return item, nil
+}
+
+// DeleteSearch removes a saved search that no rule refers to.
+func (s *Serv) DeleteSearch(ctx context.Context, username string, role omuser.Role, id string) *errors.Error {
+	item, e := s.GetSearch(ctx, id)
+	if e != nil {
+		return e
+	}
+	if item.Owner != username && !role.Can(omuser.PermUserManage) {
+		return errors.Verify("Only the owner may delete this saved search")
+	}
+	used, err := s.rules.Count(map[string]any{"searchId": id})
+	if err != nil {
+		return errors.Sys("Count log rules failed", err)
+	}
+	if used > 0 {
+		return errors.Verify(fmt.Sprintf("Saved search is used by %d rule(s), delete them first", used))
+	}
+	if err = s.searches.Delete(map[string]any{"id": id}); err != nil {
+		return errors.Sys("Delete saved search failed", err)
+	}
+	logger.Info(ctx, fmt.Sprintf("Saved search deleted: %s(%s), by: %s", item.Name, item.ID, username))
+	return nil
+}
+
+func (s *Serv) RulePage(ctx context.Context, searchID string, page, size int64) (*cache.PageCache[Rule], *errors.Error) {
+	if page <= 0 {
+		page = 1
+	}
+	if size <= 0 {
+		size = 20
+	}
+	cond := map[string]any{}
+	if searchID != "" {
+		cond["searchId"] = searchID
+	}
+	result, err := s.rules.Find(page, size, cond, cache.PageSorterAsc("name"))
+	if err != nil {
+		return nil, errors.Sys("Page log rules failed", err)
+	}
+	return result, nil
+}
+
+func (s *Serv) GetRule(ctx context.Context, id string) (*Rule, *errors.Error) {
+	item, err := s.rules.Get(map[string]any{"id": id})
+	if err != nil {
+		return nil, errors.Sys("Get log rule failed", err)
+	}
+	if item == nil {
+		return nil, errors.Verify("Log rule not found")
+	}
+	return item, nil
+}
+
+func parseDuration(name, value string, min, max time.Duration) (time.Duration, *errors.Error) {
+	d, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil || d < min || d > max || d%time.Second != 0 {
+		return 0, errors.Verify(fmt.Sprintf("Invalid %s: %s, use whole seconds from %s to %s", name, value, min, max))
+	}
+	return d, nil
+}
+
+// checkRule validates the rule and fills in its defaults.
+func (s *Serv) checkRule(ctx context.Context, rule *Rule) *errors.Error {
+	name, e := checkName(rule.Name)
+	if e != nil {
+		return e
+	}
+	rule.Name = name
+	if !rule.Kind.Valid() {
+		return errors.Verify(fmt.Sprintf("Invalid kind: %s, use %s or %s", rule.Kind, KindThreshold, KindAbsence))
+	}
+	window, e := parseDuration("window", rule.Window, minWindow, maxWindow)
+	if e != nil {
+		return e
+	}
+	rule.Window = window.String()
+	if rule.Cooldown == "" {
+		rule.Cooldown = rule.Window
+	}
+	cooldown, e := parseDuration("cooldown", rule.Cooldown, minWindow, 7*maxWindow)
+	if e != nil {
+		return e
+	}
+	rule.Cooldown = cooldown.String()
+	if rule.Threshold < 0 {
+		return errors.Verify("Threshold must not be negative")
+	}
+	if rule.Threshold == 0 {
+		rule.Threshold = 1
+	}
+	if rule.Samples < 0 || rule.Samples > maxSamples {
+		return errors.Verify(fmt.Sprintf("Samples must be between 0 and %d", maxSamples))
+	}
+	if rule.Samples == 0 {
+		rule.Samples = defSamples
+	}
+	_, e = s.GetSearch(ctx, rule.SearchID)
+	return e
+}
+
+// SaveRule creates a rule or updates its settings. Updating resets the firing state.
+func (s *Serv) SaveRule(ctx context.Context, username string, req SaveRuleReq) (*Rule, *errors.Error) {
+	now := time.Now().Unix()
+	rule := &Rule{ID: req.ID, Owner: username, CreateAt: now}
+	if req.ID == "" {
+		count, err := s.rules.Count(nil)
+		if err != nil {
+			return nil, errors.Sys("Count log rules failed", err)
+		}
+		if count >= maxRules {
+			return nil, errors.Verify(fmt.Sprintf("At most %d log rules", maxRules))
+		}
+		rule.ID = xid.New().String()
+	} else {
+		old, e := s.GetRule(ctx, req.ID)
+		if e != nil {
+			return nil, e
+		}
+		rule.Owner, rule.CreateAt = old.Owner, old.CreateAt
+	}
+	rule.Name = req.Name
+	rule.SearchID = req.SearchID
+	rule.Kind = req.Kind
+	rule.Window = req.Window
+	rule.Threshold = req.Threshold
+	rule.Cooldown = req.Cooldown
+	rule.Samples = req.Samples
+	rule.Enabled = req.Enabled
+	rule.UpdateAt = now
+	if e := s.checkRule(ctx, rule); e != nil {
+		return nil, e
+	}
+
+	if req.ID == "" {
+		if err := s.rules.Put(*rule); err != nil {
+			return nil, errors.Sys("Save log rule failed", err)
+		}
+	} else if err := s.rules.Update(map[string]any{"id": rule.ID}, rule); err != nil {
+		return nil, errors.Sys("Save log rule failed", err)
+	}
+	logger.Info(ctx, fmt.Sprintf("Log rule saved: %s(%s), by: %s", rule.Name, rule.ID, username))
+	return rule, nil
+}
+
+func (s *Serv) DeleteRule(ctx context.Context, username, id string) *errors.Error {
+	rule, e := s.GetRule(ctx, id)
+	if e != nil {
+		return e
+	}
+	if err := s.rules.Delete(map[string]any{"id": id}); err != nil {
+		return errors.Sys("Delete log rule failed", err)
+	}
+	logger.Info(ctx, fmt.Sprintf("Log rule deleted: %s(%s), by: %s", rule.Name, rule.ID, username))
+	return nil
+}
+
+// Check runs the rule over the window ending at now without recording anything.
+func (s *Serv) Check(ctx context.Context, rule Rule, now time.Time) (*Evaluation, *errors.Error) {
+	if e := s.checkRule(ctx, &rule); e != nil {
+		return nil, e
+	}
+	search, e := s.GetSearch(ctx, rule.SearchID)
+	if e != nil {
+		return nil, e
+	}
+	window, _ := time.ParseDuration(rule.Window)
+	opts := searchOptions(search.Options)
+	opts.RootDir = s.RootDir
+	opts.StartTime = now.Add(-window).Format(timeLayout)
+	opts.EndTime = now.Format(timeLayout)
+
+	counted, e := logtool.FacetLogs(ctx, logtool.FacetOptions{SearchOptions: opts, Interval: rule.Window})
+	if e != nil {
+		return nil, e
+	}
+	result := &Evaluation{From: opts.StartTime, To: opts.EndTime, Count: counted.Total, Samples: []logtool.MatchedRecord{}}
+	switch rule.Kind {
+	case KindThreshold:
+		result.Firing = result.Count >= rule.Threshold
+	case KindAbsence:
+		result.Firing = result.Count < rule.Threshold
+	}
+	// a partial count only settles a threshold that is already reached
+	if counted.Truncated && (rule.Kind != KindThreshold || !result.Firing) {
+		return nil, errors.Verify(fmt.Sprintf("Log scan of rule %s ran out of time or bytes, the window was not fully checked", rule.Name))
+	}
+
+	if rule.Kind == KindThreshold && result.Count > 0 {
+		opts.Size = rule.Samples
+		opts.Timeout = int(sampleTimeout / time.Second)
+		page, e := logtool.SearchLogsPageContext(ctx, opts)
+		if e != nil {
+			return nil, e
+		}
+		result.Samples = page.Records
+		result.Truncated = page.Truncated
+	}
+	return result, nil
+}
+
+// Evaluate runs every enabled rule once. It records an event when a rule starts firing, keeps
+// firing past its cooldown, or stops firing.
+func (s *Serv) Evaluate(ctx context.Context) {
+	rules, err := s.rules.Find(1, maxRules, nil, cache.PageSorterAsc("createAt"))
+	if err != nil {
+		logger.Error(ctx, "Find log rules failed", zap.Error(err))
+		return
+	}
+	now := time.Now()
+	for _, rule := range rules.Items {
+		if rule == nil || !rule.Enabled {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		s.evaluateRule(ctx, rule, now)
+	}
+}
+
+func (s *Serv) evaluateRule(ctx context.Context, rule *Rule, now time.Time) {
+	window, _ := time.ParseDuration(rule.Window)
+	// An absence rule needs one full window of history before it may fire.
+	if rule.Kind == KindAbsence && now.Sub(time.Unix(rule.UpdateAt, 0)) < window {
+		return
+	}
+
+	result, e := s.Check(ctx, *rule, now)
+	rule.LastEvalAt = now.Unix()
+	if e != nil {
+		logger.Warn(ctx, "Evaluate log rule failed", zap.String("rule", rule.ID), zap.Error(e))
+		rule.LastError = e.Message
+		if err := s.rules.Update(map[string]any{"id": rule.ID}, rule); err != nil {
+			logger.Error(ctx, "Save log rule failed", zap.String("rule", rule.ID), zap.Error(err))
+		}
+		return
+	}
+	rule.LastError = ""
+	rule.LastCount = result.Count
+
+	cooldown, _ := time.ParseDuration(rule.Cooldown)
+	switch {
+	case result.Firing && (!rule.Firing || now.Sub(time.Unix(rule.LastFiredAt, 0)) >= cooldown):
+		s.record(ctx, rule, StatusFiring, result, now)
+		rule.LastFiredAt = now.Unix()
+	case !result.Firing && rule.Firing:
+		s.record(ctx, rule, StatusResolved, result, now)
+	}
+	rule.Firing = result.Firing
+	if err := s.rules.Update(map[string]any{"id": rule.ID}, rule); err != nil {
+		logger.Error(ctx, "Save log rule failed", zap.String("rule", rule.ID), zap.Error(err))
+	}
+}
+
+func (s *Serv) record(ctx context.Context, rule *Rule, status AlertStatus, result *Evaluation, now time.Time) {
+	event := AlertEvent{
+		ID:        xid.New().String(),
+		RuleID:    rule.ID,
+		RuleName:  rule.Name,
+		SearchID:  rule.SearchID,
+		Kind:      rule.Kind,
+		Status:    status,
+		At:        now.Unix(),
+		From:      result.From,
+		To:        result.To,
+		Count:     result.Count,
+		Threshold: rule.Threshold,
+		Message:   alertMessage(rule, status, result.Count),
+	}
+	if status == StatusFiring {
+		event.Samples = result.Samples
+	}
+	if err := s.alerts.Put(event); err != nil {
+		logger.Error(ctx, "Save log alert failed", zap.String("rule", rule.ID), zap.Error(err))
+		return
+	}
+	logger.Warn(ctx, event.Message, zap.String("rule", rule.ID), zap.String("status", string(status)), zap.Int64("count", result.Count))
+}
+
+func alertMessage(rule *Rule, status AlertStatus, count int64) string {
+	if status == StatusResolved {
+		return fmt.Sprintf("Log rule %s resolved: %d match(es) in the last %s", rule.Name, count, rule.Window)
+	}
+	if rule.Kind == KindAbsence {
+		return fmt.Sprintf("Log rule %s firing: %d match(es) in the last %s, expected at least %d", rule.Name, count, rule.Window, rule.Threshold)
+	}
+	return fmt.Sprintf("Log rule %s firing: %d match(es) in the last %s, threshold %d", rule.Name, count, rule.Window, rule.Threshold)
+}
+
+func (s *Serv) AlertPage(ctx context.Context, req AlertPageReq) (*cache.PageCache[AlertEvent], *errors.Error) {
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.Size <= 0 {
+		req.Size = 20
+	}
+	cond := map[string]any{}
+	if req.Start > 0 || req.End > 0 {
+		at := map[string]any{}
+		if req.Start > 0 {
+			at["$gte"] = req.Start
+		}
+		if req.End > 0 {
+			at["$lte"] = req.End
+		}
+		cond["at"] = at
+	}
+	if req.RuleID != "" {
+		cond["ruleId"] = req.RuleID
+	}
+	if req.Status != "" {
+		cond["status"] = string(req.Status)
+	}
+	result, err := s.alerts.Find(req.Page, req.Size, cond, cache.PageSorterDesc("at"))
+	if err != nil {
+		logger.Error(ctx, "Page log alerts failed", zap.Error(err))
+		return nil, errors.Sys("Page log alerts failed", err)
+	}
+	return result, nil
+}
+
+func (s *Serv) Clear(ctx context.Context) error {
+	expiration := time.Now().Add(-maxAlertAge).Unix()
+	if err := s.alerts.Delete(map[string]any{"at": map[string]any{"$lt": expiration}}); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package logtool
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logExt     = ".jsonl"
+	archiveExt = ".jsonl.gz"
+)
+
+// backupTimeRegexp matches the rotation time lumberjack puts in backup names, in UTC,
+// e.g. rest-2024-05-01T09-00-00.000.jsonl.gz.
+var backupTimeRegexp = regexp.MustCompile(`(\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2}\.\d{3})\.jsonl(\.gz)?$`)
+
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// isLogFile reports whether the file is a log, plain or a gzip archive.
+func isLogFile(name string) bool {
+	return strings.HasSuffix(name, logExt) || strings.HasSuffix(name, archiveExt)
+}
+
+func isArchive(name string) bool {
+	return strings.HasSuffix(name, archiveExt)
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	_ = g.Reader.Close()
+	return g.f.Close()
+}
+
+// openLog opens a log for reading from the start, decompressing archives on the fly.
+func openLog(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !isArchive(path) {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &gzipFile{Reader: zr, f: f}, nil
+}
+
+// backupTime returns the rotation time in the backup name as a record time string.
+// No record in the backup is later than it.
+func backupTime(name string) (string, bool) {
+	m := backupTimeRegexp.FindStringSubmatch(name)
+	if m == nil {
+		return "", false
+	}
+	t, err := time.ParseInLocation(backupTimeFormat, m[1], time.UTC)
+	if err != nil {
+		return "", false
+	}
+	return t.Local().Format("2006-01-02 15:04:05.000"), true
+}
+
+type archiveBounds struct {
+	size    int64
+	modTime int64
+	first   string
+	last    string
+}
+
+// archives never change once written, their bounds are read once per file
+var archiveBoundsCache = struct {
+	sync.Mutex
+	m map[string]archiveBounds
+}{m: make(map[string]archiveBounds)}
+
+// readArchiveTimeBounds reads the first record of the archive, decompressing only its head,
+// and takes the last time from the rotation time in the name. Archives without one are read
+// through once.
+func readArchiveTimeBounds(path string) (string, string, bool) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return "", "", false
+	}
+	archiveBoundsCache.Lock()
+	cached, ok := archiveBoundsCache.m[path]
+	archiveBoundsCache.Unlock()
+	if ok && cached.size == st.Size() && cached.modTime == st.ModTime().UnixNano() {
+		return cached.first, cached.last, true
+	}
+
+	r, err := openLog(path)
+	if err != nil {
+		return "", "", false
+	}
+	defer r.Close()
+
+	parser := parserFor(path)
+	reader := bufio.NewReader(r)
+	first := ""
+	last, named := backupTime(st.Name())
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > maxLineSize && !endsWithNewline(line) {
+			skipRestOfLine(reader)
+		} else if strings.TrimSpace(line) != "" {
+			if rec := parser.Parse(line); rec != nil && normalizeRecordTimeString(rec.Time) != "" {
+				t := normalizeRecordTimeString(rec.Time)
+				if first == "" {
+					first = t
+				}
+				if !named {
+					last = t
+				}
+			}
+		}
+		if err != nil || (first != "" && named) {
+			break
+		}
+	}
+	if first == "" || last == "" {
+		return "", "", false
+	}
+
+	archiveBoundsCache.Lock()
+	archiveBoundsCache.m[path] = archiveBounds{size: st.Size(), modTime: st.ModTime().UnixNano(), first: first, last: last}
+	archiveBoundsCache.Unlock()
+	return first, last, true
+}
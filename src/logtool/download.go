@@ -0,0 +1,174 @@
+package logtool
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type DownloadOptions struct {
+	Path      string `json:"path" form:"path"`
+	StartTime string `json:"startTime" form:"startTime"` // with EndTime, serves only the lines of that period
+	EndTime   string `json:"endTime" form:"endTime"`
+	Gzip      bool   `json:"gzip" form:"gzip"`         // compress on the fly into a .gz file, which cannot be resumed
+	Unmasked  bool   `json:"unmasked" form:"unmasked"` // send the file as it is, needs logs:unmasked
+	RootDir   string `json:"-" form:"-"`
+}
+
+// logRoots are the directories log files may be read from: the .logs directory and the ones
+// listed in om.log.roots, separated by commas.
+func logRoots(rootDir string) []string {
+	roots := []string{getLogDir(rootDir)}
+	for _, root := range strings.Split(configure.GetString("om.log.roots", ""), ",") {
+		if root = strings.TrimSpace(root); root != "" {
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// resolveLogPath follows the symlinks of the path and accepts it only when the file it ends at is
+// a log file under a log root, or a file of a registered source. It returns the resolved path.
+func resolveLogPath(rootDir, path string) (string, *errors.Error) {
+	if strings.TrimSpace(path) == "" || strings.Contains(path, "..") {
+		return "", errors.Verify("invalid log file")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", errors.Verify("invalid log file")
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if os.IsNotExist(err) {
+		return "", errors.Verify("log file does not exist")
+	}
+	if err != nil {
+		return "", errors.Verify("invalid log file")
+	}
+	if st, err := os.Stat(resolved); err != nil || !st.Mode().IsRegular() {
+		return "", errors.Verify("invalid log file")
+	}
+
+	if isLogFile(resolved) {
+		for _, root := range logRoots(rootDir) {
+			if within(root, resolved) {
+				return resolved, nil
+			}
+		}
+	}
+	for _, src := range Sources() {
+		pattern := src.pattern(rootDir)
+		ok, _ := filepath.Match(pattern, resolved)
+		// the directory of the source may itself be a symlink
+		if dir, err := filepath.EvalSymlinks(filepath.Dir(pattern)); !ok && err == nil {
+			ok, _ = filepath.Match(filepath.Join(dir, filepath.Base(pattern)), resolved)
+		}
+		if ok {
+			// the resolved path is what gets read, so it keeps the parser of its source
+			rememberSourceFile(resolved, src.parser)
+			return resolved, nil
+		}
+	}
+	return "", errors.Verify("invalid log file")
+}
+
+// within reports whether the resolved path lies under the root, once the root's symlinks are resolved too.
+func within(root, resolved string) bool {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || filepath.IsAbs(rel) {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// DownloadLogs serves a log file, or the lines of a period of it. The file is sent as it is only
+// when the request may see unmasked records; plain files then answer Range requests, so a large
+// download can be resumed. Otherwise, and for periods, the lines are streamed through the redactor.
+func DownloadLogs(ctx *gin.Context, opts DownloadOptions) *errors.Error {
+	path, e := resolveLogPath(opts.RootDir, opts.Path)
+	if e != nil {
+		return e
+	}
+	search := SearchOptions{StartTime: opts.StartTime, EndTime: opts.EndTime}
+	normalizeTimeBounds(&search)
+	if search.TimeBoundsInvalid {
+		return errors.Verify("invalid time range")
+	}
+	search.redact = ViewRedactor(ctx, opts.Unmasked)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Verify(fmt.Sprintf("open log file error: %v", err))
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return errors.Verify(fmt.Sprintf("unable to get file info: %v", err))
+	}
+
+	name := filepath.Base(path)
+	if search.redact != nil || search.StartBound != "" || search.EndBound != "" {
+		stopAfter := ""
+		if search.EndBound != "" {
+			stopAfter = shiftTime(search.EndBound, boundSlack)
+		}
+		streamDownload(ctx, strings.TrimSuffix(name, ".gz"), opts.Gzip, func(w io.Writer) error {
+			return exportSlice(w, path, search, stopAfter, &scanBudget{ctx: ctx.Request.Context()})
+		})
+		return nil
+	}
+	if opts.Gzip && !isArchive(path) {
+		streamDownload(ctx, name, true, func(w io.Writer) error {
+			_, err := io.Copy(w, f)
+			return err
+		})
+		return nil
+	}
+
+	if isArchive(path) {
+		ctx.Header("Content-Type", "application/gzip")
+	} else {
+		ctx.Header("Content-Type", "application/octet-stream")
+	}
+	ctx.Header("Content-Disposition", "attachment; filename="+name)
+	http.ServeContent(ctx.Writer, ctx.Request, name, st.ModTime(), f)
+	return nil
+}
+
+// streamDownload sends what write produces as an attachment, gzip-compressed when asked.
+func streamDownload(ctx *gin.Context, name string, compress bool, write func(io.Writer) error) {
+	if compress {
+		name += ".gz"
+		ctx.Header("Content-Type", "application/gzip")
+	} else {
+		ctx.Header("Content-Type", "application/octet-stream")
+	}
+	ctx.Header("Content-Disposition", "attachment; filename="+name)
+	ctx.Status(http.StatusOK)
+
+	var w io.Writer = ctx.Writer
+	if compress {
+		zw := gzip.NewWriter(ctx.Writer)
+		defer zw.Close()
+		w = zw
+	}
+	if err := write(w); err != nil {
+		// the response has started, the error can only be logged
+		logger.Error(ctx, "Download log file failed", zap.Error(err))
+	}
+}
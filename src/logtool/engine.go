@@ -0,0 +1,144 @@
+package logtool
+
+import (
+	"context"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkEvery is how many lines are read between two checks of the deadline.
+const checkEvery = 256
+
+var (
+	searchWorkers  = 4
+	searchTimeout  = 10 * time.Second
+	searchMaxBytes = int64(1 << 30)
+)
+
+func init() {
+	searchWorkers = configure.GetInt("om.log.search_workers", min(4, runtime.NumCPU()))
+	if searchWorkers < 1 {
+		searchWorkers = 1
+	}
+	searchTimeout = configure.GetDuration("om.log.search_timeout", searchTimeout)
+	searchMaxBytes = int64(configure.GetInt("om.log.search_max_bytes", int(searchMaxBytes)))
+}
+
+// scanBudget bounds one search by time and bytes read. Once it runs out the scanners
+// stop where they are and the search returns what it found, marked truncated.
+// A nil budget is unlimited.
+type scanBudget struct {
+	ctx      context.Context
+	maxBytes int64
+	bytes    atomic.Int64
+	out      atomic.Bool
+}
+
+// newScanBudget ties the budget to ctx, the configured limits and, when shorter, the requested timeout.
+func newScanBudget(ctx context.Context, timeout time.Duration) (*scanBudget, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	limit := searchTimeout
+	if timeout > 0 && (limit <= 0 || timeout < limit) {
+		limit = timeout
+	}
+	cancel := context.CancelFunc(func() {})
+	if limit > 0 {
+		ctx, cancel = context.WithTimeout(ctx, limit)
+	}
+	return &scanBudget{ctx: ctx, maxBytes: searchMaxBytes}, cancel
+}
+
+// read counts n bytes and reports whether scanning may go on.
+func (b *scanBudget) read(n int) bool {
+	if b == nil {
+		return true
+	}
+	if b.bytes.Add(int64(n)) > b.maxBytes && b.maxBytes > 0 {
+		b.out.Store(true)
+	}
+	return !b.out.Load()
+}
+
+// alive checks the deadline and cancellation, which is slower than read.
+func (b *scanBudget) alive() bool {
+	if b == nil {
+		return true
+	}
+	if b.ctx.Err() != nil {
+		b.out.Store(true)
+	}
+	return !b.out.Load()
+}
+
+func (b *scanBudget) exhausted() bool {
+	return b != nil && b.out.Load()
+}
+
+func (b *scanBudget) used() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.bytes.Load()
+}
+
+// scanFiles reads the files with a pool of workers. Each file is read into its own selector
+// and merged into sel when done, so the merge keeps the time order whatever the file order.
+// Files are handed out in order and skipped when sel already rules them out.
+func scanFiles(files []LogFileInfo, opts SearchOptions, sel *selector, order string, cursor *searchCursor, budget *scanBudget, progress *SearchProgress) *errors.Error {
+	var (
+		mu    sync.Mutex
+		next  int
+		first *errors.Error
+		wg    sync.WaitGroup
+	)
+	take := func() (LogFileInfo, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		for next < len(files) && first == nil && budget.alive() {
+			file := files[next]
+			next++
+			if sel.canSkip(file) {
+				progress.SkippedFiles++
+				continue
+			}
+			return file, true
+		}
+		return LogFileInfo{}, false
+	}
+
+	workers := min(searchWorkers, len(files))
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				file, ok := take()
+				if !ok {
+					return
+				}
+				local := newSelector(order, cursor, sel.limit)
+				e := scanLogFile(file.Path, opts, local.offer, budget)
+
+				mu.Lock()
+				if e != nil && first == nil {
+					first = e
+				}
+				for _, m := range local.items {
+					sel.offer(m)
+				}
+				if !budget.exhausted() {
+					progress.ScannedFiles++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return first
+}
@@ -0,0 +1,240 @@
+package logtool
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"go.uber.org/zap"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ExportNDJSON = "ndjson"
+	ExportCSV    = "csv"
+	ExportZip    = "zip"
+
+	exportFlushEvery = 500 // records between two flushes of the response
+)
+
+var exportBaseColumns = []string{"time", "level", "category", "traceID", "msg", "error", "path", "line"}
+
+type ExportOptions struct {
+	SearchOptions
+	Format  string   `json:"format" form:"format"`   // ndjson (default), csv or zip
+	Columns []string `json:"columns" form:"columns"` // Data keys added as csv columns after the fixed ones
+}
+
+// ExportLogs streams every record matching the options, not limited by Size, file by file with
+// the oldest files first. ndjson and csv are gzip-encoded when the client accepts it; zip holds the
+// raw lines of each file within the time range, one entry per file.
+func ExportLogs(ctx *gin.Context, opts ExportOptions) *errors.Error {
+	format := strings.ToLower(strings.TrimSpace(opts.Format))
+	if format == "" {
+		format = ExportNDJSON
+	}
+	if format != ExportNDJSON && format != ExportCSV && format != ExportZip {
+		return errors.Verify(fmt.Sprintf("invalid format: %s, use ndjson, csv or zip", opts.Format))
+	}
+	search := opts.SearchOptions
+	normalizeTimeBounds(&search)
+	if search.TimeBoundsInvalid {
+		return errors.Verify("invalid time range")
+	}
+	if format == ExportZip && search.StartBound == "" && search.EndBound == "" {
+		return errors.Verify("zip export needs a startTime or endTime")
+	}
+	if e := compileQuery(&search); e != nil {
+		return e
+	}
+	files, err := listLogFileInfos(search)
+	if err != nil {
+		return errors.Verify(err.Error())
+	}
+	for i := range files {
+		if files[i].FirstTime == "" {
+			files[i].FirstTime, files[i].LastTime, _ = readLogTimeBounds(files[i].Path)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].FirstTime != files[j].FirstTime {
+			return files[i].FirstTime < files[j].FirstTime
+		}
+		return files[i].Path < files[j].Path
+	})
+
+	name := "logs-" + time.Now().Format("20060102-150405") + "." + format
+	ctx.Header("Content-Disposition", "attachment; filename="+name)
+	ctx.Header("Cache-Control", "no-cache")
+	budget := &scanBudget{ctx: ctx.Request.Context()}
+
+	if format == ExportZip {
+		ctx.Header("Content-Type", "application/zip")
+		ctx.Status(200)
+		err = exportZip(ctx.Writer, files, search, budget)
+	} else {
+		var w io.Writer = ctx.Writer
+		if strings.Contains(ctx.GetHeader("Accept-Encoding"), "gzip") {
+			ctx.Header("Content-Encoding", "gzip")
+			ctx.Header("Vary", "Accept-Encoding")
+			zw := gzip.NewWriter(ctx.Writer)
+			defer zw.Close()
+			w = zw
+		}
+		if format == ExportCSV {
+			ctx.Header("Content-Type", "text/csv; charset=utf-8")
+		} else {
+			ctx.Header("Content-Type", "application/x-ndjson")
+		}
+		ctx.Status(200)
+		err = exportRecords(w, ctx.Writer, format, opts.Columns, files, search, budget)
+	}
+	if err != nil {
+		// the response has started, the error can only be logged
+		logger.Error(ctx, "Export logs failed", zap.Error(err))
+	}
+	return nil
+}
+
+// flushWriter is the writer a gzip or csv writer sits on, flushed so the client sees progress.
+type flushWriter interface {
+	io.Writer
+	Flush()
+}
+
+func exportRecords(w io.Writer, out flushWriter, format string, columns []string, files []LogFileInfo, opts SearchOptions, budget *scanBudget) error {
+	var (
+		count   int
+		werr    error
+		csvw    *csv.Writer
+		encoder *json.Encoder
+	)
+	flush := func() {
+		if csvw != nil {
+			csvw.Flush()
+		}
+		if f, ok := w.(interface{ Flush() error }); ok {
+			_ = f.Flush()
+		}
+		out.Flush()
+	}
+	if format == ExportCSV {
+		csvw = csv.NewWriter(w)
+		werr = csvw.Write(append(append([]string{}, exportBaseColumns...), columns...))
+	} else {
+		encoder = json.NewEncoder(w)
+	}
+
+	for _, file := range files {
+		if werr != nil || !budget.alive() {
+			break
+		}
+		category := file.Category
+		e := scanLogFile(file.Path, opts, func(m MatchedRecord) {
+			if werr != nil {
+				return
+			}
+			if csvw != nil {
+				r := m.Record
+				row := []string{r.Time, r.Level, category, r.TraceID, r.Msg, r.Error, m.FilePath, fmt.Sprint(m.LineNumber)}
+				for _, c := range columns {
+					v, _ := fieldValue(r, c)
+					row = append(row, v)
+				}
+				werr = csvw.Write(row)
+			} else {
+				werr = encoder.Encode(m)
+			}
+			if count++; count%exportFlushEvery == 0 {
+				flush()
+			}
+		}, budget)
+		if e != nil {
+			logger.Warn(nil, "Export skipped a log file", zap.String("path", file.Path), zap.Error(e))
+		}
+	}
+	flush()
+	if werr == nil && csvw != nil {
+		werr = csvw.Error()
+	}
+	return werr
+}
+
+// exportZip writes the lines of each file whose time falls within the range, masked by the redactor.
+func exportZip(out flushWriter, files []LogFileInfo, opts SearchOptions, budget *scanBudget) error {
+	zw := zip.NewWriter(out)
+	stopAfter := ""
+	if opts.EndBound != "" {
+		stopAfter = shiftTime(opts.EndBound, boundSlack)
+	}
+	for _, file := range files {
+		if !budget.alive() {
+			break
+		}
+		if (opts.EndBound != "" && file.FirstTime > opts.EndBound) || (opts.StartBound != "" && file.LastTime != "" && file.LastTime < opts.StartBound) {
+			continue
+		}
+		entry, err := zw.Create(filepath.ToSlash(filepath.Join(file.Category, strings.TrimSuffix(file.Name, ".gz"))))
+		if err != nil {
+			return err
+		}
+		if err = exportSlice(entry, file.Path, opts, stopAfter, budget); err != nil {
+			return err
+		}
+		out.Flush()
+	}
+	return zw.Close()
+}
+
+// exportSlice writes the lines of the file within the range, together with the lines without a
+// time that follow them, and stops at the first line after stopAfter.
+func exportSlice(w io.Writer, path string, opts SearchOptions, stopAfter string, budget *scanBudget) error {
+	r, err := openLog(path)
+	if err != nil {
+		logger.Warn(nil, "Export skipped a log file", zap.String("path", path), zap.Error(err))
+		return nil
+	}
+	defer r.Close()
+	if idx := getIndex(path); idx != nil && opts.StartBound != "" {
+		offset, _ := idx.seekTime(opts.StartBound)
+		if s, ok := r.(io.Seeker); ok {
+			if _, err = s.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+		}
+	}
+
+	parser := parserFor(path)
+	reader := bufio.NewReader(r)
+	inside := opts.StartBound == ""
+	for n := 1; ; n++ {
+		line, err := reader.ReadString('\n')
+		if !budget.read(len(line)) || (n%checkEvery == 0 && !budget.alive()) {
+			return nil
+		}
+		if t := lineTime(parser, line); t != "" {
+			if stopAfter != "" && t > stopAfter {
+				return nil
+			}
+			inside = (opts.StartBound == "" || t >= opts.StartBound) && (opts.EndBound == "" || t <= opts.EndBound)
+		}
+		// lines without a time, such as stack traces, go with the line before them
+		if inside && line != "" {
+			if _, werr := io.WriteString(w, opts.redact.Line(line)); werr != nil {
+				return werr
+			}
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
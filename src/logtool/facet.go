@@ -0,0 +1,282 @@
+package logtool
+
+import (
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig/utils/errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	recordTimeLayout = "2006-01-02 15:04:05.000"
+
+	defFacetTop    = 10
+	maxFacetTop    = 100
+	maxFacetValues = 10000 // distinct values kept per facet, later ones are counted in Other
+	maxBuckets     = 10000
+	autoBuckets    = 120 // aimed number of buckets when no interval is given
+)
+
+// FacetFieldLevel and FacetFieldCategory are always counted; any other facet is a Data key.
+const (
+	FacetFieldLevel    = "level"
+	FacetFieldCategory = "category"
+)
+
+var autoIntervals = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+}
+
+type FacetOptions struct {
+	SearchOptions
+	Interval string   `json:"interval" form:"interval"` // histogram granularity, e.g. 1m or 1h, picked from the time range when empty
+	Top      int      `json:"top" form:"top"`           // values per facet, 10 by default
+	Fields   []string `json:"fields" form:"fields"`     // Data keys to count besides level and category, e.g. uri, status
+}
+
+type FacetResult struct {
+	Total     int64                   `json:"total"`
+	Interval  string                  `json:"interval"`
+	Histogram []HistogramBucket       `json:"histogram"`
+	Facets    map[string]*FacetCounts `json:"facets"`
+	Progress  SearchProgress          `json:"progress"`
+	Truncated bool                    `json:"truncated"` // the scan ran out of time or bytes, counts are partial
+}
+
+type HistogramBucket struct {
+	Time       string           `json:"time"` // start of the bucket
+	Count      int64            `json:"count"`
+	Levels     map[string]int64 `json:"levels"`
+	Categories map[string]int64 `json:"categories"`
+}
+
+type FacetCounts struct {
+	Values  []FacetValue `json:"values"`  // top values, most frequent first
+	Other   int64        `json:"other"`   // records with a value outside the top ones
+	Missing int64        `json:"missing"` // records without the field
+}
+
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+type facetCounter struct {
+	counts  map[string]int64
+	other   int64
+	missing int64
+}
+
+func (c *facetCounter) add(value string, ok bool) {
+	if !ok {
+		c.missing++
+		return
+	}
+	if _, seen := c.counts[value]; !seen && len(c.counts) >= maxFacetValues {
+		c.other++
+		return
+	}
+	c.counts[value]++
+}
+
+func (c *facetCounter) top(n int) *FacetCounts {
+	out := &FacetCounts{Values: make([]FacetValue, 0, len(c.counts)), Other: c.other, Missing: c.missing}
+	for v, n := range c.counts {
+		out.Values = append(out.Values, FacetValue{Value: v, Count: n})
+	}
+	sort.Slice(out.Values, func(i, j int) bool {
+		if out.Values[i].Count != out.Values[j].Count {
+			return out.Values[i].Count > out.Values[j].Count
+		}
+		return out.Values[i].Value < out.Values[j].Value
+	})
+	if len(out.Values) > n {
+		for _, v := range out.Values[n:] {
+			out.Other += v.Count
+		}
+		out.Values = out.Values[:n]
+	}
+	return out
+}
+
+// bucketStart truncates t to the interval in local time, so days start at local midnight.
+func bucketStart(t time.Time, interval time.Duration) time.Time {
+	_, offset := t.Zone()
+	sec := int64(interval / time.Second)
+	unix := t.Unix() + int64(offset)
+	unix -= ((unix % sec) + sec) % sec
+	return time.Unix(unix-int64(offset), 0).In(t.Location())
+}
+
+func parseRecordTime(s string) (time.Time, bool) {
+	t, err := time.ParseInLocation(recordTimeLayout, normalizeRecordTimeString(s), time.Local)
+	return t, err == nil
+}
+
+// facetInterval parses the requested interval, or picks one giving about autoBuckets buckets over first..last.
+func facetInterval(interval, first, last string) (time.Duration, *errors.Error) {
+	var span time.Duration
+	from, okFrom := parseRecordTime(first)
+	to, okTo := parseRecordTime(last)
+	if okFrom && okTo && to.After(from) {
+		span = to.Sub(from)
+	}
+
+	if strings.TrimSpace(interval) != "" {
+		d, err := time.ParseDuration(strings.TrimSpace(interval))
+		if err != nil || d < time.Second || d%time.Second != 0 {
+			return 0, errors.Verify(fmt.Sprintf("invalid interval: %s, use whole seconds such as 30s, 1m or 1h", interval))
+		}
+		if span/d > maxBuckets {
+			return 0, errors.Verify(fmt.Sprintf("interval %s is too small for the time range, at most %d buckets", interval, maxBuckets))
+		}
+		return d, nil
+	}
+	for _, d := range autoIntervals {
+		if span/d <= autoBuckets {
+			return d, nil
+		}
+	}
+	return autoIntervals[len(autoIntervals)-1], nil
+}
+
+// FacetLogs counts the records matching the search options in one pass over the files: a time
+// histogram split by level and category, and the top values of level, category and each requested Data key.
+// The scan stops when ctx ends or the search budget runs out, leaving partial counts.
+func FacetLogs(ctx context.Context, opts FacetOptions) (*FacetResult, *errors.Error) {
+	search := opts.SearchOptions
+	normalizeTimeBounds(&search)
+	if e := compileQuery(&search); e != nil {
+		return nil, e
+	}
+	top := opts.Top
+	if top <= 0 {
+		top = defFacetTop
+	}
+	if top > maxFacetTop {
+		top = maxFacetTop
+	}
+	fields := make([]string, 0, len(opts.Fields))
+	for _, f := range opts.Fields {
+		if f = strings.TrimSpace(f); f != "" && f != FacetFieldLevel && f != FacetFieldCategory {
+			fields = append(fields, f)
+		}
+	}
+
+	result := &FacetResult{Histogram: []HistogramBucket{}, Facets: map[string]*FacetCounts{}}
+	if search.TimeBoundsInvalid {
+		return result, nil
+	}
+	files, err := listLogFileInfos(search)
+	if err != nil {
+		return nil, errors.Verify(err.Error())
+	}
+
+	// The histogram spans the searched range, or the files when it is open.
+	first, last := search.StartBound, search.EndBound
+	for i := range files {
+		result.Progress.Bytes += files[i].Size
+		if files[i].FirstTime == "" {
+			files[i].FirstTime, files[i].LastTime, _ = readLogTimeBounds(files[i].Path)
+		}
+		if search.StartBound == "" && files[i].FirstTime != "" && (first == "" || files[i].FirstTime < first) {
+			first = files[i].FirstTime
+		}
+		if search.EndBound == "" && files[i].LastTime > last {
+			last = files[i].LastTime
+		}
+	}
+	interval, e := facetInterval(opts.Interval, first, last)
+	if e != nil {
+		return nil, e
+	}
+	result.Interval = interval.String()
+	result.Progress.Files = len(files)
+	budget, cancel := newScanBudget(ctx, time.Duration(search.Timeout)*time.Second)
+	defer cancel()
+
+	buckets := make(map[int64]*HistogramBucket)
+	counters := map[string]*facetCounter{
+		FacetFieldLevel:    {counts: map[string]int64{}},
+		FacetFieldCategory: {counts: map[string]int64{}},
+	}
+	for _, f := range fields {
+		counters[f] = &facetCounter{counts: map[string]int64{}}
+	}
+
+	for _, file := range files {
+		if !budget.alive() {
+			continue
+		}
+		category := file.Category
+		e = scanLogFile(file.Path, search, func(m MatchedRecord) {
+			r := m.Record
+			result.Total++
+			counters[FacetFieldLevel].add(r.Level, r.Level != "")
+			counters[FacetFieldCategory].add(category, true)
+			for _, f := range fields {
+				v, ok := fieldValue(r, f)
+				counters[f].add(v, ok)
+			}
+
+			t, ok := parseRecordTime(r.Time)
+			if !ok {
+				return
+			}
+			start := bucketStart(t, interval)
+			b := buckets[start.Unix()]
+			if b == nil {
+				if len(buckets) >= maxBuckets {
+					return
+				}
+				b = &HistogramBucket{Time: start.Format(recordTimeLayout), Levels: map[string]int64{}, Categories: map[string]int64{}}
+				buckets[start.Unix()] = b
+			}
+			b.Count++
+			b.Levels[r.Level]++
+			b.Categories[category]++
+		}, budget)
+		if e != nil {
+			return nil, e
+		}
+		result.Progress.ScannedFiles++
+	}
+	result.Progress.ScannedBytes = budget.used()
+	result.Truncated = budget.exhausted()
+
+	result.Histogram = fillHistogram(buckets, interval)
+	for name, c := range counters {
+		result.Facets[name] = c.top(top)
+	}
+	return result, nil
+}
+
+// nextBucket steps one and a half intervals and truncates, so days longer or shorter
+// than 24h around a daylight saving change still land on the next local midnight.
+func nextBucket(t time.Time, interval time.Duration) time.Time {
+	return bucketStart(t.Add(interval+interval/2), interval)
+}
+
+// fillHistogram sorts the buckets and adds empty ones for the gaps, so the histogram can be drawn as is.
+func fillHistogram(buckets map[int64]*HistogramBucket, interval time.Duration) []HistogramBucket {
+	keys := make([]int64, 0, len(buckets))
+	for k := range buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	out := make([]HistogramBucket, 0, len(keys))
+	for i, k := range keys {
+		if i > 0 {
+			for t := nextBucket(time.Unix(keys[i-1], 0), interval); t.Unix() < k && len(out) < maxBuckets; t = nextBucket(t, interval) {
+				out = append(out, HistogramBucket{Time: t.Format(recordTimeLayout), Levels: map[string]int64{}, Categories: map[string]int64{}})
+			}
+		}
+		out = append(out, *buckets[k])
+	}
+	return out
+}
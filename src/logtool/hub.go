@@ -0,0 +1,168 @@
+package logtool
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+)
+
+// tailHub follows the files under one log directory once, however many monitors are open,
+// and fans every new record out to the subscribers whose filters it matches.
+type tailHub struct {
+	key    string
+	mu     sync.Mutex
+	subs   map[*subscriber]struct{}
+	cancel context.CancelFunc
+}
+
+var hubs = struct {
+	sync.Mutex
+	m map[string]*tailHub
+}{m: make(map[string]*tailHub)}
+
+// subscriber is one monitor connection. Records queue up to monitorBuffer; when the client
+// falls behind the oldest are dropped and counted, so a slow client never holds up the hub.
+type subscriber struct {
+	hub        *tailHub
+	opts       SearchOptions
+	categories map[string]bool
+
+	mu      sync.Mutex
+	queue   []MatchedRecord
+	dropped int64
+	err     error
+	notify  chan struct{} // signalled when records arrive
+	done    chan struct{} // closed when the hub stops on an error
+}
+
+// DroppedNotice tells a monitor client that records were skipped because it read too slowly.
+type DroppedNotice struct {
+	Dropped int64 `json:"dropped"`
+}
+
+// subscribe joins the hub of the log directory, starting it for the first subscriber.
+func subscribe(opts SearchOptions) (*subscriber, error) {
+	key, err := filepath.Abs(getLogDir(opts.RootDir))
+	if err != nil {
+		return nil, err
+	}
+	sub := &subscriber{
+		opts:       opts,
+		categories: make(map[string]bool),
+		notify:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	for _, cat := range opts.Categories {
+		sub.categories[cat] = true
+	}
+
+	hubs.Lock()
+	defer hubs.Unlock()
+	hub := hubs.m[key]
+	if hub == nil {
+		t, err := newTailer(opts.RootDir, nil)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		hub = &tailHub{key: key, subs: make(map[*subscriber]struct{}), cancel: cancel}
+		hubs.m[key] = hub
+		go hub.run(ctx, t)
+	}
+	sub.hub = hub
+	hub.mu.Lock()
+	hub.subs[sub] = struct{}{}
+	hub.mu.Unlock()
+	return sub, nil
+}
+
+func (h *tailHub) run(ctx context.Context, t *tailer) {
+	defer t.close()
+	err := t.run(ctx, h.publish, monitorHeartbeat)
+	if err == nil {
+		return
+	}
+	// the watcher failed: close every subscriber and let the next one start afresh
+	hubs.Lock()
+	if hubs.m[h.key] == h {
+		delete(hubs.m, h.key)
+	}
+	hubs.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		sub.fail(fmt.Errorf("watcher error: %v", err))
+	}
+}
+
+func (h *tailHub) publish(cat string, batch []MatchedRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		if len(sub.categories) > 0 && !sub.categories[cat] {
+			continue
+		}
+		sub.offer(batch)
+	}
+}
+
+func (s *subscriber) offer(batch []MatchedRecord) {
+	s.mu.Lock()
+	added := false
+	for _, m := range batch {
+		// the batch is shared by every subscriber, redaction works on a copy
+		m.Record = s.opts.redact.Record(m.Record)
+		if !matchRecord(*m.Record, s.opts) {
+			continue
+		}
+		if len(s.queue) >= monitorBuffer {
+			s.queue = s.queue[1:]
+			s.dropped++
+		}
+		s.queue = append(s.queue, m)
+		added = true
+	}
+	s.mu.Unlock()
+	if added {
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// take returns the queued records and how many were dropped since the last take.
+func (s *subscriber) take() ([]MatchedRecord, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records, dropped := s.queue, s.dropped
+	s.queue, s.dropped = nil, 0
+	return records, dropped
+}
+
+func (s *subscriber) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+		close(s.done)
+	}
+}
+
+// close leaves the hub, stopping it when nobody is left.
+func (s *subscriber) close() {
+	hubs.Lock()
+	defer hubs.Unlock()
+	h := s.hub
+	h.mu.Lock()
+	delete(h.subs, s)
+	empty := len(h.subs) == 0
+	h.mu.Unlock()
+	if empty {
+		if hubs.m[h.key] == h {
+			delete(hubs.m, h.key)
+		}
+		h.cancel()
+	}
+}
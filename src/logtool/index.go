@@ -0,0 +1,500 @@
+package logtool
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	indexDirName   = ".index"
+	indexExt       = ".idx"
+	indexVersion   = 2
+	indexMarkEvery = 64 * 1024 // bytes between two time marks
+	indexHeadSize  = 256       // leading bytes kept to notice a file replaced under the same name
+	indexSegments  = 256       // segments an index file may have before it is rewritten as one
+)
+
+// indexEntry locates one line of the log file.
+type indexEntry struct {
+	Offset int64
+	Line   int64 // number of the line, from 1
+}
+
+// indexMark is a sparse seek point: Line lines and no record later than MaxTime precede Offset.
+type indexMark struct {
+	Offset  int64
+	Line    int64
+	MaxTime string
+}
+
+// logIndex is the sidecar index of one .jsonl file, stored under .logs/.index/<category>/<name>.idx.
+// It covers the complete lines in [0, Size); anything written later is scanned as usual.
+type logIndex struct {
+	Version int
+	Head    []byte
+	Size    int64
+	Lines   int64
+	MaxTime string
+	Traces  map[string][]indexEntry
+	Marks   []indexMark
+
+	mu       sync.RWMutex
+	segments int  // segments in the index file
+	broken   bool // the index file has a bad segment and must be rewritten
+}
+
+// indexSegment is what one run adds to an index: the lines in [From, Size). The index file is
+// the list of its segments, each a gob prefixed with its length, so a run only appends.
+type indexSegment struct {
+	Version int
+	Head    []byte // first segment only
+	From    int64
+	Size    int64
+	Lines   int64
+	MaxTime string
+	Traces  map[string][]indexEntry
+	Marks   []indexMark
+}
+
+// indexes keeps the om.log.index_cache most recently used indexes in memory.
+var indexes = struct {
+	sync.Mutex
+	m     map[string]*list.Element
+	order *list.List
+}{m: make(map[string]*list.Element), order: list.New()}
+
+type cachedIndex struct {
+	path string
+	idx  *logIndex
+}
+
+var indexCacheSize = 32
+
+// indexWrite serializes the runs, so two never append to the same index file.
+var indexWrite sync.Mutex
+
+func init() {
+	if n := configure.GetInt("om.log.index_cache", indexCacheSize); n > 0 {
+		indexCacheSize = n
+	}
+	if !configure.GetBool("om.log.index", true) {
+		return
+	}
+	interval := configure.GetDuration("om.log.index_interval", 30*time.Second)
+	if interval < time.Second {
+		interval = time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := IndexLogs(""); err != nil {
+				logger.Warn(context.Background(), "Index logs failed", zap.Error(err))
+			}
+		}
+	}()
+}
+
+// indexPathOf returns the sidecar path of a log file below a .logs directory, or "" when it has none.
+func indexPathOf(logPath string) string {
+	abs, err := filepath.Abs(logPath)
+	if err != nil || isArchive(abs) || !strings.HasSuffix(abs, logExt) {
+		return ""
+	}
+	dir, rel := filepath.Dir(abs), filepath.Base(abs)
+	for dir != filepath.Dir(dir) {
+		if filepath.Base(dir) == defLogDir {
+			if strings.HasPrefix(rel, indexDirName+string(filepath.Separator)) {
+				return ""
+			}
+			return filepath.Join(dir, indexDirName, rel+indexExt)
+		}
+		rel = filepath.Join(filepath.Base(dir), rel)
+		dir = filepath.Dir(dir)
+	}
+	return ""
+}
+
+func readHead(path string, n int) []byte {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	head := make([]byte, n)
+	read, _ := io.ReadFull(f, head)
+	return head[:read]
+}
+
+// valid reports whether the index still describes the file: same leading bytes, not truncated.
+func (idx *logIndex) valid(path string, size int64) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	if size < idx.Size {
+		return false
+	}
+	return len(idx.Head) == 0 || bytes.Equal(readHead(path, len(idx.Head)), idx.Head)
+}
+
+func cachedIndexOf(idxPath string) *logIndex {
+	indexes.Lock()
+	defer indexes.Unlock()
+	if el := indexes.m[idxPath]; el != nil {
+		indexes.order.MoveToFront(el)
+		return el.Value.(*cachedIndex).idx
+	}
+	return nil
+}
+
+func cacheIndex(idxPath string, idx *logIndex) {
+	indexes.Lock()
+	defer indexes.Unlock()
+	if el := indexes.m[idxPath]; el != nil {
+		el.Value.(*cachedIndex).idx = idx
+		indexes.order.MoveToFront(el)
+		return
+	}
+	indexes.m[idxPath] = indexes.order.PushFront(&cachedIndex{path: idxPath, idx: idx})
+	for indexes.order.Len() > indexCacheSize {
+		el := indexes.order.Back()
+		indexes.order.Remove(el)
+		delete(indexes.m, el.Value.(*cachedIndex).path)
+	}
+}
+
+func dropIndex(idxPath string) {
+	indexes.Lock()
+	defer indexes.Unlock()
+	if el := indexes.m[idxPath]; el != nil {
+		indexes.order.Remove(el)
+		delete(indexes.m, idxPath)
+	}
+}
+
+// loadIndex reads the segments of an index file. A segment cut short or out of place ends the
+// index there, and the file is rewritten by the next run.
+func loadIndex(idxPath string) *logIndex {
+	f, err := os.Open(idxPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil
+	}
+	r := bufio.NewReader(f)
+	left := st.Size()
+	var idx *logIndex
+	for {
+		seg, n, err := readSegment(r, left)
+		left -= n
+		if err == io.EOF {
+			break
+		}
+		if idx == nil {
+			if err != nil || seg.Version != indexVersion || seg.From != 0 {
+				return nil
+			}
+			idx = &logIndex{Version: seg.Version, Head: seg.Head, Traces: map[string][]indexEntry{}}
+		} else if err != nil || seg.From != idx.Size {
+			idx.broken = true
+			break
+		}
+		idx.apply(seg)
+		idx.segments++
+	}
+	return idx
+}
+
+// readSegment reads the next segment of a file with left bytes remaining and returns the bytes it took.
+func readSegment(r io.Reader, left int64) (*indexSegment, int64, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, 0, err
+	}
+	if int64(n) > left-4 {
+		return nil, 4, io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, 4, err
+	}
+	seg := &indexSegment{}
+	if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(seg); err != nil {
+		return nil, 4 + int64(n), err
+	}
+	return seg, 4 + int64(n), nil
+}
+
+func writeSegment(w io.Writer, seg *indexSegment) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(seg); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(buf.Len())); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// apply adds the lines of the segment; the caller holds the lock or owns the index.
+func (idx *logIndex) apply(seg *indexSegment) {
+	for trace, entries := range seg.Traces {
+		idx.Traces[trace] = append(idx.Traces[trace], entries...)
+	}
+	idx.Marks = append(idx.Marks, seg.Marks...)
+	idx.Size, idx.Lines, idx.MaxTime = seg.Size, seg.Lines, seg.MaxTime
+}
+
+// getIndex returns the index of the log file when it exists and is still valid, otherwise nil
+// and the caller scans the file.
+func getIndex(path string) *logIndex {
+	idxPath := indexPathOf(path)
+	if idxPath == "" {
+		return nil
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	idx := cachedIndexOf(idxPath)
+	if idx == nil {
+		if idx = loadIndex(idxPath); idx == nil {
+			return nil
+		}
+		cacheIndex(idxPath, idx)
+	}
+	if !idx.valid(path, st.Size()) {
+		return nil
+	}
+	return idx
+}
+
+// updateIndex indexes the lines appended since the last run, or rebuilds the index when the file was replaced.
+func updateIndex(path string) error {
+	idxPath := indexPathOf(path)
+	if idxPath == "" {
+		return nil
+	}
+	indexWrite.Lock()
+	defer indexWrite.Unlock()
+	idx := getIndex(path)
+	fresh := idx == nil
+	if fresh {
+		idx = &logIndex{Version: indexVersion, Traces: map[string][]indexEntry{}}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	idx.mu.RLock()
+	offset, lines, maxTime := idx.Size, idx.Lines, idx.MaxTime
+	lastMark := int64(-indexMarkEvery)
+	if n := len(idx.Marks); n > 0 {
+		lastMark = idx.Marks[n-1].Offset
+	}
+	idx.mu.RUnlock()
+
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(f)
+	traces := make(map[string][]indexEntry)
+	marks := make([]indexMark, 0)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// an incomplete last line is picked up once it is finished
+			break
+		}
+		if offset-lastMark >= indexMarkEvery {
+			marks = append(marks, indexMark{Offset: offset, Line: lines, MaxTime: maxTime})
+			lastMark = offset
+		}
+		lines++
+		if len(line) <= maxLineSize {
+			if trace := gjson.Get(line, "_trace_id_").String(); trace != "" {
+				traces[trace] = append(traces[trace], indexEntry{Offset: offset, Line: lines})
+			}
+			if t := normalizeRecordTimeString(gjson.Get(line, "time").String()); t > maxTime {
+				maxTime = t
+			}
+		}
+		offset += int64(len(line))
+	}
+
+	if offset == idx.Size && !idx.broken {
+		return nil
+	}
+	seg := &indexSegment{Version: indexVersion, From: idx.Size, Size: offset, Lines: lines, MaxTime: maxTime, Traces: traces, Marks: marks}
+	idx.mu.Lock()
+	if fresh {
+		idx.Head = readHead(path, indexHeadSize)
+		if int64(len(idx.Head)) > offset {
+			idx.Head = idx.Head[:offset]
+		}
+	}
+	idx.apply(seg)
+	idx.mu.Unlock()
+	cacheIndex(idxPath, idx)
+
+	if fresh || idx.broken || idx.segments >= indexSegments {
+		return idx.rewrite(idxPath)
+	}
+	return idx.appendSegment(idxPath, seg)
+}
+
+// appendSegment adds the lines of one run to the index file.
+func (idx *logIndex) appendSegment(idxPath string, seg *indexSegment) error {
+	f, err := os.OpenFile(idxPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if os.IsNotExist(err) {
+		return idx.rewrite(idxPath)
+	}
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err = writeSegment(w, seg); err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if err != nil {
+		// a part of the segment may have been written
+		idx.broken = true
+		return err
+	}
+	idx.segments++
+	return nil
+}
+
+// rewrite replaces the index file by one segment holding the whole index.
+func (idx *logIndex) rewrite(idxPath string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(idxPath), 0755); err != nil {
+		return err
+	}
+	tmp := idxPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	seg := &indexSegment{Version: idx.Version, Head: idx.Head, Size: idx.Size, Lines: idx.Lines, MaxTime: idx.MaxTime, Traces: idx.Traces, Marks: idx.Marks}
+	if err = writeSegment(w, seg); err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, idxPath); err != nil {
+		return err
+	}
+	idx.segments, idx.broken = 1, false
+	return nil
+}
+
+// trace returns the indexed lines of the trace and the end of the indexed part.
+func (idx *logIndex) trace(traceID string) ([]indexEntry, int64, int64) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	entries := append([]indexEntry(nil), idx.Traces[traceID]...)
+	return entries, idx.Size, idx.Lines
+}
+
+// seekTime returns the last seek point before which every record is earlier than bound.
+func (idx *logIndex) seekTime(bound string) (int64, int64) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	i := sort.Search(len(idx.Marks), func(i int) bool { return idx.Marks[i].MaxTime >= bound })
+	if i == 0 {
+		return 0, 0
+	}
+	return idx.Marks[i-1].Offset, idx.Marks[i-1].Line
+}
+
+// seekLine returns the last seek point before the line.
+func (idx *logIndex) seekLine(line int64) (int64, int64) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	i := sort.Search(len(idx.Marks), func(i int) bool { return idx.Marks[i].Line >= line })
+	if i == 0 {
+		return 0, 0
+	}
+	return idx.Marks[i-1].Offset, idx.Marks[i-1].Line
+}
+
+// IndexLogs brings the sidecar index of every .jsonl file under rootDir up to date and removes
+// the indexes of deleted files. Rotated backups never change, so they are indexed once and then
+// left alone. It runs in the background every om.log.index_interval.
+func IndexLogs(rootDir string) *errors.Error {
+	files, err := listLogFileInfos(SearchOptions{RootDir: rootDir})
+	if err != nil {
+		return errors.Verify(err.Error())
+	}
+	live := make(map[string]bool, len(files))
+	for _, file := range files {
+		idxPath := indexPathOf(file.Path)
+		if idxPath == "" || isSourceFile(file.Path) {
+			continue
+		}
+		live[idxPath] = true
+		_, rotated := backupTime(file.Name)
+		if rotated {
+			if _, err := os.Stat(idxPath); err == nil {
+				continue
+			}
+		}
+		if err = updateIndex(file.Path); err != nil {
+			logger.Warn(context.Background(), "Index log file failed", zap.String("path", file.Path), zap.Error(err))
+		}
+		if rotated {
+			// loaded again when a search needs it
+			dropIndex(idxPath)
+		}
+	}
+
+	indexRoot, err := filepath.Abs(filepath.Join(getLogDir(rootDir), indexDirName))
+	if err != nil {
+		return errors.Sys("Resolve index dir failed", err)
+	}
+	_ = filepath.Walk(indexRoot, func(path string, info fs.FileInfo, err error) error {
+		if err != nil || info.IsDir() || live[path] {
+			return nil
+		}
+		_ = os.Remove(path)
+		dropIndex(path)
+		return nil
+	})
+	return nil
+}
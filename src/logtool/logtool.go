@@ -6,12 +6,32 @@ import (
 	"github.com/jom-io/gorig/global/consts"
 )
 
+// RootDirKey is the gin context key of the application directory the handlers read logs from,
+// the working directory when unset. Only the server can set it, for routers serving the logs of
+// another application; requests cannot choose the directory.
+const RootDirKey = "om_log_root"
+
+func rootDirOf(ctx *gin.Context) string {
+	return ctx.GetString(RootDirKey)
+}
+
 func GetCategories(ctx *gin.Context) {
 	defer apix.HandlePanic(ctx)
-	categories, err := FetchCategories("")
+	categories, err := FetchCategories(rootDirOf(ctx))
 	apix.HandleData(ctx, consts.CurdSelectFailCode, categories, err)
 }
 
+func GetSources(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, Sources(), nil)
+}
+
+func GetUsage(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	usage, err := FetchUsage(rootDirOf(ctx))
+	apix.HandleData(ctx, consts.CurdSelectFailCode, usage, err)
+}
+
 func GetLevels(ctx *gin.Context) {
 	defer apix.HandlePanic(ctx)
 	apix.HandleData(ctx, consts.CurdSelectFailCode, &Levels, nil)
@@ -24,19 +44,59 @@ func Search(ctx *gin.Context) {
 	if e != nil {
 		return
 	}
-	result, err := SearchLogs(opts)
+	opts.RootDir = rootDirOf(ctx)
+	opts.redact = ViewRedactor(ctx, opts.Unmasked)
+	var result []MatchedRecord
+	page, err := SearchLogsPageContext(ctx.Request.Context(), opts)
+	if page != nil {
+		result = page.Records
+	}
 	apix.HandleData(ctx, consts.CurdSelectFailCode, &result, err)
 }
 
+func SearchPaged(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	opts := SearchOptions{}
+	e := apix.BindParams(ctx, &opts, true)
+	if e != nil {
+		return
+	}
+	opts.RootDir = rootDirOf(ctx)
+	opts.redact = ViewRedactor(ctx, opts.Unmasked)
+	result, err := SearchLogsPageContext(ctx.Request.Context(), opts)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
 func Near(ctx *gin.Context) {
 	defer apix.HandlePanic(ctx)
 	path, e := apix.GetParamType[string](ctx, "path", apix.Force)
+	if e != nil {
+		return
+	}
 	cenLine, e := apix.GetParamType[int64](ctx, "line", apix.Force)
+	if e != nil {
+		return
+	}
 	ctxRange, e := apix.GetParamType[int64](ctx, "range", apix.Force)
 	if e != nil {
 		return
 	}
-	result, err := FetchContextLines(path, cenLine, ctxRange)
+	unmasked, e := apix.GetParamType[bool](ctx, "unmasked", apix.NotForce)
+	if e != nil {
+		return
+	}
+	resolved, err := resolveLogPath(rootDirOf(ctx), path)
+	if err != nil {
+		apix.HandleData(ctx, consts.CurdSelectFailCode, nil, err)
+		return
+	}
+	result, err := FetchContextLines(resolved, cenLine, ctxRange)
+	if redact := ViewRedactor(ctx, unmasked); redact != nil {
+		for i := range result {
+			result[i].Record = redact.Record(result[i].Record)
+			result[i].Content = redact.Line(result[i].Content)
+		}
+	}
 	apix.HandleData(ctx, consts.CurdSelectFailCode, &result, err)
 }
 
@@ -47,16 +107,119 @@ func Monitor(ctx *gin.Context) {
 	if e != nil {
 		return
 	}
+	opts.RootDir = rootDirOf(ctx)
+	opts.redact = ViewRedactor(ctx, opts.Unmasked)
 	err := MonitorLogs(ctx, opts)
 	apix.HandleData(ctx, consts.CurdSelectFailCode, nil, err)
 }
 
 func Download(ctx *gin.Context) {
 	defer apix.HandlePanic(ctx)
-	path, e := apix.GetParamType[string](ctx, "path", apix.Force)
+	opts := DownloadOptions{}
+	e := apix.BindParams(ctx, &opts, true)
 	if e != nil {
 		return
 	}
-	err := DownloadLogs(ctx, path)
-	apix.HandleData(ctx, consts.CurdSelectFailCode, nil, err)
+	opts.RootDir = rootDirOf(ctx)
+	if err := DownloadLogs(ctx, opts); err != nil {
+		apix.HandleData(ctx, consts.CurdSelectFailCode, nil, err)
+	}
+}
+
+func Facets(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	opts := FacetOptions{}
+	e := apix.BindParams(ctx, &opts, true)
+	if e != nil {
+		return
+	}
+	opts.RootDir = rootDirOf(ctx)
+	opts.redact = ViewRedactor(ctx, opts.Unmasked)
+	result, err := FacetLogs(ctx.Request.Context(), opts)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+func Trace(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	opts := TraceOptions{}
+	e := apix.BindParams(ctx, &opts, true)
+	if e != nil {
+		return
+	}
+	opts.RootDir = rootDirOf(ctx)
+	opts.redact = ViewRedactor(ctx, opts.Unmasked)
+	result, err := BuildTraceTimeline(ctx.Request.Context(), opts)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+func Patterns(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	opts := PatternOptions{}
+	e := apix.BindParams(ctx, &opts, true)
+	if e != nil {
+		return
+	}
+	opts.RootDir = rootDirOf(ctx)
+	opts.redact = ViewRedactor(ctx, opts.Unmasked)
+	result, err := FindPatterns(ctx.Request.Context(), opts)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+func Export(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	opts := ExportOptions{}
+	e := apix.BindParams(ctx, &opts, true)
+	if e != nil {
+		return
+	}
+	opts.RootDir = rootDirOf(ctx)
+	opts.SearchOptions.redact = ViewRedactor(ctx, opts.Unmasked)
+	if err := ExportLogs(ctx, opts); err != nil {
+		apix.HandleData(ctx, consts.CurdSelectFailCode, nil, err)
+	}
+}
+
+func RetentionGet(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, GetRetention(), nil)
+}
+
+func RetentionSave(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	req := RetentionReq{}
+	e := apix.BindParams(ctx, &req, true)
+	if e != nil {
+		return
+	}
+	err := SetRetention(ctx, req.Policies)
+	apix.HandleData(ctx, consts.CurdUpdateFailCode, nil, err)
+}
+
+// RetentionPreview lists what the saved policies, or the ones in the request, would do now.
+func RetentionPreview(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	req := RetentionReq{}
+	e := apix.BindParams(ctx, &req, true)
+	if e != nil {
+		return
+	}
+	req.RootDir = rootDirOf(ctx)
+	result, err := ApplyRetention(ctx.Request.Context(), req, true)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+func RedactionGet(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, GetRedaction(), nil)
+}
+
+func RedactionSave(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	req := RedactReq{}
+	e := apix.BindParams(ctx, &req, true)
+	if e != nil {
+		return
+	}
+	err := SetRedaction(ctx, req.Rules)
+	apix.HandleData(ctx, consts.CurdUpdateFailCode, nil, err)
 }
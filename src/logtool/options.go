@@ -10,17 +10,51 @@ type SearchOptions struct {
 	Levels     []string `json:"levels" form:"levels"`
 	TraceID    string   `json:"traceID" form:"traceID"`
 	Keyword    string   `json:"keyword" form:"keyword"`
+	Query      string   `json:"query" form:"query"` // query expression, see Query
 	StartTime  string   `json:"startTime" form:"startTime"`
 	EndTime    string   `json:"endTime" form:"endTime"`
 
-	RootDir  string `json:"root_dir" form:"rootDir"`
-	Size     int    `json:"size" form:"size"`
+	RootDir string `json:"-" form:"-"` // set by the server, see RootDirKey
+	Size    int    `json:"size" form:"size"`
+	Order   string `json:"order" form:"order"`     // desc (newest first, default) or asc
+	Cursor  string `json:"cursor" form:"cursor"`   // next or prev cursor of a previous SearchPage
+	Timeout int    `json:"timeout" form:"timeout"` // seconds, only lowers om.log.search_timeout
+
+	// Unmasked shows records without redaction, for accounts with logs:unmasked.
+	Unmasked bool `json:"unmasked" form:"unmasked"`
+
+	// Deprecated: use Cursor. Kept for older panels, the record at LastPath:LastLine is turned into a next cursor.
 	LastPath string `json:"lastPath" form:"lastPath"`
 	LastLine int64  `json:"lastLine" form:"lastLine"`
 
-	StartBound       string `json:"-" form:"-"`
-	EndBound         string `json:"-" form:"-"`
+	StartBound        string `json:"-" form:"-"`
+	EndBound          string `json:"-" form:"-"`
 	TimeBoundsInvalid bool   `json:"-" form:"-"`
+
+	query  *Query    // compiled Query, set by compileQuery
+	redact *Redactor // masks records before they are matched and returned, set by the handlers
+}
+
+const (
+	OrderDesc = "desc"
+	OrderAsc  = "asc"
+)
+
+type SearchPage struct {
+	Records   []MatchedRecord `json:"records"`
+	Next      string          `json:"next,omitempty"` // cursor of the following page, empty at the end
+	Prev      string          `json:"prev,omitempty"` // cursor of the preceding page, empty at the start
+	HasMore   bool            `json:"hasMore"`        // more records exist in the direction just fetched
+	Truncated bool            `json:"truncated"`      // the search ran out of time or bytes, records may be missing
+	Progress  SearchProgress  `json:"progress"`
+}
+
+type SearchProgress struct {
+	Files        int   `json:"files"`        // files matching the categories and time range
+	ScannedFiles int   `json:"scannedFiles"` // files actually read
+	SkippedFiles int   `json:"skippedFiles"` // files ruled out by their time range without reading
+	Bytes        int64 `json:"bytes"`        // total size of the matching files
+	ScannedBytes int64 `json:"scannedBytes"` // bytes actually read
 }
 
 type MatchedRecord struct {
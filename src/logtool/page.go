@@ -0,0 +1,224 @@
+package logtool
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// recordKey orders records across files: by time, then file path, then line number.
+// It is total, so a cursor always splits the records into a before and an after.
+type recordKey struct {
+	Time string `json:"t"`
+	Path string `json:"p"`
+	Line int64  `json:"l"`
+}
+
+func (k recordKey) less(o recordKey) bool {
+	if k.Time != o.Time {
+		return k.Time < o.Time
+	}
+	if k.Path != o.Path {
+		return k.Path < o.Path
+	}
+	return k.Line < o.Line
+}
+
+func keyOf(m MatchedRecord) recordKey {
+	t := ""
+	if m.Record != nil {
+		t = normalizeRecordTimeString(m.Record.Time)
+	}
+	return recordKey{Time: t, Path: m.FilePath, Line: m.LineNumber}
+}
+
+const (
+	dirNext = "next"
+	dirPrev = "prev"
+)
+
+type searchCursor struct {
+	recordKey
+	Order string `json:"o"`
+	Dir   string `json:"d"`
+}
+
+func encodeCursor(key recordKey, order, dir string) string {
+	data, _ := json.Marshal(searchCursor{recordKey: key, Order: order, Dir: dir})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (*searchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	c := &searchCursor{}
+	if err = json.Unmarshal(data, c); err != nil || (c.Dir != dirNext && c.Dir != dirPrev) {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return c, nil
+}
+
+func normalizeOrder(order string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(order)) {
+	case "", OrderDesc:
+		return OrderDesc, nil
+	case OrderAsc:
+		return OrderAsc, nil
+	}
+	return "", fmt.Errorf("invalid order: %s", order)
+}
+
+// selector keeps the limit records nearest to the cursor on one side of it.
+// below keeps the greatest keys under the bound, otherwise the smallest keys above it.
+type selector struct {
+	below bool
+	bound *recordKey
+	limit int
+	items []MatchedRecord
+	keys  []recordKey
+}
+
+func newSelector(order string, cursor *searchCursor, limit int) *selector {
+	s := &selector{limit: limit}
+	dir := dirNext
+	if cursor != nil {
+		dir = cursor.Dir
+		s.bound = &cursor.recordKey
+	}
+	// Newest first reads towards smaller keys when going forward.
+	s.below = (order == OrderDesc) == (dir == dirNext)
+	return s
+}
+
+func (s *selector) accepts(k recordKey) bool {
+	if s.bound != nil {
+		if s.below && !k.less(*s.bound) {
+			return false
+		}
+		if !s.below && !s.bound.less(k) {
+			return false
+		}
+	}
+	if len(s.keys) < s.limit {
+		return true
+	}
+	worst := s.keys[len(s.keys)-1]
+	if s.below {
+		return worst.less(k)
+	}
+	return k.less(worst)
+}
+
+func (s *selector) offer(m MatchedRecord) {
+	k := keyOf(m)
+	if !s.accepts(k) {
+		return
+	}
+	// keys stay sorted nearest to the bound first, so the worst one is last.
+	i := sort.Search(len(s.keys), func(i int) bool {
+		if s.below {
+			return s.keys[i].less(k)
+		}
+		return k.less(s.keys[i])
+	})
+	s.keys = append(s.keys, recordKey{})
+	s.items = append(s.items, MatchedRecord{})
+	copy(s.keys[i+1:], s.keys[i:])
+	copy(s.items[i+1:], s.items[i:])
+	s.keys[i], s.items[i] = k, m
+	if len(s.keys) > s.limit {
+		s.keys = s.keys[:s.limit]
+		s.items = s.items[:s.limit]
+	}
+}
+
+func (s *selector) full() bool {
+	return len(s.keys) >= s.limit
+}
+
+// boundSlack widens the file level time checks, since concurrent writers can
+// append records a little out of time order.
+const boundSlack = time.Second
+
+func shiftTime(t string, d time.Duration) string {
+	parsed, err := time.ParseInLocation("2006-01-02 15:04:05.000", t, time.Local)
+	if err != nil {
+		return t
+	}
+	return parsed.Add(d).Format("2006-01-02 15:04:05.000")
+}
+
+// canSkip reports whether no record of the file can be selected, judging by the
+// times of its first and last records.
+func (s *selector) canSkip(f LogFileInfo) bool {
+	if f.FirstTime == "" || f.LastTime == "" {
+		return false
+	}
+	first := shiftTime(f.FirstTime, -boundSlack)
+	last := shiftTime(f.LastTime, boundSlack)
+	if s.below {
+		if s.bound != nil && first > s.bound.Time {
+			return true
+		}
+		return s.full() && last < s.keys[len(s.keys)-1].Time
+	}
+	if s.bound != nil && last < s.bound.Time {
+		return true
+	}
+	return s.full() && first > s.keys[len(s.keys)-1].Time
+}
+
+// sortFilesFor puts the files most likely to hold the wanted records first, so
+// that the rest can often be skipped once the selector is full.
+func sortFilesFor(files []LogFileInfo, below bool) {
+	sort.SliceStable(files, func(i, j int) bool {
+		if below {
+			a, b := files[i].LastTime, files[j].LastTime
+			if a != b {
+				return a > b
+			}
+		} else {
+			a, b := files[i].FirstTime, files[j].FirstTime
+			if a != b {
+				return a < b
+			}
+		}
+		return files[i].Path < files[j].Path
+	})
+}
+
+// page turns the selection into records in display order with cursors for both directions.
+func (s *selector) page(order string, cursor *searchCursor, size int) *SearchPage {
+	items := s.items
+	hasMore := len(items) > size
+	if hasMore {
+		items = items[:size]
+	}
+	records := make([]MatchedRecord, len(items))
+	copy(records, items)
+	// items are nearest to the cursor first; a previous page is shown in reverse.
+	if cursor != nil && cursor.Dir == dirPrev {
+		for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+			records[i], records[j] = records[j], records[i]
+		}
+	}
+
+	result := &SearchPage{Records: records, HasMore: hasMore}
+	if len(records) == 0 {
+		return result
+	}
+	first, last := keyOf(records[0]), keyOf(records[len(records)-1])
+	goingPrev := cursor != nil && cursor.Dir == dirPrev
+	if hasMore && !goingPrev || goingPrev {
+		result.Next = encodeCursor(last, order, dirNext)
+	}
+	if hasMore && goingPrev || cursor != nil && !goingPrev {
+		result.Prev = encodeCursor(first, order, dirPrev)
+	}
+	return result
+}
@@ -0,0 +1,243 @@
+package logtool
+
+import (
+	"fmt"
+	"github.com/tidwall/gjson"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jsonlParser reads the records written by the gorig logger.
+type jsonlParser struct{}
+
+func (jsonlParser) Parse(line string) *LogRecord {
+	return parseLineToLogRecord(line)
+}
+
+// isJSONL reports whether lines of the parser are gorig JSON, which the raw line pre-filters rely on.
+func isJSONL(p LineParser) bool {
+	_, ok := p.(jsonlParser)
+	return ok
+}
+
+// lineTime is the normalized time of the line, read without parsing the rest of a JSON line.
+func lineTime(p LineParser, line string) string {
+	if isJSONL(p) {
+		return normalizeRecordTimeString(gjson.Get(line, "time").String())
+	}
+	if rec := p.Parse(line); rec != nil {
+		return normalizeRecordTimeString(rec.Time)
+	}
+	return ""
+}
+
+var (
+	defTextTimeRegexp = regexp.MustCompile(`\d{4}[-/]\d{2}[-/]\d{2}[ T]\d{2}:\d{2}:\d{2}(?:[.,]\d{1,9})?`)
+	textLevelRegexp   = regexp.MustCompile(`(?i)\b(debug|info|warn|warning|error|fatal|panic|dpanic)\b`)
+)
+
+// textParser reads plain text lines, taking the time and level from where they appear in the line.
+// Lines without a time, such as stack traces, have an empty time and are left out of time ranges.
+type textParser struct {
+	timeRegexp *regexp.Regexp
+	timeLayout string
+}
+
+func newTextParser(src LogSource) (LineParser, error) {
+	p := &textParser{timeRegexp: defTextTimeRegexp, timeLayout: src.TimeLayout}
+	if src.TimeRegexp != "" {
+		re, err := regexp.Compile(src.TimeRegexp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time regexp: %v", err)
+		}
+		p.timeRegexp = re
+	}
+	return p, nil
+}
+
+func (p *textParser) Parse(line string) *LogRecord {
+	line = strings.TrimRight(line, "\r\n")
+	rec := &LogRecord{Msg: strings.TrimSpace(line)}
+	if loc := p.timeRegexp.FindStringSubmatchIndex(line); loc != nil {
+		start, end := loc[0], loc[1]
+		if len(loc) >= 4 && loc[2] >= 0 {
+			start, end = loc[2], loc[3]
+		}
+		if t, ok := p.parseTime(line[start:end]); ok {
+			rec.Time = t
+			rec.Msg = strings.TrimSpace(line[:loc[0]] + line[loc[1]:])
+		}
+	}
+	if m := textLevelRegexp.FindStringSubmatch(line); m != nil {
+		rec.Level = normalizeLevel(m[1])
+	}
+	return rec
+}
+
+func (p *textParser) parseTime(s string) (string, bool) {
+	if p.timeLayout != "" {
+		t, err := time.ParseInLocation(p.timeLayout, s, time.Local)
+		if err != nil {
+			return "", false
+		}
+		return t.Local().Format(recordTimeLayout), true
+	}
+	return parseAnyTime(s)
+}
+
+// parseAnyTime reads the common ways to write a time into a record time string.
+func parseAnyTime(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", false
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.Local().Format(recordTimeLayout), true
+	}
+	norm := strings.NewReplacer("/", "-", "T", " ", ",", ".").Replace(s)
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", norm, time.Local); err == nil {
+		return t.Format(recordTimeLayout), true
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil && n > 0 {
+		if n > 1e12 {
+			n /= 1000 // milliseconds
+		}
+		sec := int64(n)
+		return time.Unix(sec, int64((n-float64(sec))*1e9)).Format(recordTimeLayout), true
+	}
+	return "", false
+}
+
+func normalizeLevel(level string) string {
+	level = strings.ToLower(level)
+	switch level {
+	case "warning":
+		return "warn"
+	case "err":
+		return "error"
+	case "crit", "critical":
+		return "fatal"
+	}
+	return level
+}
+
+// logfmtParser reads key=value lines. Well-known keys fill the record, the others go to Data.
+type logfmtParser struct{}
+
+func (logfmtParser) Parse(line string) *LogRecord {
+	line = strings.TrimSpace(line)
+	rec := &LogRecord{}
+	pairs := parseLogfmt(line)
+	if len(pairs) == 0 {
+		rec.Msg = line
+		return rec
+	}
+	for _, kv := range pairs {
+		switch strings.ToLower(kv[0]) {
+		case "level", "lvl", "severity":
+			rec.Level = normalizeLevel(kv[1])
+		case "time", "ts", "t", "timestamp":
+			if t, ok := parseAnyTime(kv[1]); ok {
+				rec.Time = t
+			}
+		case "msg", "message":
+			rec.Msg = kv[1]
+		case "error", "err":
+			rec.Error = kv[1]
+		case "trace", "trace_id", "traceid", "_trace_id_":
+			rec.TraceID = kv[1]
+		default:
+			if rec.Data == nil {
+				rec.Data = map[string]string{}
+			}
+			rec.Data[kv[0]] = kv[1]
+		}
+	}
+	return rec
+}
+
+// parseLogfmt splits key=value pairs. Values may be double quoted with backslash escapes.
+func parseLogfmt(line string) [][2]string {
+	pairs := make([][2]string, 0)
+	i := 0
+	for i < len(line) {
+		for i < len(line) && line[i] == ' ' {
+			i++
+		}
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' {
+			i++
+		}
+		key := line[start:i]
+		if i >= len(line) || line[i] != '=' {
+			continue // a bare word is not a pair
+		}
+		i++
+		var value string
+		if i < len(line) && line[i] == '"' {
+			var b strings.Builder
+			i++
+			for i < len(line) && line[i] != '"' {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				b.WriteByte(line[i])
+				i++
+			}
+			i++
+			value = b.String()
+		} else {
+			start = i
+			for i < len(line) && line[i] != ' ' {
+				i++
+			}
+			value = line[start:i]
+		}
+		if key != "" {
+			pairs = append(pairs, [2]string{key, value})
+		}
+	}
+	return pairs
+}
+
+// nginxRegexp matches the combined log format; the referer and user agent are optional, so the common format works too.
+var nginxRegexp = regexp.MustCompile(`^(\S+) \S+ (\S+) \[([^\]]+)\] "([^"]*)" (\d{3}) (\S+)(?: "([^"]*)" "([^"]*)")?`)
+
+// nginxParser reads access logs in the nginx combined format. The level comes from the status.
+type nginxParser struct{}
+
+func (nginxParser) Parse(line string) *LogRecord {
+	m := nginxRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return nil
+	}
+	rec := &LogRecord{
+		Msg: m[4],
+		Data: map[string]string{
+			"remote_addr": m[1],
+			"remote_user": m[2],
+			"status":      m[5],
+			"bytes":       m[6],
+			"referer":     m[7],
+			"user_agent":  m[8],
+		},
+	}
+	if t, err := time.Parse("02/Jan/2006:15:04:05 -0700", m[3]); err == nil {
+		rec.Time = t.Local().Format(recordTimeLayout)
+	}
+	if parts := strings.Fields(m[4]); len(parts) == 3 {
+		rec.Data["method"], rec.Data["uri"], rec.Data["protocol"] = parts[0], parts[1], parts[2]
+	}
+	status, _ := strconv.Atoi(m[5])
+	switch {
+	case status >= 500:
+		rec.Level = "error"
+	case status >= 400:
+		rec.Level = "warn"
+	default:
+		rec.Level = "info"
+	}
+	return rec
+}
@@ -0,0 +1,283 @@
+package logtool
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/jom-io/gorig/utils/errors"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	patternWildcard = "<*>"
+
+	defPatternSimilarity = 0.5
+	defPatternTop        = 50
+	maxPatternTop        = 500
+	maxPatternClusters   = 5000 // templates kept per run, later records that fit none are counted in Other
+	patternPrefixDepth   = 1    // leading tokens used to route a message before comparing templates
+	maxPatternChildren   = 100  // distinct tokens per tree node, later ones share the wildcard branch
+)
+
+var patternMasks = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`),
+	regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`),
+	regexp.MustCompile(`(?i)\b(0x[0-9a-f]+|[0-9a-f]{8,})\b`),
+	regexp.MustCompile(`\b\d+(\.\d+)?\b`),
+}
+
+type PatternOptions struct {
+	SearchOptions
+	Similarity float64 `json:"similarity" form:"similarity"` // share of equal tokens for a message to join a template, 0.5 by default
+	Top        int     `json:"top" form:"top"`               // templates returned, most frequent first, 50 by default
+}
+
+type PatternResult struct {
+	Total     int64          `json:"total"`     // records clustered
+	Clusters  int            `json:"clusters"`  // templates found, Patterns holds the top ones
+	Other     int64          `json:"other"`     // records that fit no template once maxPatternClusters was reached
+	Patterns  []*LogPattern  `json:"patterns"`  // most frequent first
+	Truncated bool           `json:"truncated"` // the scan ran out of time or bytes, counts are partial
+	Progress  SearchProgress `json:"progress"`
+}
+
+// LogPattern is a message template with the variable tokens replaced by <*>.
+type LogPattern struct {
+	ID          string           `json:"id"`
+	Field       string           `json:"field"`    // msg, or error for records without a message
+	Template    string           `json:"template"` // e.g. payment callback failed order <*> in <*>
+	Query       string           `json:"query"`    // query expression matching the lines of the template, to use with the same filters
+	Count       int64            `json:"count"`
+	Levels      map[string]int64 `json:"levels"`
+	Categories  map[string]int64 `json:"categories"`
+	FirstSeen   string           `json:"firstSeen"`
+	LastSeen    string           `json:"lastSeen"`
+	SampleTrace string           `json:"sampleTrace,omitempty"` // trace ID of the latest record that has one
+	Sample      MatchedRecord    `json:"sample"`                // latest record
+
+	traceTime string // time of the record SampleTrace comes from
+}
+
+// patternTree is a Drain-style parse tree: messages are routed by field, token count and
+// their first token, then joined to the most similar template of that leaf.
+type patternTree struct {
+	similarity float64
+	roots      map[string]*patternNode
+	clusters   []*LogPattern
+	tokens     map[*LogPattern][]string
+}
+
+type patternNode struct {
+	children map[string]*patternNode
+	clusters []*LogPattern
+}
+
+func newPatternTree(similarity float64) *patternTree {
+	return &patternTree{similarity: similarity, roots: map[string]*patternNode{}, tokens: map[*LogPattern][]string{}}
+}
+
+// patternTokens masks the values that are variable by nature and splits the message on whitespace.
+func patternTokens(text string) []string {
+	for _, re := range patternMasks {
+		text = re.ReplaceAllString(text, patternWildcard)
+	}
+	return strings.Fields(text)
+}
+
+func hasDigit(s string) bool {
+	return strings.IndexFunc(s, unicode.IsDigit) >= 0
+}
+
+// add joins the tokens to a template, or starts a new one. It returns nil when no template
+// fits and maxPatternClusters is reached.
+func (t *patternTree) add(field string, tokens []string) *LogPattern {
+	key := fmt.Sprintf("%s:%d", field, len(tokens))
+	node := t.roots[key]
+	if node == nil {
+		node = &patternNode{children: map[string]*patternNode{}}
+		t.roots[key] = node
+	}
+	for i := 0; i < patternPrefixDepth && i < len(tokens); i++ {
+		token := tokens[i]
+		if hasDigit(token) || strings.Contains(token, patternWildcard) {
+			token = patternWildcard
+		}
+		child := node.children[token]
+		if child == nil {
+			if len(node.children) >= maxPatternChildren {
+				token = patternWildcard
+				child = node.children[token]
+			}
+			if child == nil {
+				child = &patternNode{children: map[string]*patternNode{}}
+				node.children[token] = child
+			}
+		}
+		node = child
+	}
+
+	var best *LogPattern
+	bestSim, bestParams := -1.0, -1
+	for _, c := range node.clusters {
+		sim, params := templateSimilarity(t.tokens[c], tokens)
+		if sim > bestSim || (sim == bestSim && params > bestParams) {
+			best, bestSim, bestParams = c, sim, params
+		}
+	}
+	if best != nil && bestSim >= t.similarity {
+		template := t.tokens[best]
+		for i := range template {
+			if template[i] != tokens[i] {
+				template[i] = patternWildcard
+			}
+		}
+		return best
+	}
+	if len(t.clusters) >= maxPatternClusters {
+		return nil
+	}
+	c := &LogPattern{Field: field, Levels: map[string]int64{}, Categories: map[string]int64{}}
+	t.tokens[c] = append([]string(nil), tokens...)
+	node.clusters = append(node.clusters, c)
+	t.clusters = append(t.clusters, c)
+	return c
+}
+
+// templateSimilarity is the share of tokens equal to a constant token of the template,
+// and the number of wildcards, which breaks ties in favour of the more general template.
+func templateSimilarity(template, tokens []string) (float64, int) {
+	if len(tokens) == 0 {
+		return 1, 0
+	}
+	same, params := 0, 0
+	for i, token := range template {
+		if token == patternWildcard {
+			params++
+			continue
+		}
+		if token == tokens[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(tokens)), params
+}
+
+// patternQuery builds a query term matching the messages of a template: constant text is
+// quoted and each <*> stands for a run of non-space characters.
+func patternQuery(field string, tokens []string) string {
+	parts := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		pieces := strings.Split(token, patternWildcard)
+		for i, piece := range pieces {
+			// The query language ends a regexp at the next slash, so it is written as an escape.
+			pieces[i] = strings.ReplaceAll(regexp.QuoteMeta(piece), "/", `\x2f`)
+		}
+		parts = append(parts, strings.Join(pieces, `\S+`))
+	}
+	return fmt.Sprintf(`%s:/^\s*%s\s*$/`, field, strings.Join(parts, `\s+`))
+}
+
+func patternID(field, template string) string {
+	sum := sha1.Sum([]byte(field + "|" + template))
+	return hex.EncodeToString(sum[:6])
+}
+
+// FindPatterns clusters the messages of the matching records into templates, any level and category.
+func FindPatterns(ctx context.Context, opts PatternOptions) (*PatternResult, *errors.Error) {
+	search := opts.SearchOptions
+	normalizeTimeBounds(&search)
+	if e := compileQuery(&search); e != nil {
+		return nil, e
+	}
+	similarity := opts.Similarity
+	if similarity == 0 {
+		similarity = defPatternSimilarity
+	}
+	if similarity < 0 || similarity > 1 {
+		return nil, errors.Verify("similarity must be between 0 and 1")
+	}
+	top := opts.Top
+	if top <= 0 {
+		top = defPatternTop
+	}
+	if top > maxPatternTop {
+		top = maxPatternTop
+	}
+
+	result := &PatternResult{Patterns: []*LogPattern{}}
+	if search.TimeBoundsInvalid {
+		return result, nil
+	}
+	files, err := listLogFileInfos(search)
+	if err != nil {
+		return nil, errors.Verify(err.Error())
+	}
+	budget, cancel := newScanBudget(ctx, time.Duration(search.Timeout)*time.Second)
+	defer cancel()
+
+	tree := newPatternTree(similarity)
+	result.Progress.Files = len(files)
+	for _, file := range files {
+		result.Progress.Bytes += file.Size
+		if !budget.alive() {
+			continue
+		}
+		category := file.Category
+		e := scanLogFile(file.Path, search, func(m MatchedRecord) {
+			r := m.Record
+			result.Total++
+			field, text := "msg", r.Msg
+			if strings.TrimSpace(text) == "" && r.Error != "" {
+				field, text = "error", r.Error
+			}
+			c := tree.add(field, patternTokens(text))
+			if c == nil {
+				result.Other++
+				return
+			}
+			c.Count++
+			c.Levels[r.Level]++
+			c.Categories[category]++
+			if c.FirstSeen == "" || (r.Time != "" && r.Time < c.FirstSeen) {
+				c.FirstSeen = r.Time
+			}
+			if r.Time >= c.LastSeen {
+				c.LastSeen = r.Time
+				c.Sample = m
+			}
+			if r.TraceID != "" && r.Time >= c.traceTime {
+				c.SampleTrace, c.traceTime = r.TraceID, r.Time
+			}
+		}, budget)
+		if e != nil {
+			return nil, e
+		}
+		result.Progress.ScannedFiles++
+	}
+	result.Progress.ScannedBytes = budget.used()
+	result.Truncated = budget.exhausted()
+
+	sort.SliceStable(tree.clusters, func(i, j int) bool {
+		return tree.clusters[i].Count > tree.clusters[j].Count
+	})
+	result.Clusters = len(tree.clusters)
+	for _, c := range tree.clusters {
+		if len(result.Patterns) >= top {
+			break
+		}
+		tokens := tree.tokens[c]
+		c.Template = strings.Join(tokens, " ")
+		c.ID = patternID(c.Field, c.Template)
+		c.Query = patternQuery(c.Field, tokens)
+		if strings.TrimSpace(opts.Query) != "" {
+			c.Query = fmt.Sprintf("(%s) AND %s", opts.Query, c.Query)
+		}
+		result.Patterns = append(result.Patterns, c)
+	}
+	return result, nil
+}
@@ -0,0 +1,680 @@
+package logtool
+
+import (
+	"fmt"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/tidwall/gjson"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Query is a compiled search expression, for example:
+//
+//	level:error AND data.uri:/api/order* AND data.status>=500 AND NOT msg:"health"
+//
+// Terms are field:value (exact, * and ? as wildcards, substring for msg and error),
+// field:~value (the same, case-insensitive), field:/regexp/ (add i for case-insensitive),
+// field=value and field!=value (exact), field>n, >=, <, <= (numeric, or by text when
+// either side is not a number) and field:* (the field exists). A bare word matches msg,
+// error or any data value like Keyword. Terms combine with AND (or juxtaposition), OR,
+// NOT and parentheses. Fields are level, time, msg, error, trace and data.<key>; any
+// other name is looked up in data.
+type Query struct {
+	root qNode
+	pre  *preReq
+}
+
+// ParseQuery parses and compiles the expression. An empty expression matches everything.
+func ParseQuery(expr string) (*Query, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	tokens, err := lexQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &qParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.tokens[p.pos].text, p.tokens[p.pos].at)
+	}
+	return &Query{root: root, pre: root.pre()}, nil
+}
+
+// compileQuery parses opts.Query once for the whole search.
+func compileQuery(opts *SearchOptions) *errors.Error {
+	if opts.query != nil {
+		return nil
+	}
+	q, err := ParseQuery(opts.Query)
+	if err != nil {
+		return errors.Verify(fmt.Sprintf("invalid query: %v", err))
+	}
+	opts.query = q
+	return nil
+}
+
+// PreMatch is a cheap check on the raw line: false means the record cannot match.
+func (q *Query) PreMatch(line string) bool {
+	if q == nil || q.pre == nil {
+		return true
+	}
+	return q.pre.match(line)
+}
+
+// Match evaluates the expression against a parsed record.
+func (q *Query) Match(r *LogRecord) bool {
+	if q == nil {
+		return true
+	}
+	return r != nil && q.root.eval(r)
+}
+
+// ---- AST ----
+
+type qNode interface {
+	eval(r *LogRecord) bool
+	pre() *preReq
+}
+
+type qAnd struct{ left, right qNode }
+type qOr struct{ left, right qNode }
+type qNot struct{ inner qNode }
+
+func (n qAnd) eval(r *LogRecord) bool { return n.left.eval(r) && n.right.eval(r) }
+func (n qOr) eval(r *LogRecord) bool  { return n.left.eval(r) || n.right.eval(r) }
+func (n qNot) eval(r *LogRecord) bool { return !n.inner.eval(r) }
+
+func (n qAnd) pre() *preReq { return preAll(n.left.pre(), n.right.pre()) }
+func (n qOr) pre() *preReq  { return preAny(n.left.pre(), n.right.pre()) }
+func (n qNot) pre() *preReq { return nil }
+
+type qOp int
+
+const (
+	opMatch qOp = iota // field:value
+	opEq               // field=value
+	opNe               // field!=value
+	opGt
+	opGe
+	opLt
+	opLe
+	opExists  // field:*
+	opRegexp  // field:/re/
+	opKeyword // bare word
+)
+
+type qTerm struct {
+	field string
+	op    qOp
+	value string
+	fold  bool // case-insensitive
+	glob  bool // value has wildcards
+	re    *regexp.Regexp
+	num   float64
+	isNum bool
+}
+
+// fieldValue resolves the field on the record; ok is false when it is absent.
+func fieldValue(r *LogRecord, field string) (string, bool) {
+	switch strings.ToLower(field) {
+	case "level":
+		return r.Level, r.Level != ""
+	case "time":
+		return r.Time, r.Time != ""
+	case "msg":
+		return r.Msg, r.Msg != ""
+	case "error":
+		return r.Error, r.Error != ""
+	case "trace", "traceid", "_trace_id_":
+		return r.TraceID, r.TraceID != ""
+	}
+	key := strings.TrimPrefix(field, "data.")
+	if r.Data == nil {
+		return "", false
+	}
+	if v, ok := r.Data[key]; ok {
+		return v, true
+	}
+	// data.req.id looks into the JSON object stored under req.
+	if i := strings.Index(key, "."); i > 0 {
+		if raw, ok := r.Data[key[:i]]; ok {
+			res := gjson.Get(raw, key[i+1:])
+			if res.Exists() {
+				return res.String(), true
+			}
+		}
+	}
+	return "", false
+}
+
+// freeText fields match a plain value anywhere in the text rather than as a whole.
+func freeText(field string) bool {
+	f := strings.ToLower(field)
+	return f == "msg" || f == "error"
+}
+
+func (t *qTerm) eval(r *LogRecord) bool {
+	if t.op == opKeyword {
+		if t.matchText(r.Msg, true) || t.matchText(r.Error, true) {
+			return true
+		}
+		for _, v := range r.Data {
+			if t.matchText(v, true) {
+				return true
+			}
+		}
+		return false
+	}
+	v, ok := fieldValue(r, t.field)
+	switch t.op {
+	case opExists:
+		return ok && v != ""
+	case opNe:
+		return !ok || !t.equal(v)
+	}
+	if !ok {
+		return false
+	}
+	switch t.op {
+	case opMatch:
+		return t.matchText(v, freeText(t.field))
+	case opEq:
+		return t.equal(v)
+	case opRegexp:
+		return t.re.MatchString(v)
+	case opGt, opGe, opLt, opLe:
+		return t.compare(v)
+	}
+	return false
+}
+
+func (t *qTerm) equal(v string) bool {
+	if t.fold {
+		return strings.EqualFold(v, t.value)
+	}
+	return v == t.value
+}
+
+func (t *qTerm) matchText(v string, substring bool) bool {
+	value := t.value
+	if t.fold {
+		v, value = strings.ToLower(v), strings.ToLower(value)
+	}
+	if t.glob {
+		if substring {
+			value = "*" + value + "*"
+		}
+		return wildcardMatch(value, v)
+	}
+	if substring {
+		return strings.Contains(v, value)
+	}
+	return v == value
+}
+
+// wildcardMatch matches s against a pattern where * is any run of characters, / included, and ? is one character.
+func wildcardMatch(pattern, s string) bool {
+	p, i := 0, 0
+	star, mark := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, i
+			p++
+		case star >= 0:
+			p = star + 1
+			mark++
+			i = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+func (t *qTerm) compare(v string) bool {
+	var c int
+	if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil && t.isNum {
+		switch {
+		case f < t.num:
+			c = -1
+		case f > t.num:
+			c = 1
+		}
+	} else {
+		c = strings.Compare(v, t.value)
+	}
+	switch t.op {
+	case opGt:
+		return c > 0
+	case opGe:
+		return c >= 0
+	case opLt:
+		return c < 0
+	case opLe:
+		return c <= 0
+	}
+	return false
+}
+
+// ---- pre-filter ----
+
+// preReq is a requirement on the raw JSON line: all of, any of, or a literal substring.
+type preReq struct {
+	lit string
+	all []*preReq
+	any []*preReq
+}
+
+func (p *preReq) match(line string) bool {
+	switch {
+	case p.lit != "":
+		return strings.Contains(line, p.lit)
+	case p.all != nil:
+		for _, c := range p.all {
+			if !c.match(line) {
+				return false
+			}
+		}
+		return true
+	case p.any != nil:
+		for _, c := range p.any {
+			if c.match(line) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func preAll(a, b *preReq) *preReq {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	return &preReq{all: []*preReq{a, b}}
+}
+
+// preAny needs both sides to constrain the line, otherwise either could match anything.
+func preAny(a, b *preReq) *preReq {
+	if a == nil || b == nil {
+		return nil
+	}
+	return &preReq{any: []*preReq{a, b}}
+}
+
+// safeLiteral reports whether s appears verbatim in the JSON encoding of a string containing it.
+func safeLiteral(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r > unicode.MaxASCII || r < ' ' || r == '"' || r == '\\' || r == '<' || r == '>' || r == '&' {
+			return false
+		}
+	}
+	return true
+}
+
+// longestLiteral returns the longest wildcard-free part of a glob pattern.
+func longestLiteral(pattern string) string {
+	best := ""
+	for _, part := range strings.FieldsFunc(pattern, func(r rune) bool { return r == '*' || r == '?' }) {
+		if len(part) > len(best) {
+			best = part
+		}
+	}
+	return best
+}
+
+// jsonKey is the raw key of a field as written in the log line.
+func jsonKey(field string) string {
+	switch strings.ToLower(field) {
+	case "level", "time", "msg", "error":
+		return strings.ToLower(field)
+	case "trace", "traceid", "_trace_id_":
+		return "_trace_id_"
+	}
+	key := strings.TrimPrefix(field, "data.")
+	if i := strings.Index(key, "."); i > 0 {
+		key = key[:i]
+	}
+	return key
+}
+
+func (t *qTerm) pre() *preReq {
+	key := jsonKey(t.field)
+	keyReq := func() *preReq {
+		if t.op == opKeyword || !safeLiteral(key) {
+			return nil
+		}
+		return &preReq{lit: `"` + key + `":`}
+	}
+	switch t.op {
+	case opExists, opGt, opGe, opLt, opLe, opRegexp:
+		return keyReq()
+	case opMatch, opEq, opKeyword:
+		if t.fold {
+			return keyReq()
+		}
+		lit := t.value
+		if t.glob {
+			lit = longestLiteral(t.value)
+		}
+		if !safeLiteral(lit) {
+			return keyReq()
+		}
+		return preAll(keyReq(), &preReq{lit: lit})
+	}
+	return nil
+}
+
+// ---- lexer ----
+
+type qTokKind int
+
+const (
+	tokTerm qTokKind = iota
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+)
+
+type qToken struct {
+	kind qTokKind
+	text string
+	at   int
+	term *qTerm
+}
+
+func isFieldChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func lexQuery(s string) ([]qToken, error) {
+	tokens := make([]qToken, 0)
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '(':
+			tokens = append(tokens, qToken{kind: tokLParen, text: "(", at: i})
+			i++
+			continue
+		case c == ')':
+			tokens = append(tokens, qToken{kind: tokRParen, text: ")", at: i})
+			i++
+			continue
+		case strings.HasPrefix(s[i:], "&&"):
+			tokens = append(tokens, qToken{kind: tokAnd, text: "&&", at: i})
+			i += 2
+			continue
+		case strings.HasPrefix(s[i:], "||"):
+			tokens = append(tokens, qToken{kind: tokOr, text: "||", at: i})
+			i += 2
+			continue
+		case c == '!' && i+1 < len(s) && s[i+1] != '=' && s[i+1] != ' ':
+			tokens = append(tokens, qToken{kind: tokNot, text: "!", at: i})
+			i++
+			continue
+		case c == '-' && i+1 < len(s) && (s[i+1] == '(' || (isFieldChar(s[i+1]) && (i == 0 || s[i-1] == ' ' || s[i-1] == '('))):
+			// -term negates, as in most search boxes.
+			tokens = append(tokens, qToken{kind: tokNot, text: "-", at: i})
+			i++
+			continue
+		}
+
+		start := i
+		j := i
+		for j < len(s) && isFieldChar(s[j]) {
+			j++
+		}
+		if j > i && j < len(s) && strings.ContainsRune(":=!<>", rune(s[j])) {
+			term, next, err := lexTerm(s, i, j)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, qToken{kind: tokTerm, text: s[start:next], at: start, term: term})
+			i = next
+			continue
+		}
+
+		value, next, quoted, err := lexValue(s, i)
+		if err != nil {
+			return nil, err
+		}
+		if !quoted {
+			switch strings.ToUpper(value) {
+			case "AND":
+				tokens = append(tokens, qToken{kind: tokAnd, text: value, at: start})
+				i = next
+				continue
+			case "OR":
+				tokens = append(tokens, qToken{kind: tokOr, text: value, at: start})
+				i = next
+				continue
+			case "NOT":
+				tokens = append(tokens, qToken{kind: tokNot, text: value, at: start})
+				i = next
+				continue
+			}
+		}
+		term := &qTerm{op: opKeyword, value: value, glob: !quoted && strings.ContainsAny(value, "*?")}
+		tokens = append(tokens, qToken{kind: tokTerm, text: s[start:next], at: start, term: term})
+		i = next
+	}
+	return tokens, nil
+}
+
+// lexTerm reads field<op>value starting at i, the operator is at j.
+func lexTerm(s string, i, j int) (*qTerm, int, error) {
+	term := &qTerm{field: s[i:j]}
+	k := j
+	switch {
+	case strings.HasPrefix(s[k:], ">="):
+		term.op, k = opGe, k+2
+	case strings.HasPrefix(s[k:], "<="):
+		term.op, k = opLe, k+2
+	case strings.HasPrefix(s[k:], "!="):
+		term.op, k = opNe, k+2
+	case s[k] == '>':
+		term.op, k = opGt, k+1
+	case s[k] == '<':
+		term.op, k = opLt, k+1
+	case s[k] == '=':
+		term.op, k = opEq, k+1
+	case strings.HasPrefix(s[k:], ":~"):
+		term.op, term.fold, k = opMatch, true, k+2
+	case s[k] == ':':
+		term.op, k = opMatch, k+1
+	default:
+		return nil, 0, fmt.Errorf("unknown operator at position %d", k)
+	}
+
+	value, next, quoted, err := lexValue(s, k)
+	if err != nil {
+		return nil, 0, err
+	}
+	if value == "" && !quoted {
+		return nil, 0, fmt.Errorf("missing value for %s at position %d", term.field, i)
+	}
+	term.value = value
+
+	if term.op == opMatch && !quoted {
+		switch {
+		case value == "*":
+			term.op = opExists
+		case isRegexpLiteral(value):
+			end := strings.LastIndex(value, "/")
+			pattern, flags := value[1:end], value[end+1:]
+			if strings.Contains(flags, "i") || term.fold {
+				pattern = "(?i)" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid regexp for %s: %v", term.field, err)
+			}
+			term.op, term.re = opRegexp, re
+		default:
+			term.glob = strings.ContainsAny(value, "*?")
+		}
+	}
+	if term.op == opGt || term.op == opGe || term.op == opLt || term.op == opLe {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			term.num, term.isNum = f, true
+		}
+	}
+	return term, next, nil
+}
+
+// isRegexpLiteral reports whether a bare value is written as /pattern/ or /pattern/i.
+func isRegexpLiteral(v string) bool {
+	if len(v) < 2 || v[0] != '/' {
+		return false
+	}
+	end := strings.LastIndex(v, "/")
+	if end <= 0 {
+		return false
+	}
+	flags := v[end+1:]
+	return flags == "" || flags == "i"
+}
+
+// lexValue reads a quoted string or a bare value ending at whitespace or a parenthesis.
+func lexValue(s string, i int) (string, int, bool, error) {
+	if i < len(s) && s[i] == '"' {
+		var b strings.Builder
+		j := i + 1
+		for j < len(s) {
+			switch s[j] {
+			case '\\':
+				if j+1 < len(s) {
+					b.WriteByte(s[j+1])
+					j += 2
+					continue
+				}
+			case '"':
+				return b.String(), j + 1, true, nil
+			}
+			b.WriteByte(s[j])
+			j++
+		}
+		return "", 0, false, fmt.Errorf("unterminated quote at position %d", i)
+	}
+	j := i
+	if j < len(s) && s[j] == '/' {
+		// A regexp may contain spaces and parentheses, read up to the closing slash.
+		if end := strings.Index(s[j+1:], "/"); end >= 0 {
+			k := j + 1 + end + 1
+			if k < len(s) && s[k] == 'i' {
+				k++
+			}
+			if k == len(s) || s[k] == ' ' || s[k] == ')' {
+				return s[i:k], k, false, nil
+			}
+		}
+	}
+	for j < len(s) && s[j] != ' ' && s[j] != '\t' && s[j] != '(' && s[j] != ')' {
+		j++
+	}
+	return s[i:j], j, false, nil
+}
+
+// ---- parser ----
+
+type qParser struct {
+	tokens []qToken
+	pos    int
+}
+
+func (p *qParser) peek() *qToken {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *qParser) parseOr() (qNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t != nil && t.kind == tokOr; t = p.peek() {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = qOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *qParser) parseAnd() (qNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t != nil && t.kind != tokOr && t.kind != tokRParen; t = p.peek() {
+		if t.kind == tokAnd {
+			p.pos++
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = qAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *qParser) parseNot() (qNode, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, fmt.Errorf("unexpected end of query")
+	}
+	switch t.kind {
+	case tokNot:
+		p.pos++
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return qNot{inner}, nil
+	case tokLParen:
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if next := p.peek(); next == nil || next.kind != tokRParen {
+			return nil, fmt.Errorf("missing ) for ( at position %d", t.at)
+		}
+		p.pos++
+		return inner, nil
+	case tokTerm:
+		p.pos++
+		return t.term, nil
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.at)
+}
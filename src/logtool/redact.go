@@ -0,0 +1,337 @@
+package logtool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig-om/src/omuser"
+	"github.com/jom-io/gorig/cache"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	redactKey     = "rules"
+	redactedValue = "******"
+)
+
+var (
+	redactEnabled = true
+	redactConf    cache.Cache[[]RedactRule]
+	redactOnce    sync.Once
+	redactCur     = struct {
+		sync.RWMutex
+		r      *Redactor
+		loaded bool
+	}{}
+)
+
+// defRedactRules are used until rules are saved.
+var defRedactRules = []RedactRule{
+	{Field: "*password*"},
+	{Field: "*passwd*"},
+	{Field: "*pwd*"},
+	{Field: "*secret*"},
+	{Field: "*token*"},
+	{Field: "*api_key*"},
+	{Field: "*apikey*"},
+	{Field: "*private_key*"},
+	{Field: "authorization"},
+	{Field: "cookie"},
+}
+
+// logfmtPairRegexp finds key=value pairs in lines that are not JSON, and in query strings.
+var logfmtPairRegexp = regexp.MustCompile(`([A-Za-z0-9_.-]+)=("(?:[^"\\]|\\.)*"|[^\s&]*)`)
+
+// RedactRule hides a sensitive value from log views. Exactly one of Field, Path and Regexp is set.
+type RedactRule struct {
+	Field   string `json:"field,omitempty" form:"field"`     // key at any depth of the record and of JSON values, * as wildcard, any case, e.g. *token*
+	Path    string `json:"path,omitempty" form:"path"`       // dotted path from the top of the record, e.g. data.body.user.phone
+	Regexp  string `json:"regexp,omitempty" form:"regexp"`   // text replaced in msg, error and every value, e.g. card numbers
+	Replace string `json:"replace,omitempty" form:"replace"` // replacement of Regexp matches, may use $1, ****** by default
+}
+
+type RedactReq struct {
+	Rules []RedactRule `json:"rules" form:"rules"`
+}
+
+// Redactor masks the values matched by a set of rules. A nil Redactor masks nothing.
+type Redactor struct {
+	field *regexp.Regexp // every Field rule in one expression, as it is tried on each key
+	paths [][]string
+	texts []textRedaction
+}
+
+type textRedaction struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+func init() {
+	redactEnabled = configure.GetBool("om.log.redact.enabled", true)
+}
+
+func redactStore() cache.Cache[[]RedactRule] {
+	redactOnce.Do(func() {
+		redactConf = cache.New[[]RedactRule](cache.Sqlite, "om_log_redact")
+	})
+	return redactConf
+}
+
+// GetRedaction returns the saved rules, or the default ones.
+func GetRedaction() []RedactRule {
+	saved, err := redactStore().Get(redactKey)
+	if err == nil && len(saved) > 0 {
+		return saved
+	}
+	return defRedactRules
+}
+
+// SetRedaction replaces the saved rules. An empty list goes back to the default ones.
+func SetRedaction(ctx context.Context, rules []RedactRule) *errors.Error {
+	r, e := CompileRedaction(rules)
+	if e != nil {
+		return e
+	}
+	if len(rules) == 0 {
+		if err := redactStore().Del(redactKey); err != nil {
+			return errors.Sys("Reset log redaction failed", err)
+		}
+		r, _ = CompileRedaction(defRedactRules)
+	} else if err := redactStore().Set(redactKey, rules, 0); err != nil {
+		return errors.Sys("Save log redaction failed", err)
+	}
+	redactCur.Lock()
+	redactCur.r, redactCur.loaded = r, true
+	redactCur.Unlock()
+	logger.Info(ctx, fmt.Sprintf("Log redaction saved: %d rule(s)", len(rules)))
+	return nil
+}
+
+// currentRedactor is the redactor of the saved rules, nil when redaction is turned off.
+func currentRedactor() *Redactor {
+	if !redactEnabled {
+		return nil
+	}
+	redactCur.RLock()
+	r, loaded := redactCur.r, redactCur.loaded
+	redactCur.RUnlock()
+	if loaded {
+		return r
+	}
+	r, e := CompileRedaction(GetRedaction())
+	if e != nil {
+		logger.Warn(nil, "Saved log redaction is invalid, using the default rules: "+e.Error())
+		r, _ = CompileRedaction(defRedactRules)
+	}
+	redactCur.Lock()
+	redactCur.r, redactCur.loaded = r, true
+	redactCur.Unlock()
+	return r
+}
+
+// ViewRedactor returns the redactor for the records a request shows. It is nil, showing them as they
+// are, only when redaction is off or the request asks for unmasked records and holds logs:unmasked.
+func ViewRedactor(ctx *gin.Context, unmasked bool) *Redactor {
+	if unmasked && omuser.Allowed(ctx, omuser.PermLogUnmasked) {
+		return nil
+	}
+	return currentRedactor()
+}
+
+// CompileRedaction checks the rules and builds their redactor.
+func CompileRedaction(rules []RedactRule) (*Redactor, *errors.Error) {
+	r := &Redactor{}
+	fields := make([]string, 0)
+	for i := range rules {
+		rule := &rules[i]
+		rule.Field = strings.TrimSpace(rule.Field)
+		rule.Path = strings.TrimSpace(rule.Path)
+		set := 0
+		for _, s := range []string{rule.Field, rule.Path, rule.Regexp} {
+			if s != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return nil, errors.Verify(fmt.Sprintf("Rule %d must set exactly one of field, path and regexp", i+1))
+		}
+		switch {
+		case rule.Field != "":
+			fields = append(fields, strings.ReplaceAll(regexp.QuoteMeta(rule.Field), `\*`, ".*"))
+		case rule.Path != "":
+			segments := strings.Split(strings.TrimPrefix(rule.Path, "data."), ".")
+			for _, s := range segments {
+				if s == "" {
+					return nil, errors.Verify(fmt.Sprintf("Invalid path: %s", rule.Path))
+				}
+			}
+			r.paths = append(r.paths, segments)
+		default:
+			re, err := regexp.Compile(rule.Regexp)
+			if err != nil {
+				return nil, errors.Verify(fmt.Sprintf("Invalid regexp %s: %v", rule.Regexp, err))
+			}
+			if re.MatchString("") {
+				return nil, errors.Verify(fmt.Sprintf("Regexp %s matches empty text", rule.Regexp))
+			}
+			replace := rule.Replace
+			if replace == "" {
+				replace = redactedValue
+			}
+			r.texts = append(r.texts, textRedaction{re: re, replace: replace})
+		}
+	}
+	if len(fields) > 0 {
+		r.field = regexp.MustCompile(`(?i)^(?:` + strings.Join(fields, "|") + `)$`)
+	}
+	return r, nil
+}
+
+// Record returns a copy of the record with the sensitive values masked.
+func (r *Redactor) Record(rec *LogRecord) *LogRecord {
+	if r == nil || rec == nil {
+		return rec
+	}
+	out := *rec
+	out.Msg = r.text(rec.Msg)
+	out.Error = r.text(rec.Error)
+	if rec.Data != nil {
+		out.Data = make(map[string]string, len(rec.Data))
+		for k, v := range rec.Data {
+			out.Data[k] = r.value([]string{k}, v)
+		}
+	}
+	return &out
+}
+
+// Line masks a raw line: gorig JSON the same way as its record, other lines by their key=value
+// pairs and the Regexp rules.
+func (r *Redactor) Line(line string) string {
+	if r == nil {
+		return line
+	}
+	body := strings.TrimRight(line, "\r\n")
+	eol := line[len(body):]
+	if strings.HasPrefix(strings.TrimSpace(body), "{") {
+		if v, ok := decodeJSON(body); ok {
+			if out, changed := r.walk(nil, v); changed {
+				return encodeJSON(out) + eol
+			}
+			return line
+		}
+	}
+	body = logfmtPairRegexp.ReplaceAllStringFunc(body, func(pair string) string {
+		key := pair[:strings.IndexByte(pair, '=')]
+		if r.hides([]string{key}) {
+			return key + "=" + redactedValue
+		}
+		return pair
+	})
+	return r.text(body) + eol
+}
+
+// hides reports whether the value at the path is masked as a whole.
+func (r *Redactor) hides(path []string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	if r.field != nil && r.field.MatchString(path[len(path)-1]) {
+		return true
+	}
+	for _, p := range r.paths {
+		if len(p) != len(path) {
+			continue
+		}
+		same := true
+		for i := range p {
+			if !strings.EqualFold(p[i], path[i]) {
+				same = false
+				break
+			}
+		}
+		if same {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redactor) text(s string) string {
+	for _, t := range r.texts {
+		s = t.re.ReplaceAllString(s, t.replace)
+	}
+	return s
+}
+
+// value masks the text found at the path. A JSON object or array, such as a logged request
+// body, is masked inside.
+func (r *Redactor) value(path []string, s string) string {
+	if r.hides(path) {
+		return redactedValue
+	}
+	if t := strings.TrimSpace(s); strings.HasPrefix(t, "{") || strings.HasPrefix(t, "[") {
+		if v, ok := decodeJSON(t); ok {
+			if out, changed := r.walk(path, v); changed {
+				return encodeJSON(out)
+			}
+			return s
+		}
+	}
+	return r.text(s)
+}
+
+func (r *Redactor) walk(path []string, v any) (any, bool) {
+	switch val := v.(type) {
+	case map[string]any:
+		changed := false
+		for k, item := range val {
+			p := append(path[:len(path):len(path)], k)
+			if r.hides(p) {
+				val[k] = redactedValue
+				changed = true
+			} else if out, ok := r.walk(p, item); ok {
+				val[k] = out
+				changed = true
+			}
+		}
+		return val, changed
+	case []any:
+		changed := false
+		for i, item := range val {
+			if out, ok := r.walk(path, item); ok {
+				val[i] = out
+				changed = true
+			}
+		}
+		return val, changed
+	case string:
+		out := r.value(path, val)
+		return out, out != val
+	}
+	return v, false
+}
+
+func decodeJSON(s string) (any, bool) {
+	var v any
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return nil, false
+	}
+	return v, true
+}
+
+func encodeJSON(v any) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(v)
+	return strings.TrimRight(buf.String(), "\n")
+}
@@ -0,0 +1,414 @@
+package logtool
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig/cache"
+	"github.com/jom-io/gorig/cronx"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetentionDefault is the category of the policy applied to categories without their own.
+const RetentionDefault = "*"
+
+const (
+	retentionKey = "policies"
+
+	RetentionCompress = "compress"
+	RetentionDelete   = "delete"
+)
+
+var (
+	retentionConf cache.Cache[[]RetentionPolicy]
+	retentionOnce sync.Once
+	retentionMu   sync.Mutex // one enforcement at a time
+)
+
+// RetentionPolicy limits the files of a category. Zero turns a limit off. The file being
+// written to is never compressed or deleted.
+type RetentionPolicy struct {
+	Category     string `json:"category" form:"category"`         // category name, or * for the default policy
+	MaxDays      int    `json:"maxDays" form:"maxDays"`           // delete files whose last record is older than this
+	MaxSizeMB    int64  `json:"maxSizeMB" form:"maxSizeMB"`       // then delete the oldest files until the category fits
+	CompressDays int    `json:"compressDays" form:"compressDays"` // gzip plain files whose last record is older than this
+}
+
+type RetentionReq struct {
+	Policies []RetentionPolicy `json:"policies" form:"policies"` // previewed instead of the saved policies when set
+	RootDir  string            `json:"-" form:"-"`
+}
+
+type RetentionAction struct {
+	Category string `json:"category"`
+	Path     string `json:"path"`
+	Action   string `json:"action"` // compress or delete
+	Reason   string `json:"reason"` // e.g. older than 30 days
+	Size     int64  `json:"size"`
+	LastTime string `json:"lastTime,omitempty"`
+	Error    string `json:"error,omitempty"` // set when enforcing failed for this file
+}
+
+type RetentionResult struct {
+	DryRun     bool              `json:"dryRun"`
+	Actions    []RetentionAction `json:"actions"`
+	FreedBytes int64             `json:"freedBytes"` // deleted sizes, a dry run does not count the gain of compression
+}
+
+type CategoryUsage struct {
+	Category string `json:"category"`
+	Files    int    `json:"files"`
+	Archives int    `json:"archives"` // gzip files among Files
+	Size     int64  `json:"size"`
+	Oldest   string `json:"oldest,omitempty"` // time of the oldest record
+	Newest   string `json:"newest,omitempty"` // time of the newest record
+}
+
+type LogUsage struct {
+	Categories []CategoryUsage `json:"categories"`
+	Files      int             `json:"files"`
+	Size       int64           `json:"size"`
+}
+
+func init() {
+	if !configure.GetBool("om.log.retention.enabled", true) {
+		return
+	}
+	cronx.AddCronTask("0 20 * * * *", enforceRetention, 30*time.Minute)
+}
+
+func enforceRetention(ctx context.Context) {
+	result, err := ApplyRetention(ctx, RetentionReq{}, false)
+	if err != nil {
+		logger.Error(ctx, "Apply log retention failed", zap.Error(err))
+		return
+	}
+	for _, a := range result.Actions {
+		if a.Error != "" {
+			logger.Warn(ctx, "Log retention action failed", zap.String("path", a.Path), zap.String("action", a.Action), zap.String("error", a.Error))
+		}
+	}
+	if len(result.Actions) > 0 {
+		logger.Info(ctx, fmt.Sprintf("Log retention applied: %d action(s), %d bytes freed", len(result.Actions), result.FreedBytes))
+	}
+}
+
+func retentionStore() cache.Cache[[]RetentionPolicy] {
+	retentionOnce.Do(func() {
+		retentionConf = cache.New[[]RetentionPolicy](cache.Sqlite, "om_log_retention")
+	})
+	return retentionConf
+}
+
+// configPolicy is the default policy from om.log.retention, used until policies are saved.
+func configPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		Category:     RetentionDefault,
+		MaxDays:      configure.GetInt("om.log.retention.max_days", 0),
+		MaxSizeMB:    int64(configure.GetInt("om.log.retention.max_size_mb", 0)),
+		CompressDays: configure.GetInt("om.log.retention.compress_days", 0),
+	}
+}
+
+// GetRetention returns the saved policies, or the one from the configuration.
+func GetRetention() []RetentionPolicy {
+	saved, err := retentionStore().Get(retentionKey)
+	if err == nil && len(saved) > 0 {
+		return saved
+	}
+	return []RetentionPolicy{configPolicy()}
+}
+
+func checkPolicies(policies []RetentionPolicy) *errors.Error {
+	seen := make(map[string]bool, len(policies))
+	for i := range policies {
+		p := &policies[i]
+		p.Category = strings.TrimSpace(p.Category)
+		if p.Category == "" {
+			p.Category = RetentionDefault
+		}
+		if strings.ContainsAny(p.Category, `/\`) || strings.HasPrefix(p.Category, ".") {
+			return errors.Verify(fmt.Sprintf("Invalid category: %s", p.Category))
+		}
+		if seen[p.Category] {
+			return errors.Verify(fmt.Sprintf("Duplicate policy for %s", p.Category))
+		}
+		seen[p.Category] = true
+		if p.MaxDays < 0 || p.MaxSizeMB < 0 || p.CompressDays < 0 {
+			return errors.Verify(fmt.Sprintf("Limits of %s must not be negative", p.Category))
+		}
+	}
+	return nil
+}
+
+// SetRetention replaces the saved policies. An empty list goes back to the configuration.
+func SetRetention(ctx context.Context, policies []RetentionPolicy) *errors.Error {
+	if e := checkPolicies(policies); e != nil {
+		return e
+	}
+	if len(policies) == 0 {
+		if err := retentionStore().Del(retentionKey); err != nil {
+			return errors.Sys("Reset log retention failed", err)
+		}
+		return nil
+	}
+	if err := retentionStore().Set(retentionKey, policies, 0); err != nil {
+		return errors.Sys("Save log retention failed", err)
+	}
+	logger.Info(ctx, fmt.Sprintf("Log retention saved: %+v", policies))
+	return nil
+}
+
+func policyOf(policies []RetentionPolicy, category string) RetentionPolicy {
+	var def RetentionPolicy
+	for _, p := range policies {
+		if p.Category == category {
+			return p
+		}
+		if p.Category == RetentionDefault {
+			def = p
+		}
+	}
+	return def
+}
+
+// isActiveLog reports whether the file is the one the logger writes to, <category>/<category>.jsonl.
+func isActiveLog(file LogFileInfo) bool {
+	return file.Name == file.Category+logExt
+}
+
+// fileLastTime is the time of the last record, or the modification time when it cannot be read.
+func fileLastTime(file *LogFileInfo) time.Time {
+	if file.LastTime == "" {
+		file.FirstTime, file.LastTime, _ = readLogTimeBounds(file.Path)
+	}
+	if t, ok := parseRecordTime(file.LastTime); ok {
+		return t
+	}
+	return time.Unix(0, file.ModTime)
+}
+
+// ApplyRetention applies the policies to every category: files past MaxDays are deleted, plain
+// files past CompressDays are compressed, then the oldest files are deleted until the category
+// fits in MaxSizeMB. A dry run only lists what would be done.
+func ApplyRetention(ctx context.Context, req RetentionReq, dryRun bool) (*RetentionResult, *errors.Error) {
+	policies := req.Policies
+	if len(policies) == 0 {
+		policies = GetRetention()
+	} else if e := checkPolicies(policies); e != nil {
+		return nil, e
+	}
+	if !dryRun {
+		retentionMu.Lock()
+		defer retentionMu.Unlock()
+	}
+
+	files, err := listLogFileInfos(SearchOptions{RootDir: req.RootDir})
+	if err != nil {
+		return nil, errors.Verify(err.Error())
+	}
+	byCategory := make(map[string][]LogFileInfo)
+	for _, file := range files {
+		// Source files belong to the programs writing them, which rotate them on their own.
+		if !isActiveLog(file) && !isSourceFile(file.Path) {
+			byCategory[file.Category] = append(byCategory[file.Category], file)
+		}
+	}
+	categories := make([]string, 0, len(byCategory))
+	for cat := range byCategory {
+		categories = append(categories, cat)
+	}
+	sort.Strings(categories)
+
+	result := &RetentionResult{DryRun: dryRun, Actions: []RetentionAction{}}
+	now := time.Now()
+	for _, cat := range categories {
+		if ctx.Err() != nil {
+			break
+		}
+		policy := policyOf(policies, cat)
+		if policy.MaxDays <= 0 && policy.MaxSizeMB <= 0 && policy.CompressDays <= 0 {
+			continue
+		}
+		// The active file counts towards the size but is never touched.
+		var size int64
+		for _, file := range files {
+			if file.Category == cat && isActiveLog(file) {
+				size += file.Size
+			}
+		}
+
+		catFiles := byCategory[cat]
+		lastTimes := make(map[string]time.Time, len(catFiles))
+		for i := range catFiles {
+			lastTimes[catFiles[i].Path] = fileLastTime(&catFiles[i])
+		}
+		sort.SliceStable(catFiles, func(i, j int) bool {
+			return lastTimes[catFiles[i].Path].Before(lastTimes[catFiles[j].Path])
+		})
+
+		kept := make([]LogFileInfo, 0, len(catFiles))
+		for _, file := range catFiles {
+			age := now.Sub(lastTimes[file.Path])
+			action := RetentionAction{Category: cat, Path: file.Path, Size: file.Size, LastTime: file.LastTime}
+			switch {
+			case policy.MaxDays > 0 && age > time.Duration(policy.MaxDays)*24*time.Hour:
+				action.Action = RetentionDelete
+				action.Reason = fmt.Sprintf("older than %d days", policy.MaxDays)
+				result.apply(action, dryRun)
+				continue
+			case policy.CompressDays > 0 && !isArchive(file.Name) && age > time.Duration(policy.CompressDays)*24*time.Hour:
+				action.Action = RetentionCompress
+				action.Reason = fmt.Sprintf("older than %d days", policy.CompressDays)
+				if path, size, ok := result.apply(action, dryRun); ok {
+					file.Path, file.Name, file.Size = path, filepath.Base(path), size
+				}
+			}
+			size += file.Size
+			kept = append(kept, file)
+		}
+
+		limit := policy.MaxSizeMB * 1024 * 1024
+		for i := 0; limit > 0 && size > limit && i < len(kept); i++ {
+			file := kept[i]
+			result.apply(RetentionAction{
+				Category: cat,
+				Path:     file.Path,
+				Action:   RetentionDelete,
+				Reason:   fmt.Sprintf("category over %d MB", policy.MaxSizeMB),
+				Size:     file.Size,
+				LastTime: file.LastTime,
+			}, dryRun)
+			size -= file.Size
+		}
+	}
+	return result, nil
+}
+
+// apply records the action and, unless it is a dry run, carries it out. For a compression it
+// returns the archive path and size.
+func (r *RetentionResult) apply(action RetentionAction, dryRun bool) (path string, size int64, ok bool) {
+	switch {
+	case dryRun:
+		if action.Action == RetentionDelete {
+			r.FreedBytes += action.Size
+		}
+	case action.Action == RetentionDelete:
+		if err := os.Remove(action.Path); err != nil {
+			action.Error = err.Error()
+		} else {
+			r.FreedBytes += action.Size
+		}
+	case action.Action == RetentionCompress:
+		var err error
+		if path, size, err = compressLog(action.Path); err != nil {
+			action.Error = err.Error()
+		} else {
+			r.FreedBytes += action.Size - size
+			ok = true
+		}
+	}
+	r.Actions = append(r.Actions, action)
+	return path, size, ok
+}
+
+// compressLog gzips a plain log next to itself as .jsonl.gz, keeping its modification time,
+// and removes the original.
+func compressLog(path string) (string, int64, error) {
+	if !strings.HasSuffix(path, logExt) {
+		return "", 0, fmt.Errorf("not a plain log file: %s", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer src.Close()
+
+	target := strings.TrimSuffix(path, logExt) + archiveExt
+	tmp := target + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return "", 0, err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chtimes(tmp, info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmp, target)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", 0, err
+	}
+	if err = os.Remove(path); err != nil {
+		return "", 0, err
+	}
+	zipped, err := os.Stat(target)
+	if err != nil {
+		return "", 0, err
+	}
+	return target, zipped.Size(), nil
+}
+
+// FetchUsage reports the disk usage, file count and record time range of each category.
+func FetchUsage(rootDir string) (*LogUsage, *errors.Error) {
+	files, err := listLogFileInfos(SearchOptions{RootDir: rootDir})
+	if err != nil {
+		return nil, errors.Verify(err.Error())
+	}
+	usage := &LogUsage{Categories: []CategoryUsage{}}
+	byCategory := make(map[string]*CategoryUsage)
+	for _, file := range files {
+		u := byCategory[file.Category]
+		if u == nil {
+			u = &CategoryUsage{Category: file.Category}
+			byCategory[file.Category] = u
+		}
+		u.Files++
+		u.Size += file.Size
+		if isArchive(file.Name) {
+			u.Archives++
+		}
+		first, last, ok := readLogTimeBounds(file.Path)
+		if !ok {
+			continue
+		}
+		if first != "" && (u.Oldest == "" || first < u.Oldest) {
+			u.Oldest = first
+		}
+		if last > u.Newest {
+			u.Newest = last
+		}
+	}
+	for _, u := range byCategory {
+		usage.Categories = append(usage.Categories, *u)
+		usage.Files += u.Files
+		usage.Size += u.Size
+	}
+	sort.Slice(usage.Categories, func(i, j int) bool {
+		return usage.Categories[i].Category < usage.Categories[j].Category
+	})
+	return usage, nil
+}
@@ -3,9 +3,9 @@ package logtool
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/fsnotify/fsnotify"
 	"github.com/gin-gonic/gin"
 	"github.com/jom-io/gorig/utils/errors"
 	"github.com/jom-io/gorig/utils/logger"
@@ -17,6 +17,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
@@ -33,7 +34,11 @@ func getLogDir(rootDir string) string {
 
 func FetchCategories(rootDir string) ([]string, *errors.Error) {
 	logDir := getLogDir(rootDir)
+	srcCategories := sourceCategories(rootDir)
 	if _, err := os.Stat(logDir); os.IsNotExist(err) {
+		if len(srcCategories) > 0 {
+			return srcCategories, nil
+		}
 		return nil, errors.Verify(fmt.Sprintf("log directory does not exist: %s", logDir))
 	}
 
@@ -43,6 +48,7 @@ func FetchCategories(rootDir string) ([]string, *errors.Error) {
 	}
 
 	categories := make([]string, 0)
+	seen := make(map[string]bool)
 	for _, catDir := range catDirList {
 		if catDir.IsDir() {
 			isValidCategory := false
@@ -51,7 +57,7 @@ func FetchCategories(rootDir string) ([]string, *errors.Error) {
 					logger.Warn(nil, "skip file", zap.Error(err))
 					return nil
 				}
-				if !info.IsDir() && strings.HasSuffix(info.Name(), ".jsonl") {
+				if !info.IsDir() && isLogFile(info.Name()) {
 					isValidCategory = true
 					return io.EOF // Stop walking once we find a valid file
 				}
@@ -59,19 +65,50 @@ func FetchCategories(rootDir string) ([]string, *errors.Error) {
 			})
 			if isValidCategory {
 				categories = append(categories, catDir.Name())
+				seen[catDir.Name()] = true
 			}
 		}
 	}
+	for _, name := range srcCategories {
+		if !seen[name] {
+			categories = append(categories, name)
+		}
+	}
 
 	return categories, nil
 }
 
 func ListLogFiles(opts SearchOptions) (map[string]string, error) {
+	infos, err := listLogFileInfos(opts)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(infos))
+	for _, info := range infos {
+		result[info.Path] = info.Name
+	}
+	return result, nil
+}
+
+// LogFileInfo describes one log file matched by the search options.
+type LogFileInfo struct {
+	Path      string `json:"path"`
+	Name      string `json:"name"`
+	Category  string `json:"category"`
+	Size      int64  `json:"size"`
+	ModTime   int64  `json:"modTime"`
+	FirstTime string `json:"firstTime,omitempty"` // time of the first record, empty when unknown
+	LastTime  string `json:"lastTime,omitempty"`  // time of the last record, empty when unknown
+}
+
+// listLogFileInfos lists the matched log files, newest modified first, ties broken by path,
+// so that every call sees the files in the same order.
+func listLogFileInfos(opts SearchOptions) ([]LogFileInfo, error) {
 	if opts.RootDir == "" {
 		opts.RootDir = "."
 	}
 	logDir := getLogDir(opts.RootDir)
-	result := make(map[string]string)
+	result := make([]LogFileInfo, 0)
 
 	localCategories, e := FetchCategories(opts.RootDir)
 	if e != nil {
@@ -83,7 +120,7 @@ func ListLogFiles(opts SearchOptions) (map[string]string, error) {
 	}
 
 	catDirList, err := os.ReadDir(logDir)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return nil, fmt.Errorf("read log dir error: %v", err)
 	}
 
@@ -116,8 +153,15 @@ func ListLogFiles(opts SearchOptions) (map[string]string, error) {
 				logger.Warn(nil, "skip file", zap.Error(err))
 				return nil
 			}
-			if !info.IsDir() && strings.HasSuffix(info.Name(), ".jsonl") {
+			if !info.IsDir() && isLogFile(info.Name()) {
 				if strings.HasPrefix(info.Name(), cat) {
+					fileInfo := LogFileInfo{
+						Path:     path,
+						Name:     info.Name(),
+						Category: cat,
+						Size:     info.Size(),
+						ModTime:  info.ModTime().UnixNano(),
+					}
 					if startBound != "" || endBound != "" {
 						firstTime, lastTime, ok := readLogTimeBounds(path)
 						if ok {
@@ -127,24 +171,62 @@ func ListLogFiles(opts SearchOptions) (map[string]string, error) {
 							if startBound != "" && lastTime < startBound {
 								return nil
 							}
+							fileInfo.FirstTime, fileInfo.LastTime = firstTime, lastTime
 						}
 					}
-					result[path] = info.Name()
+					result = append(result, fileInfo)
 				}
 			}
 			return nil
 		})
-		//if err != nil {
-		//	logger.Warn(nil, "walk log dir error", zap.Error(err))
-		//	//fmt.Printf("warn: %v\n", err)
-		//	return nil, nil
-		//}
 	}
 
+	for _, cat := range categories {
+		src := sourceByName(cat)
+		if src == nil {
+			continue
+		}
+		for _, path := range src.files(opts.RootDir) {
+			st, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			fileInfo := LogFileInfo{
+				Path:     path,
+				Name:     st.Name(),
+				Category: cat,
+				Size:     st.Size(),
+				ModTime:  st.ModTime().UnixNano(),
+			}
+			if startBound != "" || endBound != "" {
+				firstTime, lastTime, ok := readLogTimeBounds(path)
+				if ok {
+					if endBound != "" && firstTime > endBound {
+						continue
+					}
+					if startBound != "" && lastTime < startBound {
+						continue
+					}
+					fileInfo.FirstTime, fileInfo.LastTime = firstTime, lastTime
+				}
+			}
+			result = append(result, fileInfo)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ModTime != result[j].ModTime {
+			return result[i].ModTime > result[j].ModTime
+		}
+		return result[i].Path < result[j].Path
+	})
 	return result, nil
 }
 
 func readLogTimeBounds(path string) (string, string, bool) {
+	if isArchive(path) {
+		return readArchiveTimeBounds(path)
+	}
 	f, err := os.Open(path)
 	if err != nil {
 		return "", "", false
@@ -173,6 +255,7 @@ func readFirstRecordTimeString(f *os.File) (string, bool) {
 		return "", false
 	}
 
+	parser := parserFor(f.Name())
 	reader := bufio.NewReader(f)
 	for {
 		line, err := reader.ReadString('\n')
@@ -192,7 +275,7 @@ func readFirstRecordTimeString(f *os.File) (string, bool) {
 			}
 			continue
 		}
-		rec := parseLineToLogRecord(line)
+		rec := parser.Parse(line)
 		if rec != nil {
 			t := normalizeRecordTimeString(rec.Time)
 			if t != "" {
@@ -207,24 +290,48 @@ func readFirstRecordTimeString(f *os.File) (string, bool) {
 	return "", false
 }
 
+// SearchLogs returns one page of matching records, see SearchLogsPage.
 func SearchLogs(opts SearchOptions) ([]MatchedRecord, *errors.Error) {
+	page, err := SearchLogsPage(opts)
+	if err != nil || page == nil {
+		return nil, err
+	}
+	return page.Records, nil
+}
+
+// SearchLogsPage returns matching records ordered by time across all files, newest
+// first unless opts.Order is asc, with cursors for the following and preceding pages.
+func SearchLogsPage(opts SearchOptions) (*SearchPage, *errors.Error) {
+	return SearchLogsPageContext(context.Background(), opts)
+}
+
+// SearchLogsPageContext is SearchLogsPage stopped by ctx and by the search budget,
+// om.log.search_timeout (or opts.Timeout when shorter) and om.log.search_max_bytes.
+// When either stops it the page holds what was found so far and Truncated is set.
+func SearchLogsPageContext(ctx context.Context, opts SearchOptions) (*SearchPage, *errors.Error) {
+	budget, cancel := newScanBudget(ctx, time.Duration(opts.Timeout)*time.Second)
+	defer cancel()
+
 	if opts.Size <= 0 {
 		opts.Size = 10
 	}
+	if e := compileQuery(&opts); e != nil {
+		return nil, e
+	}
 
 	traceID := strings.TrimSpace(opts.TraceID)
 	if traceID != "" {
 		missingStart := strings.TrimSpace(opts.StartTime) == ""
 		missingEnd := strings.TrimSpace(opts.EndTime) == ""
 		if missingStart || missingEnd {
-			return searchLogsWithTraceTimeWindow(opts)
+			return searchLogsWithTraceTimeWindow(opts, budget)
 		}
 	}
 
-	return searchLogsOnce(opts)
+	return searchLogsOnce(opts, budget)
 }
 
-func searchLogsWithTraceTimeWindow(opts SearchOptions) ([]MatchedRecord, *errors.Error) {
+func searchLogsWithTraceTimeWindow(opts SearchOptions, budget *scanBudget) (*SearchPage, *errors.Error) {
 	traceID := strings.TrimSpace(opts.TraceID)
 	id, err := xid.FromString(traceID)
 	if err != nil {
@@ -243,8 +350,8 @@ func searchLogsWithTraceTimeWindow(opts SearchOptions) ([]MatchedRecord, *errors
 		endTime := baseTime.Add(1 * time.Hour)
 		narrowed.EndTime = endTime.Format("2006-01-02 15:04:05")
 
-		result, err := searchLogsOnce(narrowed)
-		if err != nil || len(result) > 0 {
+		result, err := searchLogsOnce(narrowed, budget)
+		if err != nil || (result != nil && len(result.Records) > 0) {
 			return result, err
 		}
 
@@ -254,108 +361,187 @@ func searchLogsWithTraceTimeWindow(opts SearchOptions) ([]MatchedRecord, *errors
 		}
 
 		narrowed.EndTime = now.Format("2006-01-02 15:04:05")
-		return searchLogsOnce(narrowed)
+		return searchLogsOnce(narrowed, budget)
 	}
 
-	return searchLogsOnce(narrowed)
+	return searchLogsOnce(narrowed, budget)
 }
 
-func searchLogsOnce(opts SearchOptions) ([]MatchedRecord, *errors.Error) {
+func searchLogsOnce(opts SearchOptions, budget *scanBudget) (*SearchPage, *errors.Error) {
 	normalizeTimeBounds(&opts)
 	if opts.TimeBoundsInvalid {
-		return nil, nil
+		return &SearchPage{Records: []MatchedRecord{}}, nil
 	}
 
-	files, err := ListLogFiles(opts)
+	order, err := normalizeOrder(opts.Order)
 	if err != nil {
 		return nil, errors.Verify(err.Error())
 	}
-
-	fileList := make([]string, 0)
-	for _, v := range files {
-		fileList = append(fileList, v)
+	cursor, e := searchCursorOf(opts, order)
+	if e != nil {
+		return nil, e
 	}
-	//logger.Info(nil, "log files", zap.Any("files", fileList))
 
-	var matchedRecords []MatchedRecord
-	var matchedCount int
-	lastRecordFound := false
-	startProcessing := opts.LastPath == ""
+	files, err := listLogFileInfos(opts)
+	if err != nil {
+		return nil, errors.Verify(err.Error())
+	}
 
-	for filePath := range files {
-		if !startProcessing && filePath == opts.LastPath {
-			startProcessing = true
-		}
-		if !startProcessing {
-			continue
+	sel := newSelector(order, cursor, opts.Size+1)
+	progress := SearchProgress{Files: len(files)}
+	for i := range files {
+		progress.Bytes += files[i].Size
+		if files[i].FirstTime == "" {
+			files[i].FirstTime, files[i].LastTime, _ = readLogTimeBounds(files[i].Path)
 		}
+	}
+	sortFilesFor(files, sel.below)
+
+	used := budget.used()
+	if e = scanFiles(files, opts, sel, order, cursor, budget, &progress); e != nil {
+		return nil, e
+	}
+	progress.ScannedBytes = budget.used() - used
 
-		f, err := os.Open(filePath)
+	result := sel.page(order, cursor, opts.Size)
+	result.Progress = progress
+	result.Truncated = budget.exhausted()
+	return result, nil
+}
+
+// searchCursorOf decodes opts.Cursor, or turns the legacy LastPath/LastLine into a next cursor.
+func searchCursorOf(opts SearchOptions, order string) (*searchCursor, *errors.Error) {
+	if strings.TrimSpace(opts.Cursor) != "" {
+		cursor, err := decodeCursor(opts.Cursor)
 		if err != nil {
-			return nil, errors.Verify(fmt.Sprintf("open file error: %v", err))
+			return nil, errors.Verify(err.Error())
 		}
-		defer f.Close()
+		if cursor.Order != order {
+			return nil, errors.Verify("cursor was made for another order")
+		}
+		return cursor, nil
+	}
+	if opts.LastPath == "" {
+		return nil, nil
+	}
+	path, e := resolveLogPath(opts.RootDir, opts.LastPath)
+	if e != nil {
+		return nil, e
+	}
+	lines, e := FetchContextLines(path, opts.LastLine, 0)
+	if e != nil {
+		return nil, e
+	}
+	if len(lines) == 0 {
+		return nil, errors.Verify("lastPath/lastLine does not point to a record")
+	}
+	key := keyOf(MatchedRecord{FilePath: opts.LastPath, LineNumber: opts.LastLine, Record: lines[0].Record})
+	return &searchCursor{recordKey: key, Order: order, Dir: dirNext}, nil
+}
 
-		reader := bufio.NewReader(f)
-		var lineNumber int64 = 0
+// scanLogFile reads the file and hands every matching record to fn. A valid sidecar index
+// takes trace lookups straight to their lines and time-bounded searches past earlier records.
+func scanLogFile(filePath string, opts SearchOptions, fn func(MatchedRecord), budget *scanBudget) *errors.Error {
+	if idx := getIndex(filePath); idx != nil {
+		return scanIndexed(filePath, idx, opts, fn, budget)
+	}
+	f, err := openLog(filePath)
+	if err != nil {
+		return errors.Verify(fmt.Sprintf("open file error: %v", err))
+	}
+	defer f.Close()
 
-		for {
-			line, err := reader.ReadString('\n')
-			lineNumber++
+	scanLines(filePath, bufio.NewReader(f), 0, parserFor(filePath), opts, fn, budget)
+	return nil
+}
 
-			if filePath == opts.LastPath && lineNumber <= opts.LastLine {
-				if err == io.EOF {
-					break
-				}
-				continue
-			}
+func scanIndexed(filePath string, idx *logIndex, opts SearchOptions, fn func(MatchedRecord), budget *scanBudget) *errors.Error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return errors.Verify(fmt.Sprintf("open file error: %v", err))
+	}
+	defer f.Close()
 
-			if len(line) > maxLineSize && !endsWithNewline(line) {
-				skipRestOfLine(reader)
+	var offset, lineNumber int64
+	reader := bufio.NewReader(f)
+	if traceID := strings.TrimSpace(opts.TraceID); traceID != "" {
+		var entries []indexEntry
+		entries, offset, lineNumber = idx.trace(traceID)
+		for _, entry := range entries {
+			if _, err = f.Seek(entry.Offset, io.SeekStart); err != nil {
+				return errors.Verify(fmt.Sprintf("seek file error: %v", err))
 			}
-
-			if strings.TrimSpace(line) == "" {
-				if err == io.EOF {
-					break
-				}
-				continue
+			reader.Reset(f)
+			line, _ := reader.ReadString('\n')
+			if !budget.read(len(line)) || !budget.alive() {
+				return nil
 			}
+			matchLine(filePath, line, entry.Line, jsonlParser{}, opts, fn)
+		}
+	} else if opts.StartBound != "" {
+		offset, lineNumber = idx.seekTime(opts.StartBound)
+	}
 
-			if !preFilter(line, opts) {
-				continue
-			}
+	// the part written after the last indexing run
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return errors.Verify(fmt.Sprintf("seek file error: %v", err))
+	}
+	reader.Reset(f)
+	scanLines(filePath, reader, lineNumber, jsonlParser{}, opts, fn, budget)
+	return nil
+}
 
-			//var recMap map[string]interface{}
-			//if err := json.Unmarshal([]byte(line), &recMap); err != nil {
-			//	continue
-			//}
-			//rec := map2LogRecord(recMap)
-			rec := parseLineToLogRecord(line)
-
-			if postFilter(*rec, opts) {
-				matchedRecords = append(matchedRecords, MatchedRecord{
-					FilePath:   filePath,
-					LineNumber: lineNumber,
-					Record:     rec,
-				})
-				matchedCount++
-				if matchedCount >= opts.Size {
-					lastRecordFound = true
-					break
-				}
-			}
+// scanLines reads to the end, or until the budget runs out, numbering lines after lineNumber.
+func scanLines(filePath string, reader *bufio.Reader, lineNumber int64, parser LineParser, opts SearchOptions, fn func(MatchedRecord), budget *scanBudget) {
+	for {
+		line, err := reader.ReadString('\n')
+		lineNumber++
+		if !budget.read(len(line)) || (lineNumber%checkEvery == 0 && !budget.alive()) {
+			return
+		}
 
-			if err == io.EOF {
+		if len(line) > maxLineSize && !endsWithNewline(line) {
+			skipRestOfLine(reader)
+		}
+
+		if strings.TrimSpace(line) == "" {
+			if err != nil {
 				break
 			}
+			continue
 		}
 
-		if lastRecordFound {
+		matchLine(filePath, line, lineNumber, parser, opts, fn)
+
+		if err != nil {
 			break
 		}
 	}
+}
 
-	return matchedRecords, nil
+// matchLine hands the line to fn when it matches. The raw line pre-filters only hold for gorig
+// JSON, lines of other parsers are parsed first and matched on the record alone. Records are
+// redacted before they are matched, so a filter cannot probe a masked value.
+func matchLine(filePath, line string, lineNumber int64, parser LineParser, opts SearchOptions, fn func(MatchedRecord)) {
+	if !isJSONL(parser) {
+		if opts.Keyword != "" && !strings.Contains(line, opts.Keyword) {
+			return
+		}
+		if rec := opts.redact.Record(parser.Parse(line)); rec != nil && matchRecord(*rec, opts) {
+			fn(MatchedRecord{FilePath: filePath, LineNumber: lineNumber, Record: rec})
+		}
+		return
+	}
+	if preFilter(line, opts) {
+		rec := opts.redact.Record(parseLineToLogRecord(line))
+		if postFilter(*rec, opts) {
+			fn(MatchedRecord{
+				FilePath:   filePath,
+				LineNumber: lineNumber,
+				Record:     rec,
+			})
+		}
+	}
 }
 
 func preFilter(line string, opts SearchOptions) bool {
@@ -380,6 +566,9 @@ func preFilter(line string, opts SearchOptions) bool {
 	if opts.Keyword != "" && !strings.Contains(line, opts.Keyword) {
 		return false
 	}
+	if !opts.query.PreMatch(line) {
+		return false
+	}
 	return true
 }
 
@@ -405,6 +594,10 @@ func postFilter(r LogRecord, opts SearchOptions) bool {
 		}
 	}
 
+	if !opts.query.Match(&r) {
+		return false
+	}
+
 	if opts.Keyword != "" {
 		kw := opts.Keyword
 		if strings.Contains(r.Msg, kw) {
@@ -469,7 +662,12 @@ func matchRecord(r LogRecord, opts SearchOptions) bool {
 		}
 	}
 
-	// 4. Keyword
+	// 4. Query expression
+	if !opts.query.Match(&r) {
+		return false
+	}
+
+	// 5. Keyword
 	if opts.Keyword != "" {
 		kw := opts.Keyword
 		if strings.Contains(r.Msg, kw) {
@@ -553,15 +751,22 @@ func FetchContextLines(filePath string, centerLine, contextRange int64) ([]Conte
 	}
 	endLine := centerLine + contextRange
 
-	f, err := os.Open(filePath)
+	f, err := openLog(filePath)
 	if err != nil {
 		return nil, errors.Verify(fmt.Sprintf("open file error: %v", err))
 	}
 	defer f.Close()
 
-	reader := bufio.NewReader(f)
 	var result []ContextLogLine
 	var currentLine int64 = 0
+	parser := parserFor(filePath)
+	if idx := getIndex(filePath); idx != nil {
+		offset, line := idx.seekLine(startLine)
+		if _, err = f.(io.Seeker).Seek(offset, io.SeekStart); err == nil {
+			currentLine = line
+		}
+	}
+	reader := bufio.NewReader(f)
 
 	for {
 		line, err := reader.ReadString('\n')
@@ -590,12 +795,19 @@ func FetchContextLines(filePath string, centerLine, contextRange int64) ([]Conte
 			continue
 		}
 
-		dataMap := map[string]interface{}{}
-		if err := json.Unmarshal([]byte(line), &dataMap); err != nil {
-			//logger.Error(nil, "unmarshal record error", zap.Error(err))
+		var rec *LogRecord
+		if isJSONL(parser) {
+			dataMap := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(line), &dataMap); err != nil {
+				//logger.Error(nil, "unmarshal record error", zap.Error(err))
+				continue
+			}
+			rec = map2LogRecord(dataMap)
+		} else if strings.TrimSpace(line) != "" {
+			rec = parser.Parse(line)
+		} else {
 			continue
 		}
-		rec := map2LogRecord(dataMap)
 		result = append(result, ContextLogLine{
 			FilePath:   filePath,
 			LineNumber: currentLine,
@@ -624,9 +836,20 @@ func skipRestOfLine(reader *bufio.Reader) {
 	}
 }
 
-// MonitorLogs monitors logs based on categories and conditions in real-time
+// MonitorLogs streams every new record matching the options as it is written, following
+// rotated and newly created files, with a heartbeat event every om.log.monitor_heartbeat.
+// All monitors of a log directory share one tail hub, so each file is read once.
 func MonitorLogs(ctx *gin.Context, opts SearchOptions) *errors.Error {
 	normalizeTimeBounds(&opts)
+	if e := compileQuery(&opts); e != nil {
+		return e
+	}
+
+	sub, err := subscribe(opts)
+	if err != nil {
+		return errors.Verify(fmt.Sprintf("unable to watch log files: %v", err))
+	}
+	defer sub.close()
 
 	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 	ctx.Writer.Header().Set("Content-Type", "text/event-stream")
@@ -634,77 +857,37 @@ func MonitorLogs(ctx *gin.Context, opts SearchOptions) *errors.Error {
 	ctx.Writer.Header().Set("Connection", "keep-alive")
 	ctx.Writer.Flush()
 
-	// Initialize fsnotify watcher
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return errors.Verify(fmt.Sprintf("unable to create watcher: %v", err))
-	}
-	defer watcher.Close()
-
-	// Get the files to monitor based on categories
-	files, err := ListLogFiles(opts)
-	if err != nil {
-		return errors.Verify(fmt.Sprintf("unable to list log files: %v", err))
-	}
-
-	// Add files to the watcher
-	for file, _ := range files {
-		err = watcher.Add(file)
-		if err != nil {
-			return errors.Verify(fmt.Sprintf("unable to add file to watcher: %v", err))
-		}
-	}
-
 	ctx.SSEvent("message", "monitoring started")
 	ctx.Writer.Flush()
 	defer func() {
 		ctx.SSEvent("message", "monitoring stopped")
 		ctx.Writer.Flush()
 	}()
-	// Monitor file events and process new logs
+
+	heartbeat := time.NewTicker(monitorHeartbeat)
+	defer heartbeat.Stop()
 	for {
 		select {
-		case event := <-watcher.Events:
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				result, errP := processFile(event.Name, opts)
-				if errP != nil {
-					return errP
-				}
-				if result != nil {
-					//fmt.Println("Matching log:", *result)
-					ctx.SSEvent("message", result)
-					ctx.Writer.Flush()
-				}
+		case <-sub.notify:
+			records, dropped := sub.take()
+			if dropped > 0 {
+				ctx.SSEvent("dropped", DroppedNotice{Dropped: dropped})
 			}
-		case err := <-watcher.Errors:
-			if err != nil {
-				return errors.Verify(fmt.Sprintf("watcher error: %v", err))
+			for _, m := range records {
+				ctx.SSEvent("message", m)
 			}
+			ctx.Writer.Flush()
+		case <-heartbeat.C:
+			ctx.SSEvent("heartbeat", time.Now().Unix())
+			ctx.Writer.Flush()
+		case <-sub.done:
+			return errors.Verify(sub.err.Error())
 		case <-ctx.Request.Context().Done():
 			return nil
 		}
 	}
 }
 
-// processFile processes a log file and prints the last matching record
-func processFile(filePath string, opts SearchOptions) (*MatchedRecord, *errors.Error) {
-	f, err := os.Open(filePath)
-	if err != nil {
-		return nil, errors.Verify(fmt.Sprintf("unable to open log file: %v", err))
-	}
-	defer f.Close()
-
-	lastRec, errR := readLastRecord(f)
-	if errR != nil {
-		return nil, errors.Verify(fmt.Sprintf("unable to read last record: %v", errR))
-	}
-
-	if lastRec != nil && matchRecord(*lastRec.Record, opts) {
-		return lastRec, nil
-	}
-	return nil, nil
-}
-
 // map2LogRecord
 func map2LogRecord(dataMap map[string]interface{}) *LogRecord {
 	rec := &LogRecord{}
@@ -773,6 +956,7 @@ func readLastRecord(f *os.File) (*MatchedRecord, *errors.Error) {
 	}
 
 	const chunkSize = 4096
+	parser := parserFor(f.Name())
 	buf := make([]byte, chunkSize)
 	offset := int64(0)
 	size := fi.Size()
@@ -812,7 +996,7 @@ func readLastRecord(f *os.File) (*MatchedRecord, *errors.Error) {
 			if strings.TrimSpace(string(line)) == "" {
 				continue
 			}
-			record := parseLineToLogRecord(string(line))
+			record := parser.Parse(string(line))
 			if record == nil || record.Time == "" {
 				continue
 			}
@@ -848,20 +1032,3 @@ func takeLastLine(tail []byte, fullRead bool) ([]byte, []byte, bool) {
 	}
 	return tail[idx+1 : end], tail[:idx+1], true
 }
-
-// DownloadLogs downloads logs based on categories and conditions
-func DownloadLogs(ctx *gin.Context, path string) *errors.Error {
-	if strings.Contains(path, "..") || !strings.HasSuffix(path, ".jsonl") {
-		return errors.Verify("invalid log file")
-	}
-
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return errors.Verify("log file does not exist")
-	}
-
-	ctx.Header("Content-Type", "application/octet-stream")
-	ctx.Header("Content-Disposition", "attachment; filename="+filepath.Base(path))
-
-	ctx.File(path)
-	return nil
-}
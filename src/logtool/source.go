@@ -0,0 +1,261 @@
+package logtool
+
+import (
+	"context"
+	"fmt"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/logger"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// LineParser turns one line of a log file into a record. It returns nil for a line that holds none.
+type LineParser interface {
+	Parse(line string) *LogRecord
+}
+
+// ParserFactory builds the parser of a source from its settings.
+type ParserFactory func(src LogSource) (LineParser, error)
+
+const (
+	ParserJSONL  = "jsonl"
+	ParserText   = "text"
+	ParserLogfmt = "logfmt"
+	ParserNginx  = "nginx"
+)
+
+// LogSource is a set of files outside .logs that is searched, monitored and read as one category.
+type LogSource struct {
+	Name       string `json:"name"`                 // category name
+	Path       string `json:"path"`                 // file glob, relative to the application directory unless absolute
+	Parser     string `json:"parser"`               // jsonl, text, logfmt, nginx or a registered plug-in
+	TimeRegexp string `json:"timeRegexp,omitempty"` // text: where the time is in a line, its first group if it has one
+	TimeLayout string `json:"timeLayout,omitempty"` // text: Go layout of that time, common layouts are recognised when empty
+
+	parser LineParser
+}
+
+var parsers = struct {
+	sync.RWMutex
+	m map[string]ParserFactory
+}{m: map[string]ParserFactory{
+	ParserJSONL:  func(LogSource) (LineParser, error) { return jsonlParser{}, nil },
+	ParserText:   newTextParser,
+	ParserLogfmt: func(LogSource) (LineParser, error) { return logfmtParser{}, nil },
+	ParserNginx:  func(LogSource) (LineParser, error) { return nginxParser{}, nil },
+}}
+
+var sources = struct {
+	sync.RWMutex
+	list []*LogSource
+}{}
+
+// sourceFiles remembers the parser of each source file listed, so scans only need the path.
+var sourceFiles = struct {
+	sync.RWMutex
+	m map[string]LineParser
+}{m: make(map[string]LineParser)}
+
+// builtinSources are the files written next to the application by the restart and watchdog scripts.
+var builtinSources = []LogSource{
+	{Name: "nohup", Path: "nohup.out", Parser: ParserText},
+	{Name: "restart", Path: "restart.log", Parser: ParserText},
+	{Name: "watchdog", Path: "watchdog.out", Parser: ParserText},
+	{Name: "restart_logs", Path: "restart_logs/*.log", Parser: ParserText},
+}
+
+func init() {
+	if configure.GetBool("om.log.builtin_sources", true) {
+		for _, src := range builtinSources {
+			if err := RegisterSource(src); err != nil {
+				logger.Warn(context.Background(), "Register log source failed", zap.String("source", src.Name), zap.Error(err))
+			}
+		}
+	}
+	conf := configure.GetSub("om.log.sources")
+	names := make([]string, 0, len(conf))
+	for name := range conf {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		item := cast.ToStringMapString(conf[name])
+		src := LogSource{
+			Name:       name,
+			Path:       item["path"],
+			Parser:     item["parser"],
+			TimeRegexp: item["time_regexp"],
+			TimeLayout: item["time_layout"],
+		}
+		if err := RegisterSource(src); err != nil {
+			logger.Warn(context.Background(), "Register log source failed", zap.String("source", name), zap.Error(err))
+		}
+	}
+}
+
+// RegisterParser adds a parser plug-in, or replaces the one of the same name.
+func RegisterParser(name string, factory ParserFactory) {
+	parsers.Lock()
+	defer parsers.Unlock()
+	parsers.m[name] = factory
+}
+
+// RegisterSource adds a log source. A source of the same name is replaced.
+func RegisterSource(src LogSource) error {
+	src.Name = strings.TrimSpace(src.Name)
+	src.Path = strings.TrimSpace(src.Path)
+	if src.Name == "" || strings.HasPrefix(src.Name, ".") || strings.ContainsAny(src.Name, `/\`) {
+		return fmt.Errorf("invalid source name: %q", src.Name)
+	}
+	if src.Path == "" {
+		return fmt.Errorf("source %s has no path", src.Name)
+	}
+	if _, err := filepath.Match(src.Path, ""); err != nil {
+		return fmt.Errorf("invalid path of source %s: %v", src.Name, err)
+	}
+	if src.Parser == "" {
+		src.Parser = ParserText
+	}
+	parsers.RLock()
+	factory := parsers.m[src.Parser]
+	parsers.RUnlock()
+	if factory == nil {
+		return fmt.Errorf("unknown parser of source %s: %s", src.Name, src.Parser)
+	}
+	parser, err := factory(src)
+	if err != nil {
+		return fmt.Errorf("parser of source %s: %v", src.Name, err)
+	}
+	src.parser = parser
+
+	sources.Lock()
+	defer sources.Unlock()
+	for i, old := range sources.list {
+		if old.Name == src.Name {
+			sources.list[i] = &src
+			return nil
+		}
+	}
+	sources.list = append(sources.list, &src)
+	return nil
+}
+
+// Sources lists the registered log sources.
+func Sources() []LogSource {
+	sources.RLock()
+	defer sources.RUnlock()
+	out := make([]LogSource, 0, len(sources.list))
+	for _, src := range sources.list {
+		out = append(out, *src)
+	}
+	return out
+}
+
+func sourceByName(name string) *LogSource {
+	sources.RLock()
+	defer sources.RUnlock()
+	for _, src := range sources.list {
+		if src.Name == name {
+			return src
+		}
+	}
+	return nil
+}
+
+// pattern is the absolute glob of the source files under rootDir.
+func (s *LogSource) pattern(rootDir string) string {
+	pattern := s.Path
+	if !filepath.IsAbs(pattern) {
+		if rootDir == "" {
+			rootDir = "."
+		}
+		pattern = filepath.Join(rootDir, pattern)
+	}
+	if abs, err := filepath.Abs(pattern); err == nil {
+		pattern = abs
+	}
+	return pattern
+}
+
+// files lists the regular files of the source and remembers their parser.
+func (s *LogSource) files(rootDir string) []string {
+	matches, _ := filepath.Glob(s.pattern(rootDir))
+	files := make([]string, 0, len(matches))
+	for _, path := range matches {
+		if st, err := os.Stat(path); err == nil && st.Mode().IsRegular() {
+			files = append(files, path)
+			rememberSourceFile(path, s.parser)
+		}
+	}
+	return files
+}
+
+func rememberSourceFile(path string, parser LineParser) {
+	sourceFiles.Lock()
+	defer sourceFiles.Unlock()
+	sourceFiles.m[path] = parser
+}
+
+// matches reports whether the path is a file of the source.
+func (s *LogSource) matches(rootDir, path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	ok, _ := filepath.Match(s.pattern(rootDir), abs)
+	return ok
+}
+
+// sourceOf returns the registered source the path belongs to, nil when there is none.
+func sourceOf(rootDir, path string) *LogSource {
+	sources.RLock()
+	defer sources.RUnlock()
+	for _, src := range sources.list {
+		if src.matches(rootDir, path) {
+			return src
+		}
+	}
+	return nil
+}
+
+// sourceCategories lists the sources that have at least one file.
+func sourceCategories(rootDir string) []string {
+	sources.RLock()
+	list := append([]*LogSource(nil), sources.list...)
+	sources.RUnlock()
+	names := make([]string, 0, len(list))
+	for _, src := range list {
+		if len(src.files(rootDir)) > 0 {
+			names = append(names, src.Name)
+		}
+	}
+	return names
+}
+
+// parserFor returns the parser of a file: the one of its source when it was listed as a
+// source file or is one of the application directory, gorig JSONL otherwise.
+func parserFor(path string) LineParser {
+	sourceFiles.RLock()
+	p := sourceFiles.m[path]
+	sourceFiles.RUnlock()
+	if p != nil {
+		return p
+	}
+	if src := sourceOf("", path); src != nil {
+		return src.parser
+	}
+	return jsonlParser{}
+}
+
+// isSourceFile reports whether the file was listed from a source rather than from .logs.
+func isSourceFile(path string) bool {
+	sourceFiles.RLock()
+	defer sourceFiles.RUnlock()
+	_, ok := sourceFiles.m[path]
+	return ok
+}
@@ -0,0 +1,339 @@
+package logtool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/fsnotify/fsnotify"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	monitorHeartbeat = 15 * time.Second
+	monitorBuffer    = 1000
+)
+
+func init() {
+	monitorHeartbeat = configure.GetDuration("om.log.monitor_heartbeat", monitorHeartbeat)
+	if monitorHeartbeat < time.Second {
+		monitorHeartbeat = time.Second
+	}
+	monitorBuffer = configure.GetInt("om.log.monitor_buffer", monitorBuffer)
+	if monitorBuffer < 1 {
+		monitorBuffer = 1
+	}
+}
+
+// tailFile is the read position in one live log file. The handle stays open, so lines
+// written just before a rotation are still read after the file is renamed.
+type tailFile struct {
+	f      *os.File
+	path   string
+	cat    string
+	parser LineParser
+	offset int64 // end of the last complete line read
+	line   int64 // number of that line
+}
+
+// tailer follows every live .jsonl file of the categories, including files and
+// categories created after it started, and hands out each complete new line once.
+// The files of the registered sources are followed the same way.
+type tailer struct {
+	rootDir    string
+	logDir     string
+	categories map[string]bool // empty follows every category
+	watcher    *fsnotify.Watcher
+	files      map[string]*tailFile
+	moved      []movedFile // files renamed away, to be recognised under their new name
+}
+
+type movedFile struct {
+	info   os.FileInfo
+	offset int64
+	line   int64
+}
+
+const maxMoved = 16
+
+func newTailer(rootDir string, categories []string) (*tailer, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	t := &tailer{
+		rootDir:    rootDir,
+		logDir:     getLogDir(rootDir),
+		categories: make(map[string]bool),
+		watcher:    watcher,
+		files:      make(map[string]*tailFile),
+	}
+	for _, cat := range categories {
+		t.categories[cat] = true
+	}
+	// the log dir itself is watched to notice new categories
+	if err = watcher.Add(t.logDir); err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+	entries, err := os.ReadDir(t.logDir)
+	if err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && t.wants(entry.Name()) {
+			if err = t.watchDir(entry.Name(), false); err != nil {
+				t.close()
+				return nil, err
+			}
+		}
+	}
+	for _, src := range Sources() {
+		if !t.wants(src.Name) {
+			continue
+		}
+		// a source whose directory does not exist yet is not followed
+		_ = watcher.Add(filepath.Dir(src.pattern(rootDir)))
+		for _, path := range src.files(rootDir) {
+			t.open(path, src.Name, false)
+		}
+	}
+	return t, nil
+}
+
+func (t *tailer) wants(cat string) bool {
+	if strings.HasPrefix(cat, ".") {
+		return false
+	}
+	return len(t.categories) == 0 || t.categories[cat]
+}
+
+// watchDir follows the category directory, from the end of its files or, for a new category, from the start.
+func (t *tailer) watchDir(cat string, fromStart bool) error {
+	dir := filepath.Join(t.logDir, cat)
+	if err := t.watcher.Add(dir); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			t.open(filepath.Join(dir, entry.Name()), cat, fromStart)
+		}
+	}
+	return nil
+}
+
+func (t *tailer) open(path, cat string, fromStart bool) *tailFile {
+	var parser LineParser = jsonlParser{}
+	if src := sourceOf(t.rootDir, path); src != nil {
+		if src.Name != cat {
+			return nil
+		}
+		parser = src.parser
+		rememberSourceFile(path, parser)
+	} else if name := filepath.Base(path); !strings.HasSuffix(name, logExt) || !strings.HasPrefix(name, cat) {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	tf := &tailFile{f: f, path: path, cat: cat, parser: parser}
+	if !fromStart {
+		tf.offset, tf.line = completeLines(f, path)
+	}
+	t.files[path] = tf
+	return tf
+}
+
+// completeLines counts the complete lines of the file, starting from its index when there is one.
+func completeLines(f *os.File, path string) (int64, int64) {
+	var offset, lines int64
+	if idx := getIndex(path); idx != nil {
+		idx.mu.RLock()
+		offset, lines = idx.Size, idx.Lines
+		idx.mu.RUnlock()
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0
+	}
+	end := offset
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			lines += int64(bytes.Count(buf[:n], []byte{'\n'}))
+			if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+				end = offset + int64(i) + 1
+			}
+			offset += int64(n)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return end, lines
+}
+
+// read hands out the complete lines written since the last read. A file cut shorter is read
+// again from the start, and a file replaced under the same name is finished, then followed anew.
+func (t *tailer) read(tf *tailFile, emit func(string, []MatchedRecord)) {
+	if cur, err := os.Stat(tf.path); err == nil {
+		if old, err := tf.f.Stat(); err == nil && !os.SameFile(old, cur) {
+			t.drop(tf, emit)
+			if tf = t.follow(tf.path, tf.cat); tf == nil {
+				return
+			}
+		}
+	}
+	t.readFrom(tf, emit)
+}
+
+func (t *tailer) readFrom(tf *tailFile, emit func(string, []MatchedRecord)) {
+	if st, err := tf.f.Stat(); err == nil && st.Size() < tf.offset {
+		tf.offset, tf.line = 0, 0
+	}
+	if _, err := tf.f.Seek(tf.offset, io.SeekStart); err != nil {
+		return
+	}
+	reader := bufio.NewReader(tf.f)
+	batch := make([]MatchedRecord, 0)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// the incomplete last line is read once it is finished
+			break
+		}
+		tf.offset += int64(len(line))
+		tf.line++
+		if len(line) > maxLineSize || strings.TrimSpace(line) == "" {
+			continue
+		}
+		if rec := tf.parser.Parse(line); rec != nil {
+			batch = append(batch, MatchedRecord{FilePath: tf.path, LineNumber: tf.line, Record: rec})
+		}
+	}
+	if len(batch) > 0 {
+		emit(tf.cat, batch)
+	}
+}
+
+// drop reads what is left in a renamed or removed file and stops following it.
+func (t *tailer) drop(tf *tailFile, emit func(string, []MatchedRecord)) {
+	t.readFrom(tf, emit)
+	if info, err := tf.f.Stat(); err == nil {
+		t.moved = append(t.moved, movedFile{info: info, offset: tf.offset, line: tf.line})
+		if len(t.moved) > maxMoved {
+			t.moved = t.moved[len(t.moved)-maxMoved:]
+		}
+	}
+	_ = tf.f.Close()
+	delete(t.files, tf.path)
+}
+
+// follow starts on a file that just appeared. A file renamed from one already read, such as
+// a rotated backup, goes on from where it was; anything else is read from the start.
+func (t *tailer) follow(path, cat string) *tailFile {
+	tf := t.open(path, cat, true)
+	if tf == nil {
+		return nil
+	}
+	if info, err := tf.f.Stat(); err == nil {
+		for i, m := range t.moved {
+			if os.SameFile(m.info, info) {
+				tf.offset, tf.line = m.offset, m.line
+				t.moved = append(t.moved[:i], t.moved[i+1:]...)
+				break
+			}
+		}
+	}
+	return tf
+}
+
+func (t *tailer) handle(event fsnotify.Event, emit func(string, []MatchedRecord)) {
+	dir, name := filepath.Split(event.Name)
+	if filepath.Clean(dir) == filepath.Clean(t.logDir) {
+		if event.Op&fsnotify.Create != 0 && t.wants(name) {
+			if st, err := os.Stat(event.Name); err == nil && st.IsDir() {
+				if t.watchDir(name, true) == nil {
+					for _, tf := range t.files {
+						if tf.cat == name {
+							t.readFrom(tf, emit)
+						}
+					}
+				}
+			}
+		}
+		return
+	}
+
+	cat := filepath.Base(filepath.Clean(dir))
+	if src := sourceOf(t.rootDir, event.Name); src != nil {
+		if !t.wants(src.Name) {
+			return
+		}
+		cat = src.Name
+	} else if filepath.Dir(filepath.Clean(dir)) != filepath.Clean(t.logDir) {
+		return // another file in a source directory
+	}
+
+	tf := t.files[event.Name]
+	switch {
+	case event.Op&(fsnotify.Rename|fsnotify.Remove) != 0:
+		if tf != nil {
+			t.drop(tf, emit)
+		}
+	case event.Op&(fsnotify.Create|fsnotify.Write) != 0:
+		if tf == nil {
+			// a file we did not know yet is new, all of it is unread
+			if tf = t.follow(event.Name, cat); tf == nil {
+				return
+			}
+		}
+		t.read(tf, emit)
+	}
+}
+
+// run follows the files until ctx is done. Every file is also read each poll interval, in case an event was missed.
+func (t *tailer) run(ctx context.Context, emit func(string, []MatchedRecord), poll time.Duration) error {
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-t.watcher.Events:
+			if !ok {
+				return nil
+			}
+			t.handle(event, emit)
+		case err, ok := <-t.watcher.Errors:
+			if !ok {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		case <-ticker.C:
+			for _, tf := range t.files {
+				t.read(tf, emit)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (t *tailer) close() {
+	for _, tf := range t.files {
+		_ = tf.f.Close()
+	}
+	t.files = map[string]*tailFile{}
+	_ = t.watcher.Close()
+}
@@ -0,0 +1,319 @@
+package logtool
+
+import (
+	"context"
+	"github.com/jom-io/gorig/utils/errors"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	maxTraceRecords = 5000
+	maxHotspots     = 5
+
+	HotspotGap  = "gap"  // time between two consecutive records
+	HotspotSpan = "span" // time spent in a span outside its child spans
+)
+
+type TraceOptions struct {
+	TraceID   string `json:"traceID" form:"traceID" binding:"required"`
+	StartTime string `json:"startTime" form:"startTime"` // narrows the search, taken from the trace ID when empty
+	EndTime   string `json:"endTime" form:"endTime"`
+	Unmasked  bool   `json:"unmasked" form:"unmasked"` // see SearchOptions.Unmasked
+	RootDir   string `json:"-" form:"-"`
+
+	redact *Redactor
+}
+
+// TraceTimeline is every record of a trace in time order, with IN/OUT pairs turned into a span tree
+// that can be drawn as a waterfall. Offsets and durations are milliseconds from the first record.
+type TraceTimeline struct {
+	TraceID    string         `json:"traceID"`
+	Start      string         `json:"start"`
+	End        string         `json:"end"`
+	DurationMs int64          `json:"durationMs"`
+	Categories []string       `json:"categories"`
+	Records    []TraceRecord  `json:"records"`
+	Spans      []*TraceSpan   `json:"spans"`    // top level spans, children nested
+	Hotspots   []TraceHotspot `json:"hotspots"` // where most of the time went, longest first
+	Truncated  bool           `json:"truncated"`
+}
+
+type TraceRecord struct {
+	MatchedRecord
+	Category string `json:"category"`
+	OffsetMs int64  `json:"offsetMs"`
+	GapMs    int64  `json:"gapMs"`  // since the previous record
+	SpanID   int    `json:"spanId"` // innermost span the record belongs to, 0 for none
+}
+
+type TraceSpan struct {
+	ID         int          `json:"id"`
+	Category   string       `json:"category"`
+	Name       string       `json:"name"`
+	Status     string       `json:"status,omitempty"`
+	OffsetMs   int64        `json:"offsetMs"`
+	DurationMs int64        `json:"durationMs"`
+	SelfMs     int64        `json:"selfMs"` // duration not covered by child spans
+	Open       bool         `json:"open"`   // no OUT record, the span ends with the trace
+	In         int          `json:"in"`     // index of the IN record
+	Out        int          `json:"out"`    // index of the OUT record, -1 when open
+	Events     []int        `json:"events"` // indexes of the other records inside the span
+	Children   []*TraceSpan `json:"children"`
+
+	start, end time.Time
+}
+
+type TraceHotspot struct {
+	Kind       string  `json:"kind"`
+	SpanID     int     `json:"spanId,omitempty"`
+	From       int     `json:"from"` // record indexes
+	To         int     `json:"to"`
+	DurationMs int64   `json:"durationMs"`
+	Share      float64 `json:"share"` // of the whole trace
+}
+
+// BuildTraceTimeline collects the records of the trace from every category.
+func BuildTraceTimeline(ctx context.Context, opts TraceOptions) (*TraceTimeline, *errors.Error) {
+	traceID := strings.TrimSpace(opts.TraceID)
+	if traceID == "" {
+		return nil, errors.Verify("traceID is required")
+	}
+	page, e := SearchLogsPageContext(ctx, SearchOptions{
+		RootDir:   opts.RootDir,
+		TraceID:   traceID,
+		StartTime: opts.StartTime,
+		EndTime:   opts.EndTime,
+		Order:     OrderAsc,
+		Size:      maxTraceRecords,
+		redact:    opts.redact,
+	})
+	if e != nil {
+		return nil, e
+	}
+	files, err := listLogFileInfos(SearchOptions{RootDir: opts.RootDir})
+	if err != nil {
+		return nil, errors.Verify(err.Error())
+	}
+	categories := make(map[string]string, len(files))
+	for _, file := range files {
+		categories[file.Path] = file.Category
+	}
+	timeline := TraceTimelineOf(traceID, page.Records, categories)
+	timeline.Truncated = page.HasMore || page.Truncated
+	return timeline, nil
+}
+
+// TraceTimelineOf builds the timeline from records sorted by time. Each OUT closes the latest
+// open IN of the same category, so nested calls pair up like apistat pairs requests.
+// categories maps a file path to its category as listed by listLogFileInfos; records of
+// unlisted files fall back to the name of their directory under .logs.
+func TraceTimelineOf(traceID string, records []MatchedRecord, categories map[string]string) *TraceTimeline {
+	t := &TraceTimeline{
+		TraceID:    traceID,
+		Categories: []string{},
+		Records:    make([]TraceRecord, 0, len(records)),
+		Spans:      []*TraceSpan{},
+		Hotspots:   []TraceHotspot{},
+	}
+	if len(records) == 0 {
+		return t
+	}
+
+	times := make([]time.Time, len(records))
+	var first, last time.Time
+	for i, m := range records {
+		if m.Record != nil {
+			times[i], _ = parseRecordTime(m.Record.Time)
+		}
+		if times[i].IsZero() {
+			continue
+		}
+		if first.IsZero() || times[i].Before(first) {
+			first = times[i]
+		}
+		if times[i].After(last) {
+			last = times[i]
+		}
+	}
+	for i := range times {
+		if times[i].IsZero() {
+			times[i] = first
+		}
+	}
+	t.Start, t.End = first.Format(recordTimeLayout), last.Format(recordTimeLayout)
+	t.DurationMs = last.Sub(first).Milliseconds()
+
+	seenCat := make(map[string]bool)
+	open := make(map[string][]*TraceSpan)
+	spans := make([]*TraceSpan, 0)
+	for i, m := range records {
+		cat, ok := categories[m.FilePath]
+		if !ok {
+			cat = filepath.Base(filepath.Dir(m.FilePath))
+		}
+		if !seenCat[cat] {
+			seenCat[cat] = true
+			t.Categories = append(t.Categories, cat)
+		}
+		rec := TraceRecord{MatchedRecord: m, Category: cat, OffsetMs: times[i].Sub(first).Milliseconds()}
+		if i > 0 {
+			rec.GapMs = times[i].Sub(times[i-1]).Milliseconds()
+		}
+		t.Records = append(t.Records, rec)
+		if m.Record == nil {
+			continue
+		}
+
+		switch strings.ToUpper(strings.TrimSpace(m.Record.Msg)) {
+		case "IN":
+			span := &TraceSpan{
+				ID:       len(spans) + 1,
+				Category: cat,
+				Name:     spanName(cat, m.Record),
+				In:       i,
+				Out:      -1,
+				Events:   []int{},
+				Children: []*TraceSpan{},
+				start:    times[i],
+			}
+			spans = append(spans, span)
+			open[cat] = append(open[cat], span)
+		case "OUT":
+			stack := open[cat]
+			if len(stack) == 0 {
+				continue
+			}
+			span := stack[len(stack)-1]
+			open[cat] = stack[:len(stack)-1]
+			span.Out, span.end = i, times[i]
+			if status, ok := m.Record.Data["status"]; ok {
+				span.Status = status
+			}
+		}
+	}
+	for _, span := range spans {
+		if span.Out < 0 {
+			span.Open, span.end = true, last
+		}
+		span.OffsetMs = span.start.Sub(first).Milliseconds()
+		span.DurationMs = span.end.Sub(span.start).Milliseconds()
+	}
+
+	t.Spans = nestSpans(spans)
+	for i := range t.Records {
+		if span := innermostSpan(t.Spans, times[i]); span != nil {
+			t.Records[i].SpanID = span.ID
+			if i != span.In && i != span.Out {
+				span.Events = append(span.Events, i)
+			}
+		}
+	}
+	t.Hotspots = hotspots(t, spans)
+	return t
+}
+
+func spanName(cat string, r *LogRecord) string {
+	parts := make([]string, 0, 2)
+	for _, key := range []string{"method", "uri"} {
+		if v := strings.TrimSpace(r.Data[key]); v != "" {
+			parts = append(parts, v)
+		}
+	}
+	if len(parts) > 0 {
+		return strings.Join(parts, " ")
+	}
+	for _, key := range []string{"func", "name", "service"} {
+		if v := strings.TrimSpace(r.Data[key]); v != "" {
+			return v
+		}
+	}
+	return cat
+}
+
+// nestSpans makes each span a child of the latest started span that contains it and computes self times.
+func nestSpans(spans []*TraceSpan) []*TraceSpan {
+	sorted := append([]*TraceSpan(nil), spans...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].start.Equal(sorted[j].start) {
+			return sorted[i].start.Before(sorted[j].start)
+		}
+		return sorted[i].end.After(sorted[j].end)
+	})
+	roots := make([]*TraceSpan, 0)
+	stack := make([]*TraceSpan, 0)
+	for _, span := range sorted {
+		for len(stack) > 0 && stack[len(stack)-1].end.Before(span.end) {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			roots = append(roots, span)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, span)
+		}
+		stack = append(stack, span)
+	}
+	for _, span := range spans {
+		span.SelfMs = span.DurationMs - coveredMs(span.Children)
+	}
+	return roots
+}
+
+// coveredMs is the time covered by the spans, overlaps counted once.
+func coveredMs(spans []*TraceSpan) int64 {
+	var total int64
+	var end time.Time
+	for _, s := range spans {
+		start := s.start
+		if start.Before(end) {
+			start = end
+		}
+		if s.end.After(start) {
+			total += s.end.Sub(start).Milliseconds()
+			end = s.end
+		}
+	}
+	return total
+}
+
+func innermostSpan(spans []*TraceSpan, at time.Time) *TraceSpan {
+	for i := len(spans) - 1; i >= 0; i-- {
+		s := spans[i]
+		if !at.Before(s.start) && !at.After(s.end) {
+			if child := innermostSpan(s.Children, at); child != nil {
+				return child
+			}
+			return s
+		}
+	}
+	return nil
+}
+
+func hotspots(t *TraceTimeline, spans []*TraceSpan) []TraceHotspot {
+	out := make([]TraceHotspot, 0)
+	share := func(ms int64) float64 {
+		if t.DurationMs <= 0 {
+			return 0
+		}
+		return math.Round(float64(ms)/float64(t.DurationMs)*10000) / 10000
+	}
+	for i := 1; i < len(t.Records); i++ {
+		if gap := t.Records[i].GapMs; gap > 0 {
+			out = append(out, TraceHotspot{Kind: HotspotGap, From: i - 1, To: i, DurationMs: gap, Share: share(gap)})
+		}
+	}
+	for _, s := range spans {
+		if s.SelfMs > 0 {
+			out = append(out, TraceHotspot{Kind: HotspotSpan, SpanID: s.ID, From: s.In, To: s.Out, DurationMs: s.SelfMs, Share: share(s.SelfMs)})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].DurationMs > out[j].DurationMs })
+	if len(out) > maxHotspots {
+		out = out[:maxHotspots]
+	}
+	return out
+}
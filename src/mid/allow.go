@@ -0,0 +1,43 @@
+package mid
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig-om/src/allow"
+	"github.com/jom-io/gorig-om/src/omuser"
+	"github.com/jom-io/gorig/apix/response"
+	"net"
+)
+
+// Allow rejects callers whose address is not on the OM allowlist, and keeps the
+// resolved address for the handlers that bind nonces, tickets and lockouts to it.
+func Allow() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := allow.S().ClientIP(c.Request)
+		if !allow.S().Allowed(ip) {
+			response.ErrorForbidden(c)
+			return
+		}
+		setClientIP(c, ip)
+		c.Next()
+	}
+}
+
+// AllowLocal is Allow for callbacks made by this host itself, such as the restart
+// script, which must keep working whatever the allowlist says.
+func AllowLocal() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := allow.S().ClientIP(c.Request)
+		if !allow.IsLocal(c.Request) && !allow.S().Allowed(ip) {
+			response.ErrorForbidden(c)
+			return
+		}
+		setClientIP(c, ip)
+		c.Next()
+	}
+}
+
+func setClientIP(c *gin.Context, ip net.IP) {
+	if ip != nil {
+		omuser.SetClientIP(c, ip.String())
+	}
+}
@@ -0,0 +1,90 @@
+package mid
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig-om/src/allow"
+	"github.com/jom-io/gorig-om/src/audit"
+	"github.com/jom-io/gorig-om/src/omuser"
+	"github.com/jom-io/gorig/apix"
+	"github.com/jom-io/gorig/global/consts"
+	"github.com/tidwall/gjson"
+	"io"
+	"net/http"
+	"time"
+)
+
+const maxAuditBody = 64 << 10
+
+type auditWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.body.Len() < maxAuditBody {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditWriter) WriteString(s string) (int, error) {
+	if w.body.Len() < maxAuditBody {
+		w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Audit records who called a mutating route, with what parameters and how it ended.
+// It must run after Sign so the actor is known, and before Interactive and Perm so
+// refused calls are recorded with their 403.
+func Audit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		var body []byte
+		if c.Request.Body != nil {
+			body, _ = io.ReadAll(io.LimitReader(c.Request.Body, maxAuditBody))
+			c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+		}
+		writer := &auditWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		actor, role := omuser.Current(c)
+		ip := ""
+		// not c.ClientIP, which believes X-Forwarded-For from any peer
+		if addr := allow.S().ClientIP(c.Request); addr != nil {
+			ip = addr.String()
+		}
+		log := audit.AuditLog{
+			At:       start.Unix(),
+			Actor:    actor,
+			Token:    omuser.CurrentApiToken(c),
+			Role:     role.String(),
+			IP:       ip,
+			Method:   c.Request.Method,
+			Route:    c.FullPath(),
+			Params:   audit.MaskParams(c.Request.URL.Query(), body),
+			Status:   c.Writer.Status(),
+			Duration: time.Since(start).Milliseconds(),
+			TraceID:  apix.GetTraceID(c),
+		}
+		if log.Route == "" {
+			log.Route = c.Request.URL.Path
+		}
+		log.Result, log.Message = auditResult(log.Status, writer.body.Bytes())
+		audit.S().Record(c, log)
+	}
+}
+
+func auditResult(status int, resp []byte) (audit.Result, string) {
+	if status >= http.StatusBadRequest {
+		return audit.ResultFailed, http.StatusText(status)
+	}
+	code := gjson.GetBytes(resp, "code")
+	if code.Exists() && code.Int() != int64(consts.CurdStatusOkCode) {
+		return audit.ResultFailed, gjson.GetBytes(resp, "msg").String()
+	}
+	return audit.ResultSuccess, ""
+}
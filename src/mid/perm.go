@@ -0,0 +1,19 @@
+package mid
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig-om/src/omuser"
+	"github.com/jom-io/gorig/apix/response"
+)
+
+// Perm rejects the request unless the account authenticated by Sign holds the permission
+// and, for API tokens, the token was issued with it as a scope.
+func Perm(p omuser.Perm) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !omuser.Allowed(c, p) {
+			response.ErrorForbidden(c)
+			return
+		}
+		c.Next()
+	}
+}
@@ -10,26 +10,94 @@ import (
 
 func Sign() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		sign := c.GetHeader("Authorization")
-		if sign == "" || !strings.HasPrefix(sign, "Bearer ") {
-			if c.Query("token") == "" {
-				response.ErrorForbidden(c)
-				return
-			}
-			sign = c.Query("token")
+		signBearer(c)
+	}
+}
+
+// SignStream is Sign for SSE and download routes: besides the Authorization header it
+// accepts a ?ticket= minted by om/auth/ticket for the same path.
+func SignStream() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value := c.Query("ticket")
+		if value == "" {
+			signBearer(c)
+			return
+		}
+		username, ok := omuser.RedeemTicket(c, value)
+		if !ok {
+			response.ErrorTokenAuthFail(c)
+			return
+		}
+		role, ok := omuser.RoleOf(c, username)
+		if !ok {
+			response.ErrorTokenAuthFail(c)
+			return
 		}
-		sign = strings.TrimPrefix(sign, "Bearer ")
-		get := tokenx.Get(tokenx.Jwt, tokenx.Memory)
-		if _, err := get.Generator.Parse(sign); err != nil {
+		omuser.SetCurrent(c, username, role)
+		c.Next()
+	}
+}
+
+func signBearer(c *gin.Context) {
+	sign := c.GetHeader("Authorization")
+	if sign == "" || !strings.HasPrefix(sign, "Bearer ") {
+		response.ErrorForbidden(c)
+		return
+	}
+	sign = strings.TrimPrefix(sign, "Bearer ")
+	if omuser.IsApiToken(sign) {
+		signApiToken(c, sign)
+		return
+	}
+	get := tokenx.Get(tokenx.Jwt, tokenx.Memory)
+	if claims, err := get.Generator.Parse(sign); err != nil {
+		response.ErrorForbidden(c)
+	} else {
+		if userID, exist := get.Manager.GetUserID(sign); !exist {
+			response.ErrorTokenAuthFail(c)
+			return
+		} else if !omuser.IsOM(userID) {
+			response.ErrorForbidden(c)
+			return
+		}
+		if !omuser.CheckSession(c, sign, claims) {
+			response.ErrorTokenAuthFail(c)
+			return
+		}
+		username := omuser.UsernameOf(claims)
+		role, ok := omuser.RoleOf(c, username)
+		if !ok {
+			response.ErrorTokenAuthFail(c)
+			return
+		}
+		omuser.SetCurrent(c, username, role)
+		c.Next()
+	}
+}
+
+func signApiToken(c *gin.Context, sign string) {
+	item, ok := omuser.CheckApiToken(c, sign)
+	if !ok {
+		response.ErrorTokenAuthFail(c)
+		return
+	}
+	role, ok := omuser.RoleOf(c, item.Username)
+	if !ok {
+		response.ErrorTokenAuthFail(c)
+		return
+	}
+	omuser.SetCurrent(c, item.Username, role)
+	c.Next()
+}
+
+// Interactive rejects requests made with an API token, for routes that manage the
+// login itself (sessions, second factor, tokens) and must not be reachable by scripts.
+func Interactive() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if omuser.CurrentApiToken(c) != "" {
 			response.ErrorForbidden(c)
-		} else {
-			if userID, exist := get.Manager.GetUserID(sign); !exist {
-				response.ErrorTokenAuthFail(c)
-				return
-			} else if !omuser.IsOM(userID) {
-				response.ErrorForbidden(c)
-			}
-			c.Next()
+			return
 		}
+		c.Next()
 	}
 }
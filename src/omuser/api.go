@@ -0,0 +1,213 @@
+package omuser
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig/apix"
+	"github.com/jom-io/gorig/global/consts"
+	"time"
+)
+
+func Challenge(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	result, err := NewChallenge(ctx)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+func OIDCLogin(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	result, err := OIDCStart(ctx)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+func OIDCConnect(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	req := OIDCCallbackReq{}
+	e := apix.BindParams(ctx, &req, true)
+	if e != nil {
+		return
+	}
+	start := time.Now()
+	username, result, err := OIDCCallback(ctx, req)
+	recordLogin(ctx, username, start, err)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+func Me(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	username, role := Current(ctx)
+	perms := make([]Perm, 0)
+	for _, p := range PermsOf(role) {
+		if Allowed(ctx, p) {
+			perms = append(perms, p)
+		}
+	}
+	apix.HandleData(ctx, consts.CurdSelectFailCode, &CurrentUser{
+		Username: username,
+		Role:     role,
+		Perms:    perms,
+	}, nil)
+}
+
+func Page(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	pageReq, e := apix.GetPageReq(ctx)
+	if e != nil {
+		return
+	}
+	result, err := S().Page(ctx, pageReq.Page, pageReq.Size)
+	if result != nil {
+		for _, item := range result.Items {
+			if item != nil {
+				item.PwdHash = ""
+			}
+		}
+	}
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+func Save(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	req := SaveUserReq{}
+	e := apix.BindParams(ctx, &req, true)
+	if e != nil {
+		return
+	}
+	err := S().Save(ctx, req)
+	apix.HandleData(ctx, consts.CurdUpdateFailCode, nil, err)
+}
+
+func Delete(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	username, e := apix.GetParamForce(ctx, "username")
+	if e != nil {
+		return
+	}
+	err := S().Delete(ctx, username)
+	apix.HandleData(ctx, consts.CurdDeleteFailCode, nil, err)
+}
+
+func Ticket(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	path, e := apix.GetParamForce(ctx, "path")
+	if e != nil {
+		return
+	}
+	result, err := NewTicket(ctx, path)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+func Logout(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	err := S().Logout(ctx)
+	apix.HandleData(ctx, consts.CurdDeleteFailCode, nil, err)
+}
+
+func Refresh(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	result, err := S().Refresh(ctx)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+func Sessions(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	pageReq, e := apix.GetPageReq(ctx)
+	if e != nil {
+		return
+	}
+	result, err := S().Sessions(ctx, pageReq.Page, pageReq.Size)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+func RevokeSession(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	req := RevokeSessionReq{}
+	e := apix.BindParams(ctx, &req, true)
+	if e != nil {
+		return
+	}
+	err := S().Revoke(ctx, req)
+	apix.HandleData(ctx, consts.CurdDeleteFailCode, nil, err)
+}
+
+func TotpStatusGet(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	username, _ := Current(ctx)
+	result, err := S().TotpStatus(ctx, username)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+func TotpEnroll(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	username, _ := Current(ctx)
+	result, err := S().TotpEnroll(ctx, username)
+	apix.HandleData(ctx, consts.CurdUpdateFailCode, result, err)
+}
+
+func TotpConfirm(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	code, e := apix.GetParamForce(ctx, "code")
+	if e != nil {
+		return
+	}
+	username, _ := Current(ctx)
+	result, err := S().TotpConfirm(ctx, username, code)
+	apix.HandleData(ctx, consts.CurdUpdateFailCode, result, err)
+}
+
+func TotpDisable(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	code, e := apix.GetParamForce(ctx, "code")
+	if e != nil {
+		return
+	}
+	username, _ := Current(ctx)
+	err := S().TotpDisable(ctx, username, code)
+	apix.HandleData(ctx, consts.CurdUpdateFailCode, nil, err)
+}
+
+func TotpReset(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	username, e := apix.GetParamForce(ctx, "username")
+	if e != nil {
+		return
+	}
+	err := S().TotpReset(ctx, username)
+	apix.HandleData(ctx, consts.CurdDeleteFailCode, nil, err)
+}
+
+func ApiTokenCreate(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	req := CreateApiTokenReq{}
+	e := apix.BindParams(ctx, &req, true)
+	if e != nil {
+		return
+	}
+	username, role := Current(ctx)
+	result, err := S().CreateApiToken(ctx, username, role, req)
+	apix.HandleData(ctx, consts.CurdUpdateFailCode, result, err)
+}
+
+func ApiTokenPage(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	pageReq, e := apix.GetPageReq(ctx)
+	if e != nil {
+		return
+	}
+	username, role := Current(ctx)
+	if role.Can(PermUserManage) && ctx.Query("all") == "true" {
+		username = ""
+	}
+	result, err := S().ApiTokens(ctx, username, pageReq.Page, pageReq.Size)
+	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
+}
+
+func ApiTokenRevoke(ctx *gin.Context) {
+	defer apix.HandlePanic(ctx)
+	id, e := apix.GetParamForce(ctx, "id")
+	if e != nil {
+		return
+	}
+	username, role := Current(ctx)
+	err := S().RevokeApiToken(ctx, username, role, id)
+	apix.HandleData(ctx, consts.CurdDeleteFailCode, nil, err)
+}
@@ -0,0 +1,176 @@
+package omuser
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig/cache"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// ApiTokenPrefix marks an API token in the Authorization header, tokens look like omt_<id>_<secret>.
+const ApiTokenPrefix = "omt_"
+
+const (
+	ctxApiTokenKey = "om_api_token"
+	ctxScopesKey   = "om_scopes"
+
+	maxApiTokenDays = 3650
+)
+
+// IsApiToken reports whether the bearer value is an API token rather than a session token.
+func IsApiToken(token string) bool {
+	return strings.HasPrefix(token, ApiTokenPrefix)
+}
+
+// CreateApiToken issues a token for the account with a subset of its permissions.
+func (s *Serv) CreateApiToken(ctx context.Context, username string, role Role, req CreateApiTokenReq) (*CreateApiTokenOut, *errors.Error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 64 {
+		return nil, errors.Verify("Invalid name, use 1-64 characters")
+	}
+	if len(req.Scopes) == 0 {
+		return nil, errors.Verify("At least one scope is required")
+	}
+	for _, p := range req.Scopes {
+		if _, ok := permRoles[p]; !ok {
+			return nil, errors.Verify(fmt.Sprintf("Unknown scope: %s", p))
+		}
+		if !role.Can(p) {
+			return nil, errors.Verify(fmt.Sprintf("Scope %s exceeds the permissions of %s", p, username))
+		}
+	}
+	if req.ExpireIn < 0 || req.ExpireIn > maxApiTokenDays {
+		return nil, errors.Verify(fmt.Sprintf("expireIn must be between 0 and %d days", maxApiTokenDays))
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.Sys("Generate API token failed", err)
+	}
+	now := time.Now().Unix()
+	id := xid.New().String()
+	token := fmt.Sprintf("%s%s_%s", ApiTokenPrefix, id, hex.EncodeToString(secret))
+	item := OMApiToken{
+		ID:       id,
+		Name:     req.Name,
+		Username: username,
+		Hash:     hashToken(token),
+		Scopes:   req.Scopes,
+		CreateAt: now,
+	}
+	if req.ExpireIn > 0 {
+		item.ExpireAt = now + req.ExpireIn*24*3600
+	}
+	if err := s.apiTokens.Put(item); err != nil {
+		return nil, errors.Sys("Save API token failed", err)
+	}
+	logger.Info(ctx, fmt.Sprintf("API token created: %s(%s), owner: %s, scopes: %v", item.Name, item.ID, username, item.Scopes))
+	return &CreateApiTokenOut{ID: id, Token: token}, nil
+}
+
+// ApiTokens lists the tokens of an account, or of every account when username is empty.
+func (s *Serv) ApiTokens(ctx context.Context, username string, page, size int64) (*cache.PageCache[OMApiToken], *errors.Error) {
+	if page <= 0 {
+		page = 1
+	}
+	if size <= 0 {
+		size = 20
+	}
+	cond := map[string]any{}
+	if username != "" {
+		cond["username"] = username
+	}
+	result, err := s.apiTokens.Find(page, size, cond, cache.PageSorterDesc("createAt"))
+	if err != nil {
+		return nil, errors.Sys("Page API tokens failed", err)
+	}
+	for _, item := range result.Items {
+		if item != nil {
+			item.Hash = ""
+		}
+	}
+	return result, nil
+}
+
+// RevokeApiToken disables a token. Only its owner or an account manager may do so.
+func (s *Serv) RevokeApiToken(ctx context.Context, username string, role Role, id string) *errors.Error {
+	item, err := s.apiTokens.Get(map[string]any{"id": id})
+	if err != nil {
+		return errors.Sys("Get API token failed", err)
+	}
+	if item == nil {
+		return errors.Verify("API token not found")
+	}
+	if item.Username != username && !role.Can(PermUserManage) {
+		return errors.Verify("API token not found")
+	}
+	item.Revoked = true
+	if err = s.apiTokens.Update(map[string]any{"id": id}, item); err != nil {
+		return errors.Sys("Revoke API token failed", err)
+	}
+	logger.Info(ctx, fmt.Sprintf("API token revoked: %s(%s), by: %s", item.Name, item.ID, username))
+	return nil
+}
+
+// CheckApiToken resolves the token to its owner and records its use.
+func CheckApiToken(ctx *gin.Context, token string) (*OMApiToken, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(token, ApiTokenPrefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, false
+	}
+	item, err := S().apiTokens.Get(map[string]any{"id": parts[0]})
+	if err != nil || item == nil || item.Revoked {
+		return nil, false
+	}
+	if subtle.ConstantTimeCompare([]byte(item.Hash), []byte(hashToken(token))) != 1 {
+		return nil, false
+	}
+	now := time.Now().Unix()
+	if item.ExpireAt > 0 && item.ExpireAt < now {
+		return nil, false
+	}
+	if now-item.LastUsedAt >= touchGap || item.LastUsedIP != ClientIP(ctx) {
+		item.LastUsedAt = now
+		item.LastUsedIP = ClientIP(ctx)
+		if err = S().apiTokens.Update(map[string]any{"id": item.ID}, item); err != nil {
+			logger.Error(ctx, "Touch API token failed", zap.Error(err))
+		}
+	}
+	ctx.Set(ctxApiTokenKey, item.ID)
+	ctx.Set(ctxScopesKey, item.Scopes)
+	return item, true
+}
+
+// CurrentApiToken returns the ID of the API token the request was made with, if any.
+func CurrentApiToken(ctx *gin.Context) string {
+	return ctx.GetString(ctxApiTokenKey)
+}
+
+// Allowed reports whether the request may use the permission: the account's role must
+// grant it and, for API token requests, the token must carry it as a scope.
+func Allowed(ctx *gin.Context, p Perm) bool {
+	_, role := Current(ctx)
+	if !role.Can(p) {
+		return false
+	}
+	v, ok := ctx.Get(ctxScopesKey)
+	if !ok {
+		return true
+	}
+	scopes, _ := v.([]Perm)
+	for _, scope := range scopes {
+		if scope == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package omuser
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig/cache"
+	"github.com/jom-io/gorig/global/variable"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const challengeTTL = 60 * time.Second
+
+type challenge struct {
+	IP       string
+	ExpireAt int64
+}
+
+var (
+	challengeMu sync.Mutex
+	challenges  = cache.New[challenge](cache.Memory, challengeTTL, challengeTTL)
+	// usedLegacy remembers accepted legacy hashes so a captured one cannot be replayed in its window.
+	usedLegacy = cache.New[bool](cache.Memory, 30*time.Second, time.Minute)
+)
+
+// LegacyLoginEnabled reports whether the bcrypt(unix/10 + om.key) handshake of older panels is still accepted.
+func LegacyLoginEnabled() bool {
+	return configure.GetBool("om.auth.legacy_login", true)
+}
+
+// NewChallenge issues a single-use nonce bound to the client IP.
+func NewChallenge(ctx *gin.Context) (*ChallengeOut, *errors.Error) {
+	if variable.OMKey == "" {
+		return nil, errors.Verify("Connection rejected")
+	}
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, errors.Sys("Generate challenge failed", err)
+	}
+	nonce := hex.EncodeToString(buf)
+	expireAt := time.Now().Add(challengeTTL)
+	if err := challenges.Set(nonce, challenge{IP: ClientIP(ctx), ExpireAt: expireAt.Unix()}, challengeTTL); err != nil {
+		return nil, errors.Sys("Save challenge failed", err)
+	}
+	return &ChallengeOut{Nonce: nonce, ExpireAt: expireAt.Unix()}, nil
+}
+
+// takeChallenge consumes the nonce; it can never be used again, whatever the outcome.
+func takeChallenge(nonce string) (challenge, bool) {
+	challengeMu.Lock()
+	defer challengeMu.Unlock()
+	c, err := challenges.Get(nonce)
+	if err != nil {
+		return challenge{}, false
+	}
+	_ = challenges.Del(nonce)
+	return c, c.ExpireAt >= time.Now().Unix()
+}
+
+// ChallengeSign is the response a client computes for a nonce: hex(HMAC-SHA256(om.key, nonce|user)).
+func ChallengeSign(key, nonce, username string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(fmt.Sprintf("%s|%s", nonce, username)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// LoginByChallenge logs in as the root account with the response to a nonce from NewChallenge.
+func LoginByChallenge(ctx *gin.Context, nonce, sign, code string) (*string, *errors.Error) {
+	if variable.OMKey == "" {
+		return nil, errors.Verify("Connection rejected")
+	}
+	IP := fmt.Sprintf("%s-%s", "OM", ClientIP(ctx))
+	if err := checkLock(IP); err != nil {
+		return nil, err
+	}
+
+	c, ok := takeChallenge(nonce)
+	if !ok || c.IP != ClientIP(ctx) {
+		return nil, errors.Verify("Challenge expired, please try again")
+	}
+	expect := ChallengeSign(variable.OMKey, nonce, RootUser)
+	if !hmac.Equal([]byte(expect), []byte(strings.ToLower(strings.TrimSpace(sign)))) {
+		return nil, loginFailed(IP)
+	}
+	if err := secondFactor(ctx, IP, RootUser, code); err != nil {
+		return nil, err
+	}
+
+	clearLoginFailed(IP)
+	return issueToken(ctx, RootUser)
+}
+
+// takeLegacy marks a legacy hash as used and reports whether it was fresh.
+func takeLegacy(hashPwd string) bool {
+	challengeMu.Lock()
+	defer challengeMu.Unlock()
+	if used, _ := usedLegacy.Exists(hashPwd); used {
+		return false
+	}
+	_ = usedLegacy.Set(hashPwd, true, 30*time.Second)
+	return true
+}
@@ -3,6 +3,7 @@ package omuser
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig-om/src/audit"
 	"github.com/jom-io/gorig/apix"
 	"github.com/jom-io/gorig/cache"
 	"github.com/jom-io/gorig/global/consts"
@@ -10,6 +11,7 @@ import (
 	"github.com/jom-io/gorig/mid/tokenx"
 	"github.com/jom-io/gorig/utils/errors"
 	"golang.org/x/crypto/bcrypt"
+	"net/http"
 	"strings"
 	"time"
 )
@@ -21,55 +23,195 @@ type loginCountOut struct {
 	LockTime int64  `json:"lock_time"`
 }
 
+// UserInfoKey is the claim that carries the OM account name in the session token.
+const UserInfoKey = "user"
+
+// ErrTotpRequired is returned by login when the password is right but the account needs a TOTP code.
+const ErrTotpRequired = "TOTP code required"
+
 func Login(ctx *gin.Context) {
 	defer apix.HandlePanic(ctx)
 	pwd, e := apix.GetParamType[string](ctx, "pwd", apix.Force)
 	if e != nil {
 		return
 	}
-	result, err := LoginByPwd(ctx, pwd)
+	username, e := apix.GetParamStr(ctx, "user")
+	if e != nil {
+		return
+	}
+	code, e := apix.GetParamStr(ctx, "code")
+	if e != nil {
+		return
+	}
+	nonce, e := apix.GetParamStr(ctx, "nonce")
+	if e != nil {
+		return
+	}
+	start := time.Now()
+	var result *string
+	var err *errors.Error
+	if username == "" || username == RootUser {
+		username = RootUser
+		if nonce != "" {
+			// pwd carries the HMAC response to the nonce
+			result, err = LoginByChallenge(ctx, nonce, pwd, code)
+		} else {
+			result, err = LoginByPwd(ctx, pwd, code)
+		}
+	} else {
+		result, err = LoginByUser(ctx, username, pwd, code)
+	}
+	recordLogin(ctx, username, start, err)
 	apix.HandleData(ctx, consts.CurdSelectFailCode, result, err)
 }
 
-func LoginByPwd(ctx *gin.Context, hashPwd string) (sign *string, err *errors.Error) {
+func recordLogin(ctx *gin.Context, username string, start time.Time, err *errors.Error) {
+	log := audit.AuditLog{
+		At:       start.Unix(),
+		Actor:    strings.TrimSpace(username),
+		IP:       ClientIP(ctx),
+		Method:   ctx.Request.Method,
+		Route:    ctx.FullPath(),
+		Result:   audit.ResultSuccess,
+		Status:   http.StatusOK,
+		Duration: time.Since(start).Milliseconds(),
+		TraceID:  apix.GetTraceID(ctx),
+	}
+	if role, ok := RoleOf(ctx, log.Actor); ok {
+		log.Role = role.String()
+	}
+	if err != nil {
+		log.Result = audit.ResultFailed
+		log.Message = err.Error()
+	}
+	audit.S().Record(ctx, log)
+}
+
+// LoginByPwd logs in as the root account with a bcrypt hash of unix/10 + om.key.
+// code is the TOTP or recovery code, required once root has enrolled a second factor.
+//
+// Deprecated: a captured hash is valid for anyone within its 10 second window. Use
+// NewChallenge and LoginByChallenge; this flow can be turned off with om.auth.legacy_login.
+func LoginByPwd(ctx *gin.Context, hashPwd, code string) (sign *string, err *errors.Error) {
 	if variable.OMKey == "" {
 		return nil, errors.Verify("Connection rejected")
 	}
-	IP := fmt.Sprintf("%s-%s", "OM", ctx.ClientIP())
+	if !LegacyLoginEnabled() {
+		return nil, errors.Verify("Legacy login is disabled, please upgrade the panel")
+	}
+	IP := fmt.Sprintf("%s-%s", "OM", ClientIP(ctx))
+	if err = checkLock(IP); err != nil {
+		return nil, err
+	}
 
+	now := time.Now().Unix() / 10
+	localPwd := fmt.Sprintf("%d%s", now, variable.OMKey)
+	if e := bcrypt.CompareHashAndPassword([]byte(hashPwd), []byte(localPwd)); e != nil {
+		return nil, loginFailed(IP)
+	}
+	if !takeLegacy(hashPwd) {
+		return nil, loginFailed(IP)
+	}
+	if err = secondFactor(ctx, IP, RootUser, code); err != nil {
+		return nil, err
+	}
+
+	clearLoginFailed(IP)
+	return issueToken(ctx, RootUser)
+}
+
+// LoginByUser logs in as a named OM account with its plain password and, when enrolled, TOTP code.
+// The challenge of NewChallenge only covers root, whose secret is om.key: the server keeps no more
+// than a bcrypt hash of a named account's password, so it cannot check a response bound to a nonce.
+// These passwords rely on TLS between the panel and the application.
+func LoginByUser(ctx *gin.Context, username, pwd, code string) (sign *string, err *errors.Error) {
+	if variable.OMKey == "" {
+		return nil, errors.Verify("Connection rejected")
+	}
+	IP := fmt.Sprintf("%s-%s", "OM", ClientIP(ctx))
+	if err = checkLock(IP); err != nil {
+		return nil, err
+	}
+
+	username = strings.TrimSpace(username)
+	if _, ok := S().Verify(ctx, username, pwd); !ok {
+		return nil, loginFailed(IP)
+	}
+	if err = secondFactor(ctx, IP, username, code); err != nil {
+		return nil, err
+	}
+
+	clearLoginFailed(IP)
+	return issueToken(ctx, username)
+}
+
+// secondFactor checks the TOTP code of an enrolled account. A wrong or missing code counts
+// towards the same lockout as a wrong password, so the prompt for a code cannot be used
+// to test passwords without limit.
+func secondFactor(ctx *gin.Context, IP, username, code string) *errors.Error {
+	required, err := S().TotpRequired(ctx, username)
+	if err != nil || !required {
+		return err
+	}
+	if strings.TrimSpace(code) == "" {
+		_ = loginFailed(IP)
+		if err = checkLock(IP); err != nil {
+			return err
+		}
+		return errors.Verify(ErrTotpRequired)
+	}
+	ok, err := S().CheckTotp(ctx, username, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return loginFailed(IP)
+	}
+	return nil
+}
+
+func clearLoginFailed(IP string) {
+	_ = cache.New[loginCountOut](cache.JSON, "loginErrCount").Del(IP)
+}
+
+func checkLock(IP string) *errors.Error {
 	loginErrCount, _ := cache.New[loginCountOut](cache.JSON, "loginErrCount").Get(IP)
 
 	if loginErrCount.Count >= 5 {
 		if time.Now().Unix() < loginErrCount.LockTime {
-			return nil, errors.Verify(fmt.Sprintf("Connection rejected, please try again after %d minutes", (loginErrCount.LockTime-time.Now().Unix())/60+1))
+			return errors.Verify(fmt.Sprintf("Connection rejected, please try again after %d minutes", (loginErrCount.LockTime-time.Now().Unix())/60+1))
 		}
 		loginErrCount.Count = 0
 		loginErrCount.LockTime = 0
 		_ = cache.New[loginCountOut](cache.JSON, "loginErrCount").Set(IP, loginErrCount, 0)
 	}
+	return nil
+}
 
-	now := time.Now().Unix() / 10
-	localPwd := fmt.Sprintf("%d%s", now, variable.OMKey)
-	if e := bcrypt.CompareHashAndPassword([]byte(hashPwd), []byte(localPwd)); e != nil {
-		loginErrCount.Count++
-		if loginErrCount.Count >= 5 {
-			loginErrCount.LockTime = time.Now().Unix() + 60*10 // lock for 10 minutes
-			_ = cache.New[loginCountOut](cache.JSON, "loginErrCount").Set(IP, loginErrCount, 0)
-			return nil, errors.Verify(fmt.Sprintf("Connection rejected, please try again after %d minutes", (loginErrCount.LockTime-time.Now().Unix())/60+1))
-		} else {
-			_ = cache.New[loginCountOut](cache.JSON, "loginErrCount").Set(IP, loginErrCount, 0)
-			return nil, errors.Verify(fmt.Sprintf("Login failed, %d attempts left", 5-loginErrCount.Count))
-		}
-	}
-
-	_ = cache.New[loginCountOut](cache.JSON, "loginErrCount").Del(IP)
-	tokens, e := tokenx.Get(tokenx.Jwt, tokenx.Memory).Manager.GenerateAndRecord(ctx, IP, nil, time.Now().Unix()+3600)
-	if e != nil {
-		return nil, e
+func loginFailed(IP string) *errors.Error {
+	loginErrCount, _ := cache.New[loginCountOut](cache.JSON, "loginErrCount").Get(IP)
+	loginErrCount.Count++
+	if loginErrCount.Count >= 5 {
+		loginErrCount.LockTime = time.Now().Unix() + 60*10 // lock for 10 minutes
+		_ = cache.New[loginCountOut](cache.JSON, "loginErrCount").Set(IP, loginErrCount, 0)
+		return errors.Verify(fmt.Sprintf("Connection rejected, please try again after %d minutes", (loginErrCount.LockTime-time.Now().Unix())/60+1))
 	}
-	return &tokens, nil
+	_ = cache.New[loginCountOut](cache.JSON, "loginErrCount").Set(IP, loginErrCount, 0)
+	return errors.Verify(fmt.Sprintf("Login failed, %d attempts left", 5-loginErrCount.Count))
 }
 
 func IsOM(userID string) bool {
 	return strings.HasPrefix(userID, "OM")
 }
+
+// UsernameOf returns the OM account carried by the token claims.
+// Tokens issued before named accounts existed belong to the root account.
+func UsernameOf(claims *tokenx.CustomClaims) string {
+	if claims == nil || claims.UserInfo == nil {
+		return RootUser
+	}
+	if v, ok := claims.UserInfo[UserInfoKey].(string); ok && v != "" {
+		return v
+	}
+	return RootUser
+}
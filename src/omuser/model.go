@@ -0,0 +1,208 @@
+package omuser
+
+// RootUser is the built-in account behind the om.key login. It always has the admin role.
+const RootUser = "root"
+
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"   // read logs, stats and deploy records
+	RoleOperator Role = "operator" // viewer + restart/stop the application
+	RoleDeployer Role = "deployer" // operator + run deploy tasks and manage build env
+	RoleAdmin    Role = "admin"    // everything, including OM account management
+)
+
+var Roles = []Role{RoleViewer, RoleOperator, RoleDeployer, RoleAdmin}
+
+var roleRank = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleDeployer: 3,
+	RoleAdmin:    4,
+}
+
+func (r Role) String() string {
+	return string(r)
+}
+
+func (r Role) Valid() bool {
+	_, ok := roleRank[r]
+	return ok
+}
+
+// AtLeast reports whether r is the same as or higher than min.
+func (r Role) AtLeast(min Role) bool {
+	rank, ok := roleRank[r]
+	if !ok {
+		return false
+	}
+	return rank >= roleRank[min]
+}
+
+// Can reports whether the role is granted the permission.
+func (r Role) Can(p Perm) bool {
+	min, ok := permRoles[p]
+	if !ok {
+		return r == RoleAdmin
+	}
+	return r.AtLeast(min)
+}
+
+// Perm is a named permission required by an OM route.
+type Perm string
+
+const (
+	PermLogRead      Perm = "logs:read"     // log search, monitor and download
+	PermLogRule      Perm = "logs:rules"    // log alert rules
+	PermLogManage    Perm = "logs:manage"   // log retention policies and redaction rules
+	PermLogUnmasked  Perm = "logs:unmasked" // log records without redaction, on request
+	PermStatRead     Perm = "stats:read"    // host usage and stat endpoints
+	PermAppRead      Perm = "app:read"      // restart history
+	PermAppRestart   Perm = "app:restart"   // restart and stop the application
+	PermDeployRead   Perm = "deploy:read"   // deploy config, task records and env checks
+	PermDeployStart  Perm = "deploy:start"  // start, stop and roll back deploy tasks
+	PermDeployConfig Perm = "deploy:config" // deploy config, go env, git/go install and ssh key
+	PermUserManage   Perm = "users:manage"  // OM account management
+	PermAuditRead    Perm = "audit:read"    // audit trail and its retention
+	PermAuditManage  Perm = "audit:manage"  // audit retention changes, which can erase the trail
+)
+
+var permRoles = map[Perm]Role{
+	PermLogRead:      RoleViewer,
+	PermLogRule:      RoleOperator,
+	PermLogManage:    RoleAdmin,
+	PermLogUnmasked:  RoleAdmin,
+	PermStatRead:     RoleViewer,
+	PermAppRead:      RoleViewer,
+	PermAppRestart:   RoleOperator,
+	PermDeployRead:   RoleViewer,
+	PermDeployStart:  RoleDeployer,
+	PermDeployConfig: RoleDeployer,
+	PermUserManage:   RoleAdmin,
+	PermAuditRead:    RoleAdmin,
+	PermAuditManage:  RoleAdmin,
+}
+
+func (p Perm) String() string {
+	return string(p)
+}
+
+type OMUser struct {
+	Username string `json:"username"`
+	PwdHash  string `json:"pwdHash"`
+	Role     Role   `json:"role"`
+	Disabled bool   `json:"disabled"`
+	Source   string `json:"source,omitempty"` // empty for local accounts, oidc for accounts created by single sign-on
+	CreateAt int64  `json:"createAt"`
+	UpdateAt int64  `json:"updateAt"`
+}
+
+type SaveUserReq struct {
+	Username string `json:"username" form:"username" binding:"required"`
+	Password string `json:"password" form:"password"`
+	Role     Role   `json:"role" form:"role" binding:"required"`
+	Disabled bool   `json:"disabled" form:"disabled"`
+}
+
+type CurrentUser struct {
+	Username string `json:"username"`
+	Role     Role   `json:"role"`
+	Perms    []Perm `json:"perms"`
+}
+
+// OMSession is one issued login token. The token itself is never stored, only its hash.
+type OMSession struct {
+	ID         string `json:"id"`
+	TokenHash  string `json:"tokenHash"`
+	Username   string `json:"username"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"userAgent"`
+	CreateAt   int64  `json:"createAt"`
+	LastUsedAt int64  `json:"lastUsedAt"`
+	ExpireAt   int64  `json:"expireAt"`
+	Current    bool   `json:"current,omitempty"` // set when listing, true for the caller's own session
+}
+
+type RevokeSessionReq struct {
+	ID       string `json:"id" form:"id"`             // revoke one session
+	Username string `json:"username" form:"username"` // or every session of an account
+}
+
+// OMTotp is the second factor of an account. Recovery codes are stored as hashes.
+type OMTotp struct {
+	Username  string   `json:"username"`
+	Secret    string   `json:"secret"`    // base32 encoded
+	Enabled   bool     `json:"enabled"`   // false until the first code is confirmed
+	Recovery  []string `json:"recovery"`  // sha256 of the unused recovery codes
+	LastStep  int64    `json:"lastStep"`  // last accepted time step, a code is never accepted twice
+	CreateAt  int64    `json:"createAt"`  // enrollment time
+	ConfirmAt int64    `json:"confirmAt"` // confirmation time
+}
+
+type TotpEnrollOut struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // otpauth:// URI to render as QR code
+}
+
+type TotpStatus struct {
+	Enabled      bool `json:"enabled"`
+	RecoveryLeft int  `json:"recoveryLeft"`
+}
+
+// OMApiToken is a long-lived credential for scripts and CI. Only the hash of its secret is stored.
+type OMApiToken struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Username   string `json:"username"` // owner, the token never has more access than this account
+	Hash       string `json:"hash"`
+	Scopes     []Perm `json:"scopes"`
+	CreateAt   int64  `json:"createAt"`
+	ExpireAt   int64  `json:"expireAt"` // 0 means it never expires
+	LastUsedAt int64  `json:"lastUsedAt"`
+	LastUsedIP string `json:"lastUsedIp"`
+	Revoked    bool   `json:"revoked"`
+}
+
+type CreateApiTokenReq struct {
+	Name     string `json:"name" form:"name" binding:"required"`
+	Scopes   []Perm `json:"scopes" form:"scopes" binding:"required"`
+	ExpireIn int64  `json:"expireIn" form:"expireIn"` // days, 0 means it never expires
+}
+
+type CreateApiTokenOut struct {
+	ID    string `json:"id"`
+	Token string `json:"token"` // shown only once
+}
+
+type ChallengeOut struct {
+	Nonce    string `json:"nonce"`
+	ExpireAt int64  `json:"expireAt"`
+}
+
+type TicketOut struct {
+	Ticket   string `json:"ticket"`
+	ExpireAt int64  `json:"expireAt"`
+}
+
+// OIDCConfig configures single sign-on, read from om.oidc.* at startup.
+type OIDCConfig struct {
+	Issuer        string // e.g. https://sso.example.com/realms/ops
+	ClientID      string
+	ClientSecret  string          // empty for public clients, PKCE is always used
+	RedirectURL   string          // panel page the IdP sends the browser back to
+	Scopes        []string        // defaults to openid profile email groups
+	UsernameClaim string          // defaults to preferred_username, then email, then sub
+	GroupsClaim   string          // defaults to groups
+	RoleMap       map[string]Role // IdP group -> OM role, the highest matching role wins
+	DefaultRole   Role            // role for users in no mapped group, empty denies them
+}
+
+type OIDCStartOut struct {
+	URL   string `json:"url"`   // authorization URL to open in the browser
+	State string `json:"state"` // echoed back by the IdP, send it with the code
+}
+
+type OIDCCallbackReq struct {
+	Code  string `json:"code" form:"code" binding:"required"`
+	State string `json:"state" form:"state" binding:"required"`
+}
@@ -0,0 +1,475 @@
+package omuser
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig/cache"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"go.uber.org/zap"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SourceOIDC = "oidc"
+
+	oidcStateTTL     = 10 * time.Minute
+	oidcDiscoveryTTL = time.Hour
+	oidcClockSkew    = int64(60)
+)
+
+type oidcState struct {
+	Nonce    string
+	Verifier string
+	IP       string
+	ExpireAt int64
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type oidcJWK struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+var (
+	oidcMu      sync.Mutex
+	oidcConf    OIDCConfig
+	oidcDisc    *oidcDiscovery
+	oidcDiscAt  time.Time
+	oidcKeys    map[string]*rsa.PublicKey
+	oidcStates  = cache.New[oidcState](cache.Memory, oidcStateTTL, time.Minute)
+	oidcStateMu sync.Mutex
+	oidcClient  = &http.Client{Timeout: 10 * time.Second}
+)
+
+func init() {
+	conf := OIDCConfig{
+		Issuer:        configure.GetString("om.oidc.issuer", ""),
+		ClientID:      configure.GetString("om.oidc.client_id", ""),
+		ClientSecret:  configure.GetString("om.oidc.client_secret", ""),
+		RedirectURL:   configure.GetString("om.oidc.redirect_url", ""),
+		Scopes:        strings.Fields(configure.GetString("om.oidc.scopes", "")),
+		UsernameClaim: configure.GetString("om.oidc.username_claim", ""),
+		GroupsClaim:   configure.GetString("om.oidc.groups_claim", ""),
+		RoleMap:       make(map[string]Role),
+		DefaultRole:   Role(configure.GetString("om.oidc.default_role", "")),
+	}
+	// om.oidc.role_map: "ops-admin=admin, ops=operator, dev=viewer"
+	for _, item := range strings.Split(configure.GetString("om.oidc.role_map", ""), ",") {
+		group, role, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if ok && group != "" {
+			conf.RoleMap[strings.TrimSpace(group)] = Role(strings.TrimSpace(role))
+		}
+	}
+	if conf.Issuer != "" {
+		SetOIDCConfig(conf)
+	}
+}
+
+// SetOIDCConfig enables single sign-on with the given IdP, replacing om.oidc.*.
+func SetOIDCConfig(conf OIDCConfig) {
+	conf.Issuer = strings.TrimRight(conf.Issuer, "/")
+	if len(conf.Scopes) == 0 {
+		conf.Scopes = []string{"openid", "profile", "email", "groups"}
+	}
+	if conf.GroupsClaim == "" {
+		conf.GroupsClaim = "groups"
+	}
+	for group, role := range conf.RoleMap {
+		if !role.Valid() {
+			logger.Error(context.Background(), "Invalid role in OIDC role map, ignored", zap.String("group", group), zap.String("role", role.String()))
+			delete(conf.RoleMap, group)
+		}
+	}
+	if conf.DefaultRole != "" && !conf.DefaultRole.Valid() {
+		logger.Error(context.Background(), "Invalid OIDC default role, ignored", zap.String("role", conf.DefaultRole.String()))
+		conf.DefaultRole = ""
+	}
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	oidcConf = conf
+	oidcDisc = nil
+	oidcKeys = nil
+}
+
+// OIDCEnabled reports whether single sign-on is configured.
+func OIDCEnabled() bool {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	return oidcConf.Issuer != "" && oidcConf.ClientID != ""
+}
+
+func randomURLString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func oidcGetJSON(ctx context.Context, u string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+func discover(ctx context.Context) (*oidcDiscovery, OIDCConfig, error) {
+	oidcMu.Lock()
+	conf := oidcConf
+	disc := oidcDisc
+	fresh := time.Since(oidcDiscAt) < oidcDiscoveryTTL
+	oidcMu.Unlock()
+	if disc != nil && fresh {
+		return disc, conf, nil
+	}
+
+	d := &oidcDiscovery{}
+	if err := oidcGetJSON(ctx, conf.Issuer+"/.well-known/openid-configuration", d); err != nil {
+		return nil, conf, err
+	}
+	if strings.TrimRight(d.Issuer, "/") != conf.Issuer {
+		return nil, conf, fmt.Errorf("issuer mismatch: %s", d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JwksURI == "" {
+		return nil, conf, fmt.Errorf("incomplete discovery document")
+	}
+	oidcMu.Lock()
+	oidcDisc, oidcDiscAt = d, time.Now()
+	oidcMu.Unlock()
+	return d, conf, nil
+}
+
+// OIDCStart begins an authorization-code + PKCE login and returns the URL to open.
+func OIDCStart(ctx *gin.Context) (*OIDCStartOut, *errors.Error) {
+	if !OIDCEnabled() {
+		return nil, errors.Verify("Single sign-on is not configured")
+	}
+	disc, conf, err := discover(ctx)
+	if err != nil {
+		logger.Error(ctx, "OIDC discovery failed", zap.Error(err))
+		return nil, errors.Sys("OIDC discovery failed", err)
+	}
+	state, err := randomURLString(24)
+	if err != nil {
+		return nil, errors.Sys("Generate OIDC state failed", err)
+	}
+	nonce, _ := randomURLString(24)
+	verifier, _ := randomURLString(48)
+	sum := sha256.Sum256([]byte(verifier))
+	if err = oidcStates.Set(state, oidcState{
+		Nonce:    nonce,
+		Verifier: verifier,
+		IP:       ClientIP(ctx),
+		ExpireAt: time.Now().Add(oidcStateTTL).Unix(),
+	}, oidcStateTTL); err != nil {
+		return nil, errors.Sys("Save OIDC state failed", err)
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", conf.ClientID)
+	query.Set("redirect_uri", conf.RedirectURL)
+	query.Set("scope", strings.Join(conf.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+	query.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(disc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return &OIDCStartOut{URL: disc.AuthorizationEndpoint + sep + query.Encode(), State: state}, nil
+}
+
+// OIDCCallback exchanges the code returned by the IdP, verifies the ID token and issues a session.
+func OIDCCallback(ctx *gin.Context, req OIDCCallbackReq) (string, *string, *errors.Error) {
+	if !OIDCEnabled() {
+		return "", nil, errors.Verify("Single sign-on is not configured")
+	}
+	oidcStateMu.Lock()
+	st, err := oidcStates.Get(req.State)
+	if err == nil {
+		_ = oidcStates.Del(req.State)
+	}
+	oidcStateMu.Unlock()
+	if err != nil || st.ExpireAt < time.Now().Unix() || st.IP != ClientIP(ctx) {
+		return "", nil, errors.Verify("Login expired, please try again")
+	}
+
+	disc, conf, err := discover(ctx)
+	if err != nil {
+		return "", nil, errors.Sys("OIDC discovery failed", err)
+	}
+	rawIDToken, err := exchangeCode(ctx, disc, conf, req.Code, st.Verifier)
+	if err != nil {
+		logger.Error(ctx, "OIDC code exchange failed", zap.Error(err))
+		return "", nil, errors.Verify("Single sign-on failed")
+	}
+	claims, err := verifyIDToken(ctx, disc, conf, rawIDToken, st.Nonce)
+	if err != nil {
+		logger.Error(ctx, "OIDC ID token rejected", zap.Error(err))
+		return "", nil, errors.Verify("Single sign-on failed")
+	}
+
+	username := oidcUsername(conf, claims)
+	if username == "" {
+		return "", nil, errors.Verify("Single sign-on failed, no username claim")
+	}
+	role := oidcRole(conf, claims)
+	if role == "" {
+		return username, nil, errors.Verify(fmt.Sprintf("%s is not in any group allowed to use OM", username))
+	}
+	if e := S().saveOIDCUser(ctx, username, role); e != nil {
+		return username, nil, e
+	}
+	token, e := issueToken(ctx, username)
+	return username, token, e
+}
+
+func exchangeCode(ctx context.Context, disc *oidcDiscovery, conf OIDCConfig, code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", conf.RedirectURL)
+	form.Set("client_id", conf.ClientID)
+	form.Set("code_verifier", verifier)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, disc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if conf.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(conf.ClientID), url.QueryEscape(conf.ClientSecret))
+	}
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint: %s %s", resp.Status, body)
+	}
+	out := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	if err = json.Unmarshal(body, &out); err != nil {
+		return "", err
+	}
+	if out.IDToken == "" {
+		return "", fmt.Errorf("token response has no id_token")
+	}
+	return out.IDToken, nil
+}
+
+func jwksKey(ctx context.Context, disc *oidcDiscovery, kid string) (*rsa.PublicKey, error) {
+	oidcMu.Lock()
+	key := oidcKeys[kid]
+	oidcMu.Unlock()
+	if key != nil {
+		return key, nil
+	}
+
+	// Unknown kid: the IdP may have rotated its keys, fetch the set again.
+	set := struct {
+		Keys []oidcJWK `json:"keys"`
+	}{}
+	if err := oidcGetJSON(ctx, disc.JwksURI, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	oidcMu.Lock()
+	oidcKeys = keys
+	oidcMu.Unlock()
+	if keys[kid] == nil {
+		return nil, fmt.Errorf("no RSA key with kid %q", kid)
+	}
+	return keys[kid], nil
+}
+
+func verifyIDToken(ctx context.Context, disc *oidcDiscovery, conf OIDCConfig, raw, nonce string) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed ID token")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported alg %q", header.Alg)
+	}
+	key, err := jwksKey(ctx, disc, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("bad signature: %w", err)
+	}
+
+	claims := make(map[string]any)
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != conf.Issuer {
+		return nil, fmt.Errorf("issuer mismatch: %s", iss)
+	}
+	if !audienceHas(claims["aud"], conf.ClientID) {
+		return nil, fmt.Errorf("audience mismatch")
+	}
+	if exp, ok := claims["exp"].(float64); !ok || int64(exp)+oidcClockSkew < now {
+		return nil, fmt.Errorf("ID token expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && int64(iat)-oidcClockSkew > now {
+		return nil, fmt.Errorf("ID token issued in the future")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("nonce mismatch")
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, out any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func audienceHas(aud any, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []any:
+		for _, item := range v {
+			if s, _ := item.(string); s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func oidcUsername(conf OIDCConfig, claims map[string]any) string {
+	keys := []string{"preferred_username", "email", "sub"}
+	if conf.UsernameClaim != "" {
+		keys = []string{conf.UsernameClaim}
+	}
+	for _, k := range keys {
+		if v, _ := claims[k].(string); strings.TrimSpace(v) != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+func oidcRole(conf OIDCConfig, claims map[string]any) Role {
+	var groups []string
+	switch v := claims[conf.GroupsClaim].(type) {
+	case string:
+		groups = []string{v}
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+	role := conf.DefaultRole
+	for _, g := range groups {
+		if r, ok := conf.RoleMap[g]; ok && (role == "" || r.AtLeast(role)) {
+			role = r
+		}
+	}
+	return role
+}
+
+// saveOIDCUser keeps the account record of a single sign-on user in step with the role
+// from the IdP, so that Sign resolves it like a local account.
+func (s *Serv) saveOIDCUser(ctx context.Context, username string, role Role) *errors.Error {
+	if strings.EqualFold(username, RootUser) {
+		return errors.Verify(fmt.Sprintf("%s is reserved for the om.key login", RootUser))
+	}
+	old, e := s.Get(ctx, username)
+	if e != nil {
+		return e
+	}
+	now := time.Now().Unix()
+	if old == nil {
+		if err := s.storage.Put(OMUser{Username: username, Role: role, Source: SourceOIDC, CreateAt: now, UpdateAt: now}); err != nil {
+			return errors.Sys("Save OM user failed", err)
+		}
+		logger.Info(ctx, fmt.Sprintf("OM user created by single sign-on: %s, role: %s", username, role))
+		return nil
+	}
+	if old.Source != SourceOIDC {
+		return errors.Verify(fmt.Sprintf("%s is a local account and cannot log in by single sign-on", username))
+	}
+	if old.Disabled {
+		return errors.Verify(fmt.Sprintf("%s is disabled", username))
+	}
+	if old.Role != role {
+		old.Role = role
+		old.UpdateAt = now
+		if err := s.storage.Update(map[string]any{"username": username}, old); err != nil {
+			return errors.Sys("Save OM user failed", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package omuser
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig/cache"
+	"github.com/jom-io/gorig/mid/tokenx"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	// SessionInfoKey is the claim that carries the session ID in the token.
+	SessionInfoKey = "sid"
+
+	ctxSessionKey = "om_sid"
+	ctxTokenKey   = "om_token"
+
+	sessionTTL       = int64(3600)
+	touchGap         = int64(60)
+	sessionClearTick = 10 * time.Minute
+)
+
+func init() {
+	go func() {
+		ticker := time.NewTicker(sessionClearTick)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := S().sessions.Delete(map[string]any{"expireAt": map[string]any{"$lt": time.Now().Unix()}}); err != nil {
+				logger.Error(context.Background(), "Clear expired OM sessions failed", zap.Error(err))
+			}
+		}
+	}()
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueToken signs a token for the account and records it as a new session.
+func issueToken(ctx *gin.Context, username string) (*string, *errors.Error) {
+	now := time.Now().Unix()
+	sid := xid.New().String()
+	// The session ID is part of the user ID so that the token manager keeps
+	// concurrent sessions of the same account apart instead of replacing them.
+	userID := fmt.Sprintf("OM-%s@%s#%s", username, ClientIP(ctx), sid)
+	userInfo := map[string]interface{}{UserInfoKey: username, SessionInfoKey: sid}
+	token, e := tokenx.Get(tokenx.Jwt, tokenx.Memory).Manager.GenerateAndRecord(ctx, userID, userInfo, now+sessionTTL)
+	if e != nil {
+		return nil, e
+	}
+	session := OMSession{
+		ID:         sid,
+		TokenHash:  hashToken(token),
+		Username:   username,
+		IP:         ClientIP(ctx),
+		UserAgent:  ctx.Request.UserAgent(),
+		CreateAt:   now,
+		LastUsedAt: now,
+		ExpireAt:   now + sessionTTL,
+	}
+	if err := S().sessions.Put(session); err != nil {
+		tokenx.Get(tokenx.Jwt, tokenx.Memory).Manager.Destroy(token)
+		return nil, errors.Sys("Save OM session failed", err)
+	}
+	return &token, nil
+}
+
+// CheckSession reports whether the token belongs to a live session and marks it as used.
+func CheckSession(ctx *gin.Context, token string, claims *tokenx.CustomClaims) bool {
+	if claims == nil || claims.UserInfo == nil {
+		return false
+	}
+	sid, _ := claims.UserInfo[SessionInfoKey].(string)
+	if sid == "" {
+		return false
+	}
+	session, err := S().sessions.Get(map[string]any{"id": sid})
+	if err != nil || session == nil {
+		return false
+	}
+	now := time.Now().Unix()
+	if session.TokenHash != hashToken(token) || session.ExpireAt < now {
+		return false
+	}
+	if now-session.LastUsedAt >= touchGap {
+		session.LastUsedAt = now
+		if err := S().sessions.Update(map[string]any{"id": sid}, session); err != nil {
+			logger.Error(ctx, "Touch OM session failed", zap.Error(err))
+		}
+	}
+	ctx.Set(ctxSessionKey, sid)
+	ctx.Set(ctxTokenKey, token)
+	return true
+}
+
+// CurrentSession returns the session ID of the request.
+func CurrentSession(ctx *gin.Context) string {
+	return ctx.GetString(ctxSessionKey)
+}
+
+// Logout ends the session of the request.
+func (s *Serv) Logout(ctx *gin.Context) *errors.Error {
+	if token := ctx.GetString(ctxTokenKey); token != "" {
+		tokenx.Get(tokenx.Jwt, tokenx.Memory).Manager.Destroy(token)
+	}
+	if sid := CurrentSession(ctx); sid != "" {
+		if err := s.sessions.Delete(map[string]any{"id": sid}); err != nil {
+			return errors.Sys("Delete OM session failed", err)
+		}
+	}
+	return nil
+}
+
+// Refresh replaces the token of the current session with a new one of full lifetime.
+func (s *Serv) Refresh(ctx *gin.Context) (*string, *errors.Error) {
+	username, _ := Current(ctx)
+	if username == "" {
+		return nil, errors.Verify("Not logged in")
+	}
+	newToken, err := issueToken(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.Logout(ctx); err != nil {
+		logger.Error(ctx, "Revoke refreshed OM session failed", zap.Error(err))
+	}
+	return newToken, nil
+}
+
+// Sessions lists the live sessions, newest first.
+func (s *Serv) Sessions(ctx *gin.Context, page, size int64) (*cache.PageCache[OMSession], *errors.Error) {
+	if page <= 0 {
+		page = 1
+	}
+	if size <= 0 {
+		size = 20
+	}
+	cond := map[string]any{"expireAt": map[string]any{"$gte": time.Now().Unix()}}
+	result, err := s.sessions.Find(page, size, cond, cache.PageSorterDesc("lastUsedAt"))
+	if err != nil {
+		return nil, errors.Sys("Page OM sessions failed", err)
+	}
+	current := CurrentSession(ctx)
+	for _, item := range result.Items {
+		if item != nil {
+			item.TokenHash = ""
+			item.Current = item.ID == current
+		}
+	}
+	return result, nil
+}
+
+// Revoke ends one session by ID, or every session of an account.
+// A revoked token is refused by Sign even while its signature is still valid.
+func (s *Serv) Revoke(ctx context.Context, req RevokeSessionReq) *errors.Error {
+	cond := map[string]any{}
+	switch {
+	case req.ID != "":
+		cond["id"] = req.ID
+	case req.Username != "":
+		cond["username"] = req.Username
+	default:
+		return errors.Verify("id or username is required")
+	}
+	if err := s.sessions.Delete(cond); err != nil {
+		return errors.Sys("Revoke OM session failed", err)
+	}
+	logger.Info(ctx, fmt.Sprintf("OM session revoked, id: %s, username: %s", req.ID, req.Username))
+	return nil
+}
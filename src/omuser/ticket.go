@@ -0,0 +1,76 @@
+package omuser
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig/cache"
+	"github.com/jom-io/gorig/utils/errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ticketTTL is how long a ticket may wait before it is used; a browser opens the URL right away.
+const ticketTTL = 10 * time.Second
+
+type ticket struct {
+	Username string
+	Session  string
+	Path     string
+	IP       string
+	ExpireAt int64
+}
+
+var (
+	ticketMu sync.Mutex
+	tickets  = cache.New[ticket](cache.Memory, ticketTTL, time.Minute)
+)
+
+// NewTicket mints a single-use ticket that authenticates one request to path, for
+// EventSource and download links which cannot send an Authorization header.
+func NewTicket(ctx *gin.Context, path string) (*TicketOut, *errors.Error) {
+	path = strings.TrimSpace(path)
+	if path == "" || !strings.HasPrefix(path, "/") {
+		return nil, errors.Verify("Invalid path")
+	}
+	username, _ := Current(ctx)
+	sid := CurrentSession(ctx)
+	if username == "" || sid == "" {
+		return nil, errors.Verify("Not logged in")
+	}
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, errors.Sys("Generate ticket failed", err)
+	}
+	value := hex.EncodeToString(buf)
+	expireAt := time.Now().Add(ticketTTL)
+	t := ticket{Username: username, Session: sid, Path: path, IP: ClientIP(ctx), ExpireAt: expireAt.Unix()}
+	if err := tickets.Set(value, t, ticketTTL); err != nil {
+		return nil, errors.Sys("Save ticket failed", err)
+	}
+	return &TicketOut{Ticket: value, ExpireAt: expireAt.Unix()}, nil
+}
+
+// RedeemTicket consumes the ticket and returns the account it was minted for. It fails
+// when the ticket is used on another path, from another IP, or after its session ended.
+func RedeemTicket(ctx *gin.Context, value string) (string, bool) {
+	ticketMu.Lock()
+	t, err := tickets.Get(value)
+	if err == nil {
+		_ = tickets.Del(value)
+	}
+	ticketMu.Unlock()
+	if err != nil || t.ExpireAt < time.Now().Unix() {
+		return "", false
+	}
+	if t.Path != ctx.Request.URL.Path || t.IP != ClientIP(ctx) {
+		return "", false
+	}
+	session, e := S().sessions.Get(map[string]any{"id": t.Session})
+	if e != nil || session == nil || session.ExpireAt < time.Now().Unix() {
+		return "", false
+	}
+	ctx.Set(ctxSessionKey, t.Session)
+	return t.Username, true
+}
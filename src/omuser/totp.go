@@ -0,0 +1,290 @@
+package omuser
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"github.com/jom-io/gorig/global/variable"
+	configure "github.com/jom-io/gorig/utils/cofigure"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TOTP parameters of RFC 6238 as used by common authenticator apps.
+const (
+	totpStep      = 30
+	totpDigits    = 6
+	totpSkew      = 1 // accept one step before and after to tolerate clock drift
+	totpSecretLen = 20
+	recoveryCount = 10
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TotpCode computes the code of the secret for the given time step.
+func TotpCode(secret string, step int64) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// matchTotp returns the time step the code belongs to, refusing steps at or before lastStep.
+func matchTotp(secret, code string, lastStep int64, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpStep
+	for i := -totpSkew; i <= totpSkew; i++ {
+		step := current + int64(i)
+		if step <= lastStep {
+			continue
+		}
+		expect, err := TotpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expect), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// randomString draws n characters of alphabet. Bytes at or above the largest multiple of
+// len(alphabet) are dropped, so every character is equally likely.
+func randomString(n int, alphabet string) (string, error) {
+	limit := 256 - 256%len(alphabet)
+	out := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(out) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(out) < n {
+				out = append(out, alphabet[int(b)%len(alphabet)])
+			}
+		}
+	}
+	return string(out), nil
+}
+
+// totpLocks serializes the code checks of each account, so two concurrent logins
+// cannot both spend the same code or recovery code.
+var totpLocks = struct {
+	sync.Mutex
+	m map[string]*totpLock
+}{m: make(map[string]*totpLock)}
+
+type totpLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockTotp locks the account and returns the unlock function.
+func lockTotp(username string) func() {
+	totpLocks.Lock()
+	l := totpLocks.m[username]
+	if l == nil {
+		l = &totpLock{}
+		totpLocks.m[username] = l
+	}
+	l.refs++
+	totpLocks.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		totpLocks.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(totpLocks.m, username)
+		}
+		totpLocks.Unlock()
+	}
+}
+
+func (s *Serv) getTotp(ctx context.Context, username string) (*OMTotp, *errors.Error) {
+	t, err := s.totp.Get(map[string]any{"username": username})
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("Get OM TOTP failed: %v", err))
+		return nil, errors.Sys("Get OM TOTP failed", err)
+	}
+	return t, nil
+}
+
+func (s *Serv) saveTotp(t *OMTotp, exists bool) *errors.Error {
+	var err error
+	if exists {
+		err = s.totp.Update(map[string]any{"username": t.Username}, t)
+	} else {
+		err = s.totp.Put(*t)
+	}
+	if err != nil {
+		return errors.Sys("Save OM TOTP failed", err)
+	}
+	return nil
+}
+
+// TotpStatus reports whether the account has a confirmed second factor.
+func (s *Serv) TotpStatus(ctx context.Context, username string) (*TotpStatus, *errors.Error) {
+	t, e := s.getTotp(ctx, username)
+	if e != nil {
+		return nil, e
+	}
+	if t == nil || !t.Enabled {
+		return &TotpStatus{}, nil
+	}
+	return &TotpStatus{Enabled: true, RecoveryLeft: len(t.Recovery)}, nil
+}
+
+// TotpEnroll creates a new secret for the account. It takes effect after TotpConfirm.
+func (s *Serv) TotpEnroll(ctx context.Context, username string) (*TotpEnrollOut, *errors.Error) {
+	old, e := s.getTotp(ctx, username)
+	if e != nil {
+		return nil, e
+	}
+	if old != nil && old.Enabled {
+		return nil, errors.Verify("TOTP is already enabled, disable it first")
+	}
+	key := make([]byte, totpSecretLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.Sys("Generate TOTP secret failed", err)
+	}
+	t := &OMTotp{
+		Username: username,
+		Secret:   b32.EncodeToString(key),
+		CreateAt: time.Now().Unix(),
+	}
+	if e = s.saveTotp(t, old != nil); e != nil {
+		return nil, e
+	}
+
+	issuer := configure.GetString("om.totp.issuer", "")
+	if issuer == "" {
+		issuer = "gorig-om"
+		if variable.SysName != "" {
+			issuer = fmt.Sprintf("%s-om", variable.SysName)
+		}
+	}
+	query := url.Values{}
+	query.Set("secret", t.Secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprintf("%d", totpDigits))
+	query.Set("period", fmt.Sprintf("%d", totpStep))
+	uri := fmt.Sprintf("otpauth://totp/%s:%s?%s", url.PathEscape(issuer), url.PathEscape(username), query.Encode())
+	return &TotpEnrollOut{Secret: t.Secret, URI: uri}, nil
+}
+
+// TotpConfirm enables the pending secret and returns the recovery codes, which are shown only once.
+func (s *Serv) TotpConfirm(ctx context.Context, username, code string) ([]string, *errors.Error) {
+	defer lockTotp(username)()
+	t, e := s.getTotp(ctx, username)
+	if e != nil {
+		return nil, e
+	}
+	if t == nil {
+		return nil, errors.Verify("TOTP is not enrolled")
+	}
+	if t.Enabled {
+		return nil, errors.Verify("TOTP is already enabled")
+	}
+	step, ok := matchTotp(t.Secret, code, t.LastStep, time.Now())
+	if !ok {
+		return nil, errors.Verify("Invalid TOTP code")
+	}
+	codes := make([]string, 0, recoveryCount)
+	t.Recovery = make([]string, 0, recoveryCount)
+	for i := 0; i < recoveryCount; i++ {
+		c, err := randomString(10, "abcdefghjkmnpqrstuvwxyz23456789")
+		if err != nil {
+			return nil, errors.Sys("Generate recovery code failed", err)
+		}
+		c = c[:5] + "-" + c[5:]
+		codes = append(codes, c)
+		t.Recovery = append(t.Recovery, hashToken(c))
+	}
+	t.Enabled = true
+	t.LastStep = step
+	t.ConfirmAt = time.Now().Unix()
+	if e = s.saveTotp(t, true); e != nil {
+		return nil, e
+	}
+	logger.Info(ctx, fmt.Sprintf("OM TOTP enabled: %s", username))
+	return codes, nil
+}
+
+// TotpDisable removes the second factor after checking a code or recovery code.
+func (s *Serv) TotpDisable(ctx context.Context, username, code string) *errors.Error {
+	ok, e := s.CheckTotp(ctx, username, code)
+	if e != nil {
+		return e
+	}
+	if !ok {
+		return errors.Verify("Invalid TOTP code")
+	}
+	return s.TotpReset(ctx, username)
+}
+
+// TotpReset removes the second factor without a code, for admins helping a user who lost the device.
+func (s *Serv) TotpReset(ctx context.Context, username string) *errors.Error {
+	if err := s.totp.Delete(map[string]any{"username": username}); err != nil {
+		return errors.Sys("Delete OM TOTP failed", err)
+	}
+	logger.Info(ctx, fmt.Sprintf("OM TOTP removed: %s", username))
+	return nil
+}
+
+// TotpRequired reports whether logging in as the account needs a code.
+func (s *Serv) TotpRequired(ctx context.Context, username string) (bool, *errors.Error) {
+	t, e := s.getTotp(ctx, username)
+	if e != nil {
+		return false, e
+	}
+	return t != nil && t.Enabled, nil
+}
+
+// CheckTotp verifies a one-time code or consumes a recovery code of an enrolled account.
+// The read and the save of the used step or code run under the account's lock.
+func (s *Serv) CheckTotp(ctx context.Context, username, code string) (bool, *errors.Error) {
+	defer lockTotp(username)()
+	t, e := s.getTotp(ctx, username)
+	if e != nil {
+		return false, e
+	}
+	if t == nil || !t.Enabled {
+		return false, errors.Verify("TOTP is not enabled")
+	}
+	if step, ok := matchTotp(t.Secret, code, t.LastStep, time.Now()); ok {
+		t.LastStep = step
+		return true, s.saveTotp(t, true)
+	}
+	hash := hashToken(strings.ToLower(strings.TrimSpace(code)))
+	for i, h := range t.Recovery {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			t.Recovery = append(t.Recovery[:i], t.Recovery[i+1:]...)
+			logger.Info(ctx, fmt.Sprintf("OM TOTP recovery code used: %s, left: %d", username, len(t.Recovery)))
+			return true, s.saveTotp(t, true)
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,232 @@
+package omuser
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig/apix"
+	"github.com/jom-io/gorig/cache"
+	"github.com/jom-io/gorig/utils/errors"
+	"github.com/jom-io/gorig/utils/logger"
+	"golang.org/x/crypto/bcrypt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ctxUserKey = "om_user"
+	ctxRoleKey = "om_role"
+	ctxIPKey   = "om_client_ip"
+
+	minPwdLen = 8
+
+	// dummyPwdHash is a bcrypt hash at the default cost that Verify checks for unknown accounts.
+	dummyPwdHash = "$2a$10$vy3dhVoyIbxn7W05vbvHPOe4BIR9tc3A0WAU/Lpz0/AUBlymsz6h."
+)
+
+var usernameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]{2,32}$`)
+
+var userServ *Serv
+
+type Serv struct {
+	storage   cache.Pager[OMUser]
+	sessions  cache.Pager[OMSession]
+	totp      cache.Pager[OMTotp]
+	apiTokens cache.Pager[OMApiToken]
+}
+
+func S() *Serv {
+	if userServ == nil {
+		userServ = &Serv{
+			storage:   cache.NewPager[OMUser](context.Background(), cache.Sqlite, "om_user"),
+			sessions:  cache.NewPager[OMSession](context.Background(), cache.Sqlite, "om_session"),
+			totp:      cache.NewPager[OMTotp](context.Background(), cache.Sqlite, "om_totp"),
+			apiTokens: cache.NewPager[OMApiToken](context.Background(), cache.Sqlite, "om_api_token"),
+		}
+	}
+	return userServ
+}
+
+// Get returns the stored account, or nil when it does not exist.
+func (s *Serv) Get(ctx context.Context, username string) (*OMUser, *errors.Error) {
+	user, err := s.storage.Get(map[string]any{"username": username})
+	if err != nil {
+		logger.Error(ctx, fmt.Sprintf("Get OM user failed: %v", err))
+		return nil, errors.Sys("Get OM user failed", err)
+	}
+	return user, nil
+}
+
+func (s *Serv) Page(ctx context.Context, page, size int64) (*cache.PageCache[OMUser], *errors.Error) {
+	if page <= 0 {
+		page = 1
+	}
+	if size <= 0 {
+		size = 10
+	}
+	result, err := s.storage.Find(page, size, nil, cache.PageSorterAsc("username"))
+	if err != nil {
+		return nil, errors.Sys("Page OM users failed", err)
+	}
+	return result, nil
+}
+
+// Save creates the account or updates its role, status and (when given) password.
+func (s *Serv) Save(ctx context.Context, req SaveUserReq) *errors.Error {
+	req.Username = strings.TrimSpace(req.Username)
+	if strings.EqualFold(req.Username, RootUser) {
+		return errors.Verify(fmt.Sprintf("%s is reserved for the om.key login", RootUser))
+	}
+	if !req.Role.Valid() {
+		return errors.Verify(fmt.Sprintf("Invalid role: %s", req.Role))
+	}
+
+	old, e := s.Get(ctx, req.Username)
+	if e != nil {
+		return e
+	}
+	if old != nil && old.Source == SourceOIDC {
+		// Single sign-on accounts keep the IdP's username and never get a local password.
+		if req.Password != "" {
+			return errors.Verify("Single sign-on accounts have no local password")
+		}
+	} else if !usernameRegexp.MatchString(req.Username) {
+		return errors.Verify("Invalid username, use 2-32 letters, digits, '_', '-' or '.'")
+	}
+	now := time.Now().Unix()
+	user := OMUser{
+		Username: req.Username,
+		Role:     req.Role,
+		Disabled: req.Disabled,
+		CreateAt: now,
+		UpdateAt: now,
+	}
+	if old != nil {
+		user = *old
+		user.Role = req.Role
+		user.Disabled = req.Disabled
+		user.UpdateAt = now
+	}
+
+	if req.Password != "" || old == nil {
+		if len(req.Password) < minPwdLen {
+			return errors.Verify(fmt.Sprintf("Password must be at least %d characters", minPwdLen))
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return errors.Sys("Hash password failed", err)
+		}
+		user.PwdHash = string(hash)
+	}
+
+	if old == nil {
+		if err := s.storage.Put(user); err != nil {
+			return errors.Sys("Save OM user failed", err)
+		}
+	} else if err := s.storage.Update(map[string]any{"username": user.Username}, &user); err != nil {
+		return errors.Sys("Save OM user failed", err)
+	}
+	if old != nil && (user.Disabled || user.PwdHash != old.PwdHash) {
+		if e = s.Revoke(ctx, RevokeSessionReq{Username: user.Username}); e != nil {
+			return e
+		}
+	}
+	logger.Info(ctx, fmt.Sprintf("OM user saved: %s, role: %s, disabled: %t", user.Username, user.Role, user.Disabled))
+	return nil
+}
+
+func (s *Serv) Delete(ctx context.Context, username string) *errors.Error {
+	if username == "" || username == RootUser {
+		return errors.Verify("Invalid username")
+	}
+	if err := s.storage.Delete(map[string]any{"username": username}); err != nil {
+		return errors.Sys("Delete OM user failed", err)
+	}
+	if e := s.Revoke(ctx, RevokeSessionReq{Username: username}); e != nil {
+		return e
+	}
+	if e := s.TotpReset(ctx, username); e != nil {
+		return e
+	}
+	if err := s.apiTokens.Delete(map[string]any{"username": username}); err != nil {
+		return errors.Sys("Delete API tokens failed", err)
+	}
+	logger.Info(ctx, fmt.Sprintf("OM user deleted: %s", username))
+	return nil
+}
+
+// Verify checks the plain password of a named account.
+func (s *Serv) Verify(ctx context.Context, username, pwd string) (*OMUser, bool) {
+	user, e := s.Get(ctx, username)
+	if e != nil || user == nil || user.Disabled || user.PwdHash == "" {
+		// spend the same bcrypt time as a real account, so the reply time does not tell which names exist
+		_ = bcrypt.CompareHashAndPassword([]byte(dummyPwdHash), []byte(pwd))
+		return nil, false
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PwdHash), []byte(pwd)); err != nil {
+		return nil, false
+	}
+	return user, true
+}
+
+// RoleOf resolves the current role of an account; disabled or deleted accounts have none.
+func RoleOf(ctx context.Context, username string) (Role, bool) {
+	if username == RootUser {
+		return RoleAdmin, true
+	}
+	user, e := S().Get(ctx, username)
+	if e != nil || user == nil || user.Disabled {
+		return "", false
+	}
+	return user.Role, true
+}
+
+// SetCurrent stores the authenticated account on the request.
+func SetCurrent(ctx *gin.Context, username string, role Role) {
+	ctx.Set(ctxUserKey, username)
+	ctx.Set(ctxRoleKey, role)
+	apix.SetUserID(ctx, username)
+}
+
+// Current returns the authenticated account of the request.
+func Current(ctx *gin.Context) (string, Role) {
+	if ctx == nil {
+		return "", ""
+	}
+	role, _ := ctx.Get(ctxRoleKey)
+	r, _ := role.(Role)
+	return ctx.GetString(ctxUserKey), r
+}
+
+// SetClientIP stores the caller's address as resolved by the allowlist middleware,
+// which only follows X-Forwarded-For through trusted proxies.
+func SetClientIP(ctx *gin.Context, ip string) {
+	ctx.Set(ctxIPKey, ip)
+}
+
+// ClientIP returns the caller's address set by SetClientIP, or the peer address when
+// the request did not go through the middleware. Unlike gin's ClientIP, it never takes
+// X-Forwarded-For from the client itself, so nonces, tickets and lockouts cannot be
+// moved to another address by sending the header.
+func ClientIP(ctx *gin.Context) string {
+	if ip := ctx.GetString(ctxIPKey); ip != "" {
+		return ip
+	}
+	return ctx.RemoteIP()
+}
+
+// PermsOf lists the permissions granted to the role.
+func PermsOf(role Role) []Perm {
+	perms := make([]Perm, 0, len(permRoles))
+	for p := range permRoles {
+		if role.Can(p) {
+			perms = append(perms, p)
+		}
+	}
+	sort.Slice(perms, func(i, j int) bool {
+		return perms[i] < perms[j]
+	})
+	return perms
+}
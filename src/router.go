@@ -2,10 +2,13 @@ package om
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig-om/src/allow"
+	"github.com/jom-io/gorig-om/src/audit"
 	"github.com/jom-io/gorig-om/src/deploy/app"
 	dpGit "github.com/jom-io/gorig-om/src/deploy/env"
 	dpTask "github.com/jom-io/gorig-om/src/deploy/task"
 	"github.com/jom-io/gorig-om/src/host"
+	"github.com/jom-io/gorig-om/src/logrule"
 	"github.com/jom-io/gorig-om/src/logtool"
 	"github.com/jom-io/gorig-om/src/mid"
 	"github.com/jom-io/gorig-om/src/omuser"
@@ -28,39 +31,97 @@ func Setup() {
 	}
 	httpx.RegisterRouter(func(groupRouter *gin.RouterGroup) {
 		om := groupRouter.Group("om")
+		om.GET("app/restarted", mid.AllowLocal(), app.ReStared)
+		om.Use(mid.Allow())
+
 		runApp := om.Group("app")
-		runApp.GET("restarted", app.ReStared)
 		runApp.Use(mid.Sign())
-		runApp.POST("restart", app.Restart)
-		runApp.POST("stop", app.Stop)
-		runApp.GET("restart/logs", app.RestartLogs)
+		runApp.POST("restart", mid.Audit(), mid.Perm(omuser.PermAppRestart), app.Restart)
+		runApp.POST("stop", mid.Audit(), mid.Perm(omuser.PermAppRestart), app.Stop)
+		runApp.GET("restart/logs", mid.Perm(omuser.PermAppRead), app.RestartLogs)
 
 		auth := om.Group("auth")
+		auth.POST("challenge", omuser.Challenge)
 		auth.POST("connect", omuser.Login)
+		auth.POST("oidc/start", omuser.OIDCLogin)
+		auth.POST("oidc/connect", omuser.OIDCConnect)
+
+		stream := om.Group("log")
+		stream.Use(mid.SignStream())
+		stream.GET("monitor", mid.Perm(omuser.PermLogRead), logtool.Monitor)
+		stream.GET("download", mid.Perm(omuser.PermLogRead), logtool.Download)
+		stream.GET("export", mid.Perm(omuser.PermLogRead), logtool.Export)
 
 		om.Use(mid.Sign())
+		session := om.Group("auth")
+		session.GET("me", omuser.Me)
+		session.POST("ticket", mid.Interactive(), omuser.Ticket)
+		session.POST("logout", mid.Audit(), mid.Interactive(), omuser.Logout)
+		session.POST("refresh", mid.Interactive(), omuser.Refresh)
+		session.GET("sessions", mid.Interactive(), mid.Perm(omuser.PermUserManage), omuser.Sessions)
+		session.POST("sessions/revoke", mid.Audit(), mid.Interactive(), mid.Perm(omuser.PermUserManage), omuser.RevokeSession)
+		session.GET("totp", mid.Interactive(), omuser.TotpStatusGet)
+		session.POST("totp/enroll", mid.Audit(), mid.Interactive(), omuser.TotpEnroll)
+		session.POST("totp/confirm", mid.Audit(), mid.Interactive(), omuser.TotpConfirm)
+		session.POST("totp/disable", mid.Audit(), mid.Interactive(), omuser.TotpDisable)
+		session.GET("tokens", mid.Interactive(), omuser.ApiTokenPage)
+		session.POST("tokens/create", mid.Audit(), mid.Interactive(), omuser.ApiTokenCreate)
+		session.POST("tokens/revoke", mid.Audit(), mid.Interactive(), omuser.ApiTokenRevoke)
+
+		user := om.Group("user")
+		user.GET("page", mid.Interactive(), mid.Perm(omuser.PermUserManage), omuser.Page)
+		user.POST("save", mid.Audit(), mid.Interactive(), mid.Perm(omuser.PermUserManage), omuser.Save)
+		user.POST("delete", mid.Audit(), mid.Interactive(), mid.Perm(omuser.PermUserManage), omuser.Delete)
+		user.POST("totp/reset", mid.Audit(), mid.Interactive(), mid.Perm(omuser.PermUserManage), omuser.TotpReset)
+
+		network := om.Group("allow")
+		network.GET("cidrs", mid.Interactive(), mid.Perm(omuser.PermUserManage), allow.Get)
+		network.POST("cidrs", mid.Audit(), mid.Interactive(), mid.Perm(omuser.PermUserManage), allow.Save)
+
+		auditLog := om.Group("audit")
+		auditLog.GET("page", mid.Perm(omuser.PermAuditRead), audit.Page)
+		auditLog.GET("retention", mid.Perm(omuser.PermAuditRead), audit.GetRetention)
+		auditLog.POST("retention", mid.Audit(), mid.Perm(omuser.PermAuditManage), audit.SetRetention)
+
 		log := om.Group("log")
-		log.GET("categories", logtool.GetCategories)
-		log.GET("levels", logtool.GetLevels)
-		log.POST("search", logtool.Search)
-		log.GET("near", logtool.Near)
-		log.GET("monitor", logtool.Monitor)
-		log.GET("download", logtool.Download)
+		log.GET("categories", mid.Perm(omuser.PermLogRead), logtool.GetCategories)
+		log.GET("levels", mid.Perm(omuser.PermLogRead), logtool.GetLevels)
+		log.GET("sources", mid.Perm(omuser.PermLogRead), logtool.GetSources)
+		log.POST("search", mid.Perm(omuser.PermLogRead), logtool.Search)
+		log.POST("search/page", mid.Perm(omuser.PermLogRead), logtool.SearchPaged)
+		log.GET("near", mid.Perm(omuser.PermLogRead), logtool.Near)
+		log.POST("facets", mid.Perm(omuser.PermLogRead), logtool.Facets)
+		log.GET("trace", mid.Perm(omuser.PermLogRead), logtool.Trace)
+		log.POST("patterns", mid.Perm(omuser.PermLogRead), logtool.Patterns)
+		log.GET("usage", mid.Perm(omuser.PermLogRead), logtool.GetUsage)
+		log.GET("retention", mid.Perm(omuser.PermLogManage), logtool.RetentionGet)
+		log.POST("retention", mid.Audit(), mid.Perm(omuser.PermLogManage), logtool.RetentionSave)
+		log.POST("retention/preview", mid.Perm(omuser.PermLogManage), logtool.RetentionPreview)
+		log.GET("redaction", mid.Perm(omuser.PermLogManage), logtool.RedactionGet)
+		log.POST("redaction", mid.Audit(), mid.Perm(omuser.PermLogManage), logtool.RedactionSave)
+		log.GET("saved/page", mid.Perm(omuser.PermLogRead), logrule.SearchPage)
+		log.POST("saved/save", mid.Audit(), mid.Perm(omuser.PermLogRead), logrule.SearchSave)
+		log.POST("saved/delete", mid.Audit(), mid.Perm(omuser.PermLogRead), logrule.SearchDelete)
+		log.GET("rule/page", mid.Perm(omuser.PermLogRead), logrule.RulePage)
+		log.POST("rule/save", mid.Audit(), mid.Perm(omuser.PermLogRule), logrule.RuleSave)
+		log.POST("rule/delete", mid.Audit(), mid.Perm(omuser.PermLogRule), logrule.RuleDelete)
+		log.POST("rule/test", mid.Perm(omuser.PermLogRule), logrule.RuleTest)
+		log.GET("alert/page", mid.Perm(omuser.PermLogRead), logrule.AlertPage)
 
 		//git.POST("auto", auto)
 
 		deploy := om.Group("deploy")
 
 		git := deploy.Group("git")
-		git.GET("check", dpGit.CheckGit)
-		git.POST("install", dpGit.Install)
-		deploy.GET("branches", dpGit.Branches)
+		git.GET("check", mid.Perm(omuser.PermDeployRead), dpGit.CheckGit)
+		git.POST("install", mid.Audit(), mid.Perm(omuser.PermDeployConfig), dpGit.Install)
+		deploy.GET("branches", mid.Perm(omuser.PermDeployRead), dpGit.Branches)
 
 		goEnv := deploy.Group("go")
-		goEnv.GET("check", dpGit.CheckGo)
-		goEnv.POST("install", dpGit.InstallGo)
-		goEnv.GET("env", dpGit.GoEnvGet)
-		goEnv.POST("env", dpGit.GoEnvSet)
+		goEnv.GET("check", mid.Perm(omuser.PermDeployRead), dpGit.CheckGo)
+		goEnv.POST("install", mid.Audit(), mid.Perm(omuser.PermDeployConfig), dpGit.InstallGo)
+		goEnv.GET("env", mid.Perm(omuser.PermDeployRead), dpGit.GoEnvGet)
+		goEnv.POST("env", mid.Audit(), mid.Perm(omuser.PermDeployConfig), dpGit.GoEnvSet)
 
 		//deploy.GET("repository", dpGit.GetRepo)
 		//deploy.POST("repository", dpGit.SetRepo)
@@ -68,34 +129,34 @@ func Setup() {
 		//deploy.POST("branch", dpGit.BranchSet)
 		//deploy.GET("branch", dpGit.BranchGet)
 
-		deploy.GET("ssh/key", dpGit.GetSSHKey)
-		deploy.POST("ssh/key", dpGit.GenSSHKey)
+		deploy.GET("ssh/key", mid.Perm(omuser.PermDeployRead), dpGit.GetSSHKey)
+		deploy.POST("ssh/key", mid.Audit(), mid.Perm(omuser.PermDeployConfig), dpGit.GenSSHKey)
 
 		task := deploy.Group("task")
-		task.GET("config", dpTask.GetConfig)
-		task.POST("config", dpTask.SaveConfig)
-		task.POST("start", dpTask.Start)
-		task.POST("stop", dpTask.Stop)
-		task.GET("page", dpTask.Page)
-		task.GET("get", dpTask.Get)
-		task.POST("rollback", dpTask.Rollback)
+		task.GET("config", mid.Perm(omuser.PermDeployRead), dpTask.GetConfig)
+		task.POST("config", mid.Audit(), mid.Perm(omuser.PermDeployConfig), dpTask.SaveConfig)
+		task.POST("start", mid.Audit(), mid.Perm(omuser.PermDeployStart), dpTask.Start)
+		task.POST("stop", mid.Audit(), mid.Perm(omuser.PermDeployStart), dpTask.Stop)
+		task.GET("page", mid.Perm(omuser.PermDeployRead), dpTask.Page)
+		task.GET("get", mid.Perm(omuser.PermDeployRead), dpTask.Get)
+		task.POST("rollback", mid.Audit(), mid.Perm(omuser.PermDeployStart), dpTask.Rollback)
 
 		h := om.Group("host")
-		h.GET("usage", host.Usage)
-		h.GET("usage/time", host.TimeRange)
+		h.GET("usage", mid.Perm(omuser.PermStatRead), host.Usage)
+		h.GET("usage/time", mid.Perm(omuser.PermStatRead), host.TimeRange)
 
 		e := om.Group("stat")
-		e.GET("error/time", errstat.TimeRange)
-		e.GET("error/top", errstat.Top)
-		e.GET("api/time", apistat.TimeRange)
-		e.GET("api/summary", apistat.Summary)
-		e.GET("api/top", apistat.Top)
-		e.GET("api/sample", apistat.Sample)
-		e.GET("goroutine/time", gorstat.TimeRange)
-		e.GET("mem/big/top", memstat.BigTop)
-		e.GET("mem/big/count", memstat.BigCount)
-		e.GET("mem/leak/latest", memstat.LeakLatest)
-		e.GET("mem/leak/count", memstat.LeakCount)
-		e.GET("mem/leak/page", memstat.LeakPage)
+		e.GET("error/time", mid.Perm(omuser.PermStatRead), errstat.TimeRange)
+		e.GET("error/top", mid.Perm(omuser.PermStatRead), errstat.Top)
+		e.GET("api/time", mid.Perm(omuser.PermStatRead), apistat.TimeRange)
+		e.GET("api/summary", mid.Perm(omuser.PermStatRead), apistat.Summary)
+		e.GET("api/top", mid.Perm(omuser.PermStatRead), apistat.Top)
+		e.GET("api/sample", mid.Perm(omuser.PermStatRead), apistat.Sample)
+		e.GET("goroutine/time", mid.Perm(omuser.PermStatRead), gorstat.TimeRange)
+		e.GET("mem/big/top", mid.Perm(omuser.PermStatRead), memstat.BigTop)
+		e.GET("mem/big/count", mid.Perm(omuser.PermStatRead), memstat.BigCount)
+		e.GET("mem/leak/latest", mid.Perm(omuser.PermStatRead), memstat.LeakLatest)
+		e.GET("mem/leak/count", mid.Perm(omuser.PermStatRead), memstat.LeakCount)
+		e.GET("mem/leak/page", mid.Perm(omuser.PermStatRead), memstat.LeakPage)
 	})
 }
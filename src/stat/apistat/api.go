@@ -2,6 +2,7 @@ package apistat
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig-om/src/logtool"
 	"github.com/jom-io/gorig/apix"
 	"github.com/jom-io/gorig/cache"
 	"github.com/jom-io/gorig/global/consts"
@@ -59,10 +60,29 @@ func Sample(ctx *gin.Context) {
 	method, err := apix.GetParamForce(ctx, "method")
 	uri, err := apix.GetParamForce(ctx, "uri")
 	types, err := apix.GetParamArray[string](ctx, "types", apix.NotForce)
+	unmasked, err := apix.GetParamType[bool](ctx, "unmasked", apix.NotForce)
 	if err != nil {
 		return
 	}
 
 	data, e := S().Sample(ctx, method, uri, types)
+	if data != nil {
+		redact := logtool.ViewRedactor(ctx, unmasked)
+		for _, sample := range []*ApiLatencySample{data.Latest, data.Sample2xx, data.Sample4xx, data.Sample5xx, data.SampleSlow} {
+			redactSample(redact, sample)
+		}
+	}
 	apix.HandleData(ctx, consts.CurdSelectFailCode, data, e)
 }
+
+// redactSample masks the logged request and response of a sample the way the log views do.
+func redactSample(redact *logtool.Redactor, sample *ApiLatencySample) {
+	if sample == nil || redact == nil {
+		return
+	}
+	sample.URL = redact.Line(sample.URL)
+	for _, l := range []*ApiLogSample{&sample.InLog, &sample.OutLog} {
+		rec := redact.Record(&logtool.LogRecord{Msg: l.Msg, Error: l.Error, Data: l.Data})
+		l.Msg, l.Error, l.Data = rec.Msg, rec.Error, rec.Data
+	}
+}
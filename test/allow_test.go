@@ -0,0 +1,49 @@
+package test
+
+import (
+	"context"
+	"github.com/jom-io/gorig-om/src/allow"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowParseCIDRs(t *testing.T) {
+	nets, err := allow.ParseCIDRs([]string{"10.8.0.0/16", "127.0.0.1", "::1", "bad"})
+	if err == nil {
+		t.Errorf("ParseCIDRs() should report the invalid entry")
+	}
+	if len(nets) != 3 {
+		t.Fatalf("ParseCIDRs() returned %d nets, want 3", len(nets))
+	}
+	if !nets[0].Contains(net.ParseIP("10.8.3.4")) || nets[1].Contains(net.ParseIP("127.0.0.2")) {
+		t.Errorf("ParseCIDRs() = %v", nets)
+	}
+}
+
+func TestAllowSave(t *testing.T) {
+	ctx := context.Background()
+	req := httptest.NewRequest(http.MethodPost, "/om/allow/cidrs", nil)
+	req.RemoteAddr = "10.8.1.2:5555"
+	req.Header.Set("X-Forwarded-For", "192.168.1.1")
+	defer func() {
+		_ = allow.S().Save(ctx, req, "tester", nil)
+	}()
+
+	if ip := allow.S().ClientIP(req); ip.String() != "10.8.1.2" {
+		t.Errorf("ClientIP() = %s, X-Forwarded-For must be ignored from untrusted peers", ip)
+	}
+	if err := allow.S().Save(ctx, req, "tester", []string{"192.168.1.0/24"}); err == nil {
+		t.Errorf("Save() accepted a list that locks out the caller")
+	}
+	if err := allow.S().Save(ctx, req, "tester", []string{"10.8.0.0/16, 127.0.0.1"}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if !allow.S().Allowed(net.ParseIP("10.8.200.1")) || allow.S().Allowed(net.ParseIP("192.168.1.1")) {
+		t.Errorf("Allowed() does not follow the saved list")
+	}
+	if out := allow.S().Get(ctx, req); out.Source != allow.SourceRuntime || len(out.CIDRs) != 2 {
+		t.Errorf("Get() = %+v", out)
+	}
+}
@@ -0,0 +1,114 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig-om/src/logrule"
+	"github.com/jom-io/gorig-om/src/logtool"
+	"github.com/jom-io/gorig-om/src/omuser"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLogRuleEvaluate(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".logs", "invoke", "invoke.jsonl")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(levels ...string) {
+		data := ""
+		for i, level := range levels {
+			ts := time.Now().Add(-time.Duration(len(levels)-i) * time.Second).Format("2006-01-02 15:04:05.000")
+			data += fmt.Sprintf(`{"level":"%s","time":"%s","msg":"payment callback failed %d"}`+"\n", level, ts, i)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("error", "info", "error", "error")
+	logrule.S().RootDir = dir
+	t.Cleanup(func() { logrule.S().RootDir = "" })
+
+	owner := "logrule_test_owner"
+	search, err := logrule.S().SaveSearch(ctx, owner, omuser.RoleViewer, logrule.SaveSearchReq{
+		Name:    "payment callback",
+		Options: logtool.SearchOptions{RootDir: "/", Categories: []string{"invoke"}, Query: `level:error AND msg:"callback failed"`, Size: 99},
+	})
+	if err != nil {
+		t.Fatalf("SaveSearch() error = %v", err)
+	}
+	t.Cleanup(func() { _ = logrule.S().DeleteSearch(ctx, owner, omuser.RoleViewer, search.ID) })
+	if search.Options.Size != 0 || search.Options.RootDir != "" {
+		t.Errorf("SaveSearch() kept paging options or the root directory: %+v", search.Options)
+	}
+	if _, err := logrule.S().SaveSearch(ctx, owner, omuser.RoleViewer, logrule.SaveSearchReq{Name: "bad", Options: logtool.SearchOptions{Query: "level:(error"}}); err == nil {
+		t.Errorf("SaveSearch() should reject an invalid query")
+	}
+	if _, err := logrule.S().SaveSearch(ctx, "someone_else", omuser.RoleOperator, logrule.SaveSearchReq{ID: search.ID, Name: "x"}); err == nil {
+		t.Errorf("SaveSearch() should refuse to change another account's search")
+	}
+
+	rule, err := logrule.S().SaveRule(ctx, owner, logrule.SaveRuleReq{
+		Name: "callback errors", SearchID: search.ID, Kind: logrule.KindThreshold, Window: "5m", Threshold: 3, Samples: 2, Enabled: true,
+	})
+	if err != nil {
+		t.Fatalf("SaveRule() error = %v", err)
+	}
+	t.Cleanup(func() { _ = logrule.S().DeleteRule(ctx, owner, rule.ID) })
+	if rule.Cooldown != "5m0s" {
+		t.Errorf("SaveRule() cooldown = %s, want the window", rule.Cooldown)
+	}
+	if err := logrule.S().DeleteSearch(ctx, owner, omuser.RoleViewer, search.ID); err == nil {
+		t.Errorf("DeleteSearch() should refuse a search used by a rule")
+	}
+
+	result, err := logrule.S().Check(ctx, *rule, time.Now())
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if result.Count != 3 || !result.Firing || len(result.Samples) != 2 {
+		t.Fatalf("Check() = count %d, firing %v, samples %d", result.Count, result.Firing, len(result.Samples))
+	}
+
+	alerts := func() []*logrule.AlertEvent {
+		page, err := logrule.S().AlertPage(ctx, logrule.AlertPageReq{RuleID: rule.ID, Size: 10})
+		if err != nil {
+			t.Fatalf("AlertPage() error = %v", err)
+		}
+		return page.Items
+	}
+	logrule.S().Evaluate(ctx)
+	logrule.S().Evaluate(ctx)
+	events := alerts()
+	if len(events) != 1 || events[0].Status != logrule.StatusFiring || events[0].Count != 3 || len(events[0].Samples) != 2 {
+		t.Fatalf("Evaluate() events = %+v, want one firing event within the cooldown", events)
+	}
+
+	write("info", "error")
+	logrule.S().Evaluate(ctx)
+	events = alerts()
+	resolved := 0
+	for _, event := range events {
+		if event.Status == logrule.StatusResolved && event.Count == 1 && len(event.Samples) == 0 {
+			resolved++
+		}
+	}
+	if len(events) != 2 || resolved != 1 {
+		t.Fatalf("Evaluate() events = %d, resolved = %d, want a resolved event", len(events), resolved)
+	}
+
+	absent := *rule
+	absent.Kind = logrule.KindAbsence
+	absent.Threshold = 2
+	result, err = logrule.S().Check(ctx, absent, time.Now())
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if result.Count != 1 || !result.Firing || len(result.Samples) != 0 {
+		t.Errorf("Check() absence = %+v", result)
+	}
+}
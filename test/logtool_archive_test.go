@@ -0,0 +1,78 @@
+package test
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/jom-io/gorig-om/src/logtool"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeArchive(t *testing.T, path string, lines []string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	_, _ = zw.Write([]byte(strings.Join(lines, "\n") + "\n"))
+	_ = zw.Close()
+	_ = f.Close()
+}
+
+func TestSearchLogsArchive(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	line := func(sec int, msg string) string {
+		return fmt.Sprintf(`{"level":"info","time":"%s","msg":"%s"}`, base.Add(time.Duration(sec)*time.Second).Format("2006-01-02 15:04:05.000"), msg)
+	}
+
+	rotated := base.Add(5 * time.Second).UTC().Format("2006-01-02T15-04-05.000")
+	writeArchive(t, filepath.Join(dir, ".logs", "rest", "rest-"+rotated+".jsonl.gz"), []string{line(0, "old-0"), line(2, "old-1"), line(4, "old-2")})
+	if err := os.WriteFile(filepath.Join(dir, ".logs", "rest", "rest.jsonl"), []byte(line(6, "new-0")+"\n"+line(8, "new-1")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := logtool.SearchLogsPage(logtool.SearchOptions{RootDir: dir, Size: 10, Order: logtool.OrderAsc})
+	if err != nil {
+		t.Fatalf("SearchLogsPage() error = %v", err)
+	}
+	msgs := make([]string, 0)
+	for _, r := range page.Records {
+		msgs = append(msgs, r.Record.Msg)
+	}
+	if got := strings.Join(msgs, ","); got != "old-0,old-1,old-2,new-0,new-1" {
+		t.Fatalf("SearchLogsPage() = %s", got)
+	}
+
+	// The archive ends before the range, it must be skipped without being read.
+	page, err = logtool.SearchLogsPage(logtool.SearchOptions{
+		RootDir:   dir,
+		Size:      10,
+		StartTime: base.Add(7 * time.Second).Format("2006-01-02 15:04:05"),
+	})
+	if err != nil {
+		t.Fatalf("SearchLogsPage(startTime) error = %v", err)
+	}
+	if len(page.Records) != 1 || page.Records[0].Record.Msg != "new-1" || page.Progress.Files != 1 {
+		t.Fatalf("SearchLogsPage(startTime) = %+v, progress %+v", page.Records, page.Progress)
+	}
+
+	archived := ""
+	page, _ = logtool.SearchLogsPage(logtool.SearchOptions{RootDir: dir, Size: 10, Keyword: "old-1"})
+	if len(page.Records) == 1 {
+		archived = page.Records[0].FilePath
+	}
+	lines, err := logtool.FetchContextLines(archived, 2, 1)
+	if err != nil {
+		t.Fatalf("FetchContextLines() error = %v", err)
+	}
+	if len(lines) != 3 || lines[1].Record.Msg != "old-1" {
+		t.Fatalf("FetchContextLines() = %+v", lines)
+	}
+}
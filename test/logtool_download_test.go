@@ -0,0 +1,144 @@
+package test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig-om/src/logtool"
+	"github.com/jom-io/gorig-om/src/omuser"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadLogs(t *testing.T) {
+	dir := t.TempDir()
+	restDir := filepath.Join(dir, ".logs", "rest")
+	if err := os.MkdirAll(restDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	lines := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		lines = append(lines, fmt.Sprintf(`{"level":"info","time":"2025-03-01 10:0%d:00.000","msg":"m%d"}`+"\n", i, i))
+	}
+	lines[0] = `{"level":"info","msg":"m0","time":"2025-03-01 10:00:00.000","token":"tk-secret"}` + "\n"
+	lines[1] += "    at job.run(job.go:12)\n"
+	content := strings.Join(lines, "")
+	masked := strings.Replace(content, "tk-secret", "******", 1)
+	live := filepath.Join(restDir, "rest.jsonl")
+	if err := os.WriteFile(live, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(restDir, "rest-2025-03-01T10-04-00.000.jsonl.gz")
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	_, _ = zw.Write([]byte(content))
+	_ = zw.Close()
+	if err := os.WriteFile(archive, zipped.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "secret.jsonl")
+	if err := os.WriteFile(outside, []byte(`{"msg":"secret"}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(restDir, "rest-link.jsonl")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/download", func(c *gin.Context) {
+		if c.Query("role") != "" {
+			omuser.SetCurrent(c, "tester", omuser.Role(c.Query("role")))
+		}
+		opts := logtool.DownloadOptions{
+			Path:      c.Query("path"),
+			StartTime: c.Query("startTime"),
+			EndTime:   c.Query("endTime"),
+			Gzip:      c.Query("gzip") == "true",
+			Unmasked:  c.Query("unmasked") == "true",
+			RootDir:   dir,
+		}
+		if err := logtool.DownloadLogs(c, opts); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+		}
+	})
+	get := func(params url.Values, rangeHeader string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/download?"+params.Encode(), nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+	raw := func(params url.Values) url.Values {
+		params.Set("role", string(omuser.RoleAdmin))
+		params.Set("unmasked", "true")
+		return params
+	}
+
+	// the file as it is, with Range support, needs logs:unmasked
+	if w := get(raw(url.Values{"path": {live}}), ""); w.Code != http.StatusOK || w.Body.String() != content {
+		t.Fatalf("raw download = %d %q", w.Code, w.Body.String())
+	}
+	if w := get(raw(url.Values{"path": {live}}), "bytes=10-19"); w.Code != http.StatusPartialContent || w.Body.String() != content[10:20] {
+		t.Errorf("range download = %d %q", w.Code, w.Body.String())
+	}
+	for _, params := range []url.Values{
+		{"path": {live}},
+		{"path": {live}, "unmasked": {"true"}},
+		{"path": {live}, "role": {string(omuser.RoleViewer)}, "unmasked": {"true"}},
+		{"path": {live}, "role": {string(omuser.RoleAdmin)}},
+	} {
+		if w := get(params, "bytes=10-19"); w.Code != http.StatusOK || w.Body.String() != masked {
+			t.Errorf("download %v = %d %q", params, w.Code, w.Body.String())
+		}
+	}
+
+	for _, path := range []string{outside, link, restDir + "/../rest/rest.jsonl", filepath.Join(dir, "missing.jsonl")} {
+		if w := get(raw(url.Values{"path": {path}}), ""); w.Code != http.StatusBadRequest || strings.Contains(w.Body.String(), "secret") {
+			t.Errorf("download of %s = %d %q", path, w.Code, w.Body.String())
+		}
+	}
+
+	// a period holds its lines and the lines without a time that follow them
+	period := url.Values{"path": {live}, "startTime": {"2025-03-01 10:01:00"}, "endTime": {"2025-03-01 10:02:00"}}
+	if w := get(period, ""); w.Code != http.StatusOK || w.Body.String() != lines[1]+lines[2] {
+		t.Errorf("period download = %d %q", w.Code, w.Body.String())
+	}
+	if w := get(url.Values{"path": {live}, "endTime": {"2025-03-01 10:00:00"}}, ""); w.Body.String() != strings.Replace(lines[0], "tk-secret", "******", 1) {
+		t.Errorf("period download is not masked: %q", w.Body.String())
+	}
+	if w := get(url.Values{"path": {live}, "startTime": {"2025-03-02 00:00:00"}}, ""); w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("download of an empty period = %d %q", w.Code, w.Body.String())
+	}
+	if w := get(url.Values{"path": {archive}, "startTime": {"2025-03-01 10:02:00"}}, ""); w.Code != http.StatusOK || w.Body.String() != lines[2]+lines[3] {
+		t.Errorf("period download of an archive = %d %q", w.Code, w.Body.String())
+	}
+
+	for want, params := range map[string]url.Values{
+		content: raw(url.Values{"path": {live}, "gzip": {"true"}}),
+		masked:  {"path": {archive}, "gzip": {"true"}},
+	} {
+		w := get(params, "")
+		if w.Header().Get("Content-Type") != "application/gzip" || !strings.Contains(w.Header().Get("Content-Disposition"), "rest") {
+			t.Errorf("gzip download %v headers = %v", params, w.Header())
+		}
+		zr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("gzip download %v = %v", params, err)
+		}
+		if data, _ := io.ReadAll(zr); string(data) != want {
+			t.Errorf("gzip download %v content = %q", params, data)
+		}
+	}
+}
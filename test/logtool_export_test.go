@@ -0,0 +1,102 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig-om/src/logtool"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportLogs(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	for _, cat := range []string{"rest", "jobs"} {
+		path := filepath.Join(dir, ".logs", cat, cat+".jsonl")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		for i := 0; i < 30; i++ {
+			ts := base.Add(time.Duration(i) * time.Minute).Format("2006-01-02 15:04:05.000")
+			level := "info"
+			if i%3 == 0 {
+				level = "error"
+			}
+			_, _ = fmt.Fprintf(&b, `{"level":"%s","time":"%s","msg":"%s-%d","uri":"/u/%d"}`+"\n", level, ts, cat, i, i)
+		}
+		if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/export", func(c *gin.Context) {
+		c.Set(logtool.RootDirKey, dir)
+	}, logtool.Export)
+	srv := httptest.NewServer(engine)
+	defer srv.Close()
+	get := func(params url.Values) (*http.Response, []byte) {
+		resp, err := http.Get(srv.URL + "/export?" + params.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	// every match is exported, Size does not cap it, and the transfer is gzip-encoded
+	resp, body := get(url.Values{"levels": {"error"}, "size": {"5"}})
+	if !resp.Uncompressed {
+		t.Errorf("ndjson export was not gzip-encoded")
+	}
+	if lines := strings.Count(string(body), "\n"); lines != 20 {
+		t.Fatalf("ndjson export has %d lines, want 20", lines)
+	}
+
+	_, body = get(url.Values{"format": {"csv"}, "categories": {"rest"}, "query": {"level:error"}, "columns": {"uri"}})
+	rows, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatalf("csv export: %v", err)
+	}
+	if len(rows) != 11 || rows[0][len(rows[0])-1] != "uri" || rows[1][2] != "rest" || rows[1][len(rows[1])-1] != "/u/0" {
+		t.Fatalf("csv export = %v", rows[:2])
+	}
+
+	_, body = get(url.Values{
+		"format":    {"zip"},
+		"startTime": {base.Add(10 * time.Minute).Format("2006-01-02 15:04:05")},
+		"endTime":   {base.Add(14 * time.Minute).Format("2006-01-02 15:04:05")},
+	})
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("zip export: %v", err)
+	}
+	if len(zr.File) != 2 {
+		t.Fatalf("zip export has %d entries, want 2", len(zr.File))
+	}
+	for _, f := range zr.File {
+		r, _ := f.Open()
+		data, _ := io.ReadAll(r)
+		_ = r.Close()
+		if lines := strings.Count(string(data), "\n"); lines != 5 || !strings.Contains(string(data), "-10\"") {
+			t.Errorf("zip entry %s has %d lines:\n%s", f.Name, lines, data)
+		}
+	}
+
+	if resp, _ = get(url.Values{"format": {"zip"}}); resp.Header.Get("Content-Type") == "application/zip" {
+		t.Errorf("zip export without a time range was not rejected")
+	}
+}
@@ -0,0 +1,96 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig-om/src/logtool"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFacetLogs(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	write := func(name string, lines ...string) {
+		path := filepath.Join(dir, ".logs", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		data := ""
+		for _, l := range lines {
+			data += l + "\n"
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec := func(min int, level, uri string) string {
+		ts := base.Add(time.Duration(min) * time.Minute).Format("2006-01-02 15:04:05.000")
+		return fmt.Sprintf(`{"level":"%s","time":"%s","msg":"m","uri":"%s"}`, level, ts, uri)
+	}
+	write("rest/rest.jsonl", rec(0, "error", "/a"), rec(0, "info", "/a"), rec(3, "error", "/b"), rec(3, "error", "/a"))
+	write("commons/commons.jsonl", rec(1, "warn", "/c"), rec(1, "info", ""))
+
+	result, err := logtool.FacetLogs(context.Background(), logtool.FacetOptions{
+		SearchOptions: logtool.SearchOptions{RootDir: dir},
+		Interval:      "1m",
+		Top:           1,
+		Fields:        []string{"uri", "status"},
+	})
+	if err != nil {
+		t.Fatalf("FacetLogs() error = %v", err)
+	}
+	if result.Total != 6 || result.Progress.ScannedFiles != 2 {
+		t.Fatalf("FacetLogs() total = %d, progress = %+v", result.Total, result.Progress)
+	}
+
+	counts := make([]int64, 0)
+	for _, b := range result.Histogram {
+		counts = append(counts, b.Count)
+	}
+	if fmt.Sprint(counts) != "[2 2 0 2]" {
+		t.Fatalf("FacetLogs() histogram = %v, want [2 2 0 2]", counts)
+	}
+	if last := result.Histogram[3]; last.Levels["error"] != 2 || last.Categories["rest"] != 2 {
+		t.Errorf("FacetLogs() last bucket = %+v", last)
+	}
+
+	level := result.Facets[logtool.FacetFieldLevel]
+	if len(level.Values) != 1 || level.Values[0] != (logtool.FacetValue{Value: "error", Count: 3}) || level.Other != 3 {
+		t.Errorf("FacetLogs() level facet = %+v", level)
+	}
+	if uri := result.Facets["uri"]; uri.Values[0] != (logtool.FacetValue{Value: "/a", Count: 3}) || uri.Other != 3 {
+		t.Errorf("FacetLogs() uri facet = %+v", uri)
+	}
+	if status := result.Facets["status"]; len(status.Values) != 0 || status.Missing != 6 {
+		t.Errorf("FacetLogs() status facet = %+v", status)
+	}
+
+	// Filters and time pruning apply as in search.
+	result, err = logtool.FacetLogs(context.Background(), logtool.FacetOptions{
+		SearchOptions: logtool.SearchOptions{RootDir: dir, Query: "level:error", StartTime: base.Add(2 * time.Minute).Format("2006-01-02 15:04:05")},
+	})
+	if err != nil {
+		t.Fatalf("FacetLogs(query) error = %v", err)
+	}
+	if result.Total != 2 || result.Progress.Files != 1 {
+		t.Errorf("FacetLogs(query) total = %d, files = %d", result.Total, result.Progress.Files)
+	}
+
+	// A cancelled request stops the scan and reports partial counts.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err = logtool.FacetLogs(cancelled, logtool.FacetOptions{SearchOptions: logtool.SearchOptions{RootDir: dir}})
+	if err != nil {
+		t.Fatalf("FacetLogs(cancelled) error = %v", err)
+	}
+	if !result.Truncated || result.Total != 0 {
+		t.Errorf("FacetLogs(cancelled) truncated = %v, total = %d", result.Truncated, result.Total)
+	}
+
+	if _, err = logtool.FacetLogs(context.Background(), logtool.FacetOptions{SearchOptions: logtool.SearchOptions{RootDir: dir}, Interval: "10ms"}); err == nil {
+		t.Error("FacetLogs() with a sub-second interval error = nil")
+	}
+}
@@ -0,0 +1,133 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jom-io/gorig-om/src/logtool"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogIndex(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".logs", "rest", "rest.jsonl")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	// enough lines for several time marks
+	var b strings.Builder
+	const total = 3000
+	for i := 1; i <= total; i++ {
+		ts := base.Add(time.Duration(i) * time.Second).Format("2006-01-02 15:04:05.000")
+		_, _ = fmt.Fprintf(&b, `{"level":"info","time":"%s","msg":"line-%d","_trace_id_":"trace-%d","pad":"%s"}`+"\n", ts, i, i%100, strings.Repeat("x", 40))
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := logtool.IndexLogs(dir); err != nil {
+		t.Fatalf("IndexLogs() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".logs", ".index", "rest", "rest.jsonl.idx")); err != nil {
+		t.Fatalf("index file missing: %v", err)
+	}
+
+	// lines written after indexing are still found
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	_, _ = fmt.Fprintf(f, `{"level":"info","time":"%s","msg":"late","_trace_id_":"trace-7"}`+"\n", base.Add(time.Hour).Format("2006-01-02 15:04:05.000"))
+	_ = f.Close()
+
+	search := func(opts logtool.SearchOptions) []logtool.MatchedRecord {
+		opts.RootDir, opts.Size = dir, 100
+		if opts.StartTime == "" {
+			opts.StartTime = base.Format("2006-01-02 15:04:05")
+		}
+		if opts.EndTime == "" {
+			opts.EndTime = base.Add(2 * time.Hour).Format("2006-01-02 15:04:05")
+		}
+		page, err := logtool.SearchLogsPage(opts)
+		if err != nil {
+			t.Fatalf("SearchLogsPage() error = %v", err)
+		}
+		return page.Records
+	}
+	records := search(logtool.SearchOptions{TraceID: "trace-7", Order: logtool.OrderAsc})
+	if len(records) != total/100+1 || records[0].LineNumber != 7 || records[len(records)-1].Record.Msg != "late" {
+		t.Fatalf("trace search found %d records, first line %d", len(records), records[0].LineNumber)
+	}
+	for _, r := range records {
+		if r.Record.Msg != "late" && r.Record.Msg != fmt.Sprintf("line-%d", r.LineNumber) {
+			t.Fatalf("record %s reported at line %d", r.Record.Msg, r.LineNumber)
+		}
+	}
+
+	// a later run appends the new lines to the index file instead of rewriting it
+	idxPath := filepath.Join(dir, ".logs", ".index", "rest", "rest.jsonl.idx")
+	before, _ := os.ReadFile(idxPath)
+	if err := logtool.IndexLogs(dir); err != nil {
+		t.Fatalf("IndexLogs() error = %v", err)
+	}
+	after, _ := os.ReadFile(idxPath)
+	if len(after) <= len(before) || !bytes.Equal(after[:len(before)], before) {
+		t.Fatalf("index file went from %d to %d bytes, not appended", len(before), len(after))
+	}
+	if records = search(logtool.SearchOptions{TraceID: "trace-7"}); len(records) != total/100+1 {
+		t.Fatalf("trace search after appending found %d records", len(records))
+	}
+
+	// a rotated backup is indexed once, then left alone
+	backup := filepath.Join(dir, ".logs", "rest", "rest-2024-05-01T01-00-00.000.jsonl")
+	if err := os.WriteFile(backup, []byte(fmt.Sprintf(`{"level":"info","time":"%s","msg":"old","_trace_id_":"trace-old"}`+"\n", base.Add(-time.Hour).Format("2006-01-02 15:04:05.000"))), 0644); err != nil {
+		t.Fatal(err)
+	}
+	backupIdx := filepath.Join(dir, ".logs", ".index", "rest", filepath.Base(backup)+".idx")
+	if err := logtool.IndexLogs(dir); err != nil {
+		t.Fatalf("IndexLogs() error = %v", err)
+	}
+	if before, _ = os.ReadFile(backupIdx); len(before) == 0 {
+		t.Fatal("backup index missing")
+	}
+	f, _ = os.OpenFile(backup, os.O_APPEND|os.O_WRONLY, 0644)
+	_, _ = fmt.Fprintf(f, `{"level":"info","time":"%s","msg":"older","_trace_id_":"trace-old"}`+"\n", base.Add(-time.Hour).Format("2006-01-02 15:04:05.000"))
+	_ = f.Close()
+	if err := logtool.IndexLogs(dir); err != nil {
+		t.Fatalf("IndexLogs() error = %v", err)
+	}
+	if after, _ = os.ReadFile(backupIdx); !bytes.Equal(after, before) {
+		t.Fatal("backup index was updated again")
+	}
+	_ = os.Remove(backup)
+
+	records = search(logtool.SearchOptions{StartTime: base.Add(2500 * time.Second).Format("2006-01-02 15:04:05"), Keyword: "line-", Order: logtool.OrderAsc})
+	if len(records) == 0 || records[0].LineNumber != 2500 {
+		t.Fatalf("time search found %d records, want the first at line 2500", len(records))
+	}
+
+	lines, err := logtool.FetchContextLines(path, 2800, 1)
+	if err != nil || len(lines) != 3 || lines[1].Record.Msg != "line-2800" || lines[1].LineNumber != 2800 {
+		t.Fatalf("FetchContextLines() = %+v, %v", lines, err)
+	}
+
+	// a replaced file makes the index stale, search falls back to scanning
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(`{"level":"info","time":"%s","msg":"new","_trace_id_":"trace-7"}`+"\n", base.Add(time.Minute).Format("2006-01-02 15:04:05.000"))), 0644); err != nil {
+		t.Fatal(err)
+	}
+	records = search(logtool.SearchOptions{TraceID: "trace-7"})
+	if len(records) != 1 || records[0].Record.Msg != "new" {
+		t.Fatalf("search on a replaced file = %+v", records)
+	}
+
+	// deleting the log removes its index
+	_ = os.Remove(path)
+	_ = os.WriteFile(filepath.Join(dir, ".logs", "rest", "rest-other.jsonl"), []byte("{}\n"), 0644)
+	if err := logtool.IndexLogs(dir); err != nil {
+		t.Fatalf("IndexLogs() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".logs", ".index", "rest", "rest.jsonl.idx")); !os.IsNotExist(err) {
+		t.Fatalf("stale index kept: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig-om/src/logtool"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestLogs creates rest and commons log files with interleaved record times under dir/.logs.
+func writeTestLogs(t *testing.T, dir string) int {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	files := map[string][]int{
+		"rest/rest.jsonl":                               {0, 3, 6, 9, 12, 15},
+		"rest/rest-2024-05-01T09-00-00.000.jsonl":       {1, 4, 7},
+		"commons/commons.jsonl":                         {2, 5, 8, 10, 10, 11},
+		"commons/commons-2024-05-01T09-00-00.000.jsonl": {13, 14},
+	}
+	count := 0
+	for name, seconds := range files {
+		path := filepath.Join(dir, ".logs", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, sec := range seconds {
+			ts := base.Add(time.Duration(sec) * time.Second).Format("2006-01-02 15:04:05.000")
+			_, _ = fmt.Fprintf(f, "{\"level\":\"info\",\"time\":\"%s\",\"msg\":\"m-%s-%d\"}\n", ts, filepath.Base(name), i)
+			count++
+		}
+		_ = f.Close()
+	}
+	return count
+}
+
+func recordKeyOf(m logtool.MatchedRecord) string {
+	return fmt.Sprintf("%s|%s|%08d", m.Record.Time, m.FilePath, m.LineNumber)
+}
+
+func TestSearchLogsPageOrder(t *testing.T) {
+	dir := t.TempDir()
+	total := writeTestLogs(t, dir)
+
+	for _, order := range []string{logtool.OrderDesc, logtool.OrderAsc} {
+		opts := logtool.SearchOptions{RootDir: dir, Size: 4, Order: order}
+		seen := make([]string, 0)
+		pages := make([]*logtool.SearchPage, 0)
+		for i := 0; i < 10; i++ {
+			page, err := logtool.SearchLogsPage(opts)
+			if err != nil {
+				t.Fatalf("SearchLogsPage() error = %v", err)
+			}
+			pages = append(pages, page)
+			for _, r := range page.Records {
+				seen = append(seen, recordKeyOf(r))
+			}
+			if page.Next == "" {
+				break
+			}
+			opts.Cursor = page.Next
+		}
+		if len(seen) != total {
+			t.Fatalf("%s: paged %d records, want %d", order, len(seen), total)
+		}
+		for i := 1; i < len(seen); i++ {
+			if (order == logtool.OrderDesc && seen[i-1] <= seen[i]) || (order == logtool.OrderAsc && seen[i-1] >= seen[i]) {
+				t.Errorf("%s: records %d and %d out of order: %s, %s", order, i-1, i, seen[i-1], seen[i])
+			}
+		}
+
+		// Going back from the second page must give the first page again.
+		back, err := logtool.SearchLogsPage(logtool.SearchOptions{RootDir: dir, Size: 4, Order: order, Cursor: pages[1].Prev})
+		if err != nil {
+			t.Fatalf("SearchLogsPage(prev) error = %v", err)
+		}
+		if len(back.Records) != len(pages[0].Records) {
+			t.Fatalf("%s: prev page has %d records, want %d", order, len(back.Records), len(pages[0].Records))
+		}
+		for i := range back.Records {
+			if recordKeyOf(back.Records[i]) != recordKeyOf(pages[0].Records[i]) {
+				t.Errorf("%s: prev page record %d = %s, want %s", order, i, recordKeyOf(back.Records[i]), recordKeyOf(pages[0].Records[i]))
+			}
+		}
+		if back.Prev != "" || back.Next == "" {
+			t.Errorf("%s: first page cursors prev=%q next=%q", order, back.Prev, back.Next)
+		}
+		if pages[0].Progress.Files != 4 || pages[0].Progress.ScannedFiles+pages[0].Progress.SkippedFiles != 4 {
+			t.Errorf("%s: progress = %+v", order, pages[0].Progress)
+		}
+	}
+
+	if _, err := logtool.SearchLogsPage(logtool.SearchOptions{RootDir: dir, Order: logtool.OrderAsc, Cursor: "bad"}); err == nil {
+		t.Errorf("SearchLogsPage() accepted an invalid cursor")
+	}
+}
+
+func TestSearchLogsLegacyCursor(t *testing.T) {
+	dir := t.TempDir()
+	writeTestLogs(t, dir)
+
+	first, err := logtool.SearchLogs(logtool.SearchOptions{RootDir: dir, Size: 3})
+	if err != nil || len(first) != 3 {
+		t.Fatalf("SearchLogs() = %d records, error = %v", len(first), err)
+	}
+	last := first[len(first)-1]
+	second, err := logtool.SearchLogs(logtool.SearchOptions{RootDir: dir, Size: 3, LastPath: last.FilePath, LastLine: last.LineNumber})
+	if err != nil || len(second) == 0 {
+		t.Fatalf("SearchLogs(lastPath) = %d records, error = %v", len(second), err)
+	}
+	if recordKeyOf(second[0]) >= recordKeyOf(last) {
+		t.Errorf("legacy cursor did not continue after %s, got %s", recordKeyOf(last), recordKeyOf(second[0]))
+	}
+
+	outside := filepath.Join(t.TempDir(), "other.jsonl")
+	if err := os.WriteFile(outside, []byte(`{"level":"info","time":"2025-03-01 10:00:00.000","msg":"other"}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = logtool.SearchLogs(logtool.SearchOptions{RootDir: dir, Size: 3, LastPath: outside, LastLine: 1}); err == nil {
+		t.Error("SearchLogs(lastPath) outside the log roots succeeded")
+	}
+}
+
+func TestSearchLogsPageCancel(t *testing.T) {
+	dir := t.TempDir()
+	total := writeTestLogs(t, dir)
+
+	page, err := logtool.SearchLogsPageContext(context.Background(), logtool.SearchOptions{RootDir: dir, Size: total})
+	if err != nil {
+		t.Fatalf("SearchLogsPageContext() error = %v", err)
+	}
+	if page.Truncated || len(page.Records) != total || page.Progress.ScannedBytes == 0 {
+		t.Fatalf("SearchLogsPageContext() truncated = %v, records = %d, progress = %+v", page.Truncated, len(page.Records), page.Progress)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	page, err = logtool.SearchLogsPageContext(ctx, logtool.SearchOptions{RootDir: dir, Size: total})
+	if err != nil {
+		t.Fatalf("SearchLogsPageContext(cancelled) error = %v", err)
+	}
+	if !page.Truncated || len(page.Records) != 0 {
+		t.Fatalf("SearchLogsPageContext(cancelled) truncated = %v, records = %d", page.Truncated, len(page.Records))
+	}
+}
@@ -0,0 +1,85 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig-om/src/logtool"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFindPatterns(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	lines := map[string][]string{}
+	add := func(category string, sec int, level, msg, errStr, trace string) {
+		ts := base.Add(time.Duration(sec) * time.Second).Format("2006-01-02 15:04:05.000")
+		lines[category] = append(lines[category], fmt.Sprintf(`{"level":"%s","time":"%s","msg":"%s","error":"%s","_trace_id_":"%s"}`, level, ts, msg, errStr, trace))
+	}
+	add("invoke", 1, "error", "payment callback failed order 1001 in 35ms", "", "t1")
+	add("invoke", 2, "error", "payment callback failed order 1002 in 12ms", "", "")
+	add("rest", 3, "warn", "payment callback failed order 1003 in 9ms", "", "")
+	add("rest", 4, "info", "user alice logged in", "", "")
+	add("rest", 5, "info", "user bob logged in", "", "")
+	add("commons", 6, "info", "cache miss key /api/v1/item", "", "")
+	add("commons", 7, "error", "", "dial tcp 10.0.0.1:3306: connection refused", "")
+	for category, ls := range lines {
+		path := filepath.Join(dir, ".logs", category, category+".jsonl")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		data := ""
+		for _, l := range ls {
+			data += l + "\n"
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := logtool.FindPatterns(context.Background(), logtool.PatternOptions{SearchOptions: logtool.SearchOptions{RootDir: dir}})
+	if err != nil {
+		t.Fatalf("FindPatterns() error = %v", err)
+	}
+	if result.Total != 7 || result.Clusters != 4 {
+		t.Fatalf("FindPatterns() total = %d, clusters = %d, want 7 and 4", result.Total, result.Clusters)
+	}
+
+	first := result.Patterns[0]
+	if first.Template != "payment callback failed order <*> in <*>" || first.Count != 3 {
+		t.Fatalf("FindPatterns() top pattern = %q x %d", first.Template, first.Count)
+	}
+	if first.Levels["error"] != 2 || first.Categories["rest"] != 1 || first.SampleTrace != "t1" {
+		t.Errorf("FindPatterns() top pattern stats = %+v", first)
+	}
+	if first.FirstSeen != base.Add(time.Second).Format("2006-01-02 15:04:05.000") || first.Sample.Record.Msg != "payment callback failed order 1003 in 9ms" {
+		t.Errorf("FindPatterns() top pattern seen = %s, sample = %+v", first.FirstSeen, first.Sample.Record)
+	}
+
+	templates := map[string]*logtool.LogPattern{}
+	for _, p := range result.Patterns {
+		templates[p.Field+"|"+p.Template] = p
+	}
+	for _, want := range []string{"msg|user <*> logged in", "msg|cache miss key /api/v1/item", "error|dial tcp <*>: connection refused"} {
+		if templates[want] == nil {
+			t.Errorf("FindPatterns() missing template %s in %v", want, templates)
+		}
+	}
+
+	// Each pattern query finds exactly the lines of its template.
+	for _, p := range result.Patterns {
+		page, err := logtool.SearchLogsPage(logtool.SearchOptions{RootDir: dir, Query: p.Query, Size: 100})
+		if err != nil {
+			t.Fatalf("SearchLogsPage(%s) error = %v", p.Query, err)
+		}
+		if int64(len(page.Records)) != p.Count {
+			t.Errorf("SearchLogsPage(%s) = %d records, want %d", p.Query, len(page.Records), p.Count)
+		}
+	}
+
+	if _, err := logtool.FindPatterns(context.Background(), logtool.PatternOptions{SearchOptions: logtool.SearchOptions{RootDir: dir}, Similarity: 2}); err == nil {
+		t.Errorf("FindPatterns() should reject a similarity above 1")
+	}
+}
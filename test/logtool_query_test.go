@@ -0,0 +1,98 @@
+package test
+
+import (
+	"github.com/jom-io/gorig-om/src/logtool"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseQueryMatch(t *testing.T) {
+	rec := &logtool.LogRecord{
+		Level:   "error",
+		Time:    "2024-05-01 10:00:00.000",
+		Msg:     "order failed",
+		TraceID: "cov9a8b1e5g0a7h2s3t0",
+		Data: map[string]string{
+			"uri":    "/api/order/create",
+			"status": "502",
+			"req":    `{"id":"r-1","user":"Alice"}`,
+		},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`level:error AND data.uri:/api/order* AND data.status>=500 AND NOT msg:"health"`, true},
+		{`level:error data.status<500`, false},
+		{`status>500 status<=502`, true},
+		{`level:warn OR (msg:failed AND -level:info)`, true},
+		{`msg:~ORDER`, true},
+		{`msg:ORDER`, false},
+		{`data.uri:/order\/create$/`, true},
+		{`data.uri:/ORDER/i`, true},
+		{`data.uri=/api/order`, false},
+		{`data.uri!=/api/order`, true},
+		{`data.req.user:Alice`, true},
+		{`data.missing:*`, false},
+		{`NOT data.missing:*`, true},
+		{`trace:cov9a8b1e5g0a7h2s3t0`, true},
+		{`failed`, true},
+		{`"order/create"`, true},
+		{`level:error !failed`, false},
+		{`time>="2024-05-01 09:00:00"`, true},
+	}
+	for _, tt := range tests {
+		q, err := logtool.ParseQuery(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseQuery(%q) error = %v", tt.expr, err)
+		}
+		if got := q.Match(rec); got != tt.want {
+			t.Errorf("ParseQuery(%q).Match() = %v, want %v", tt.expr, got, tt.want)
+		}
+		line := rec.ToJsonStr()
+		if tt.want && !q.PreMatch(line) {
+			t.Errorf("ParseQuery(%q).PreMatch() rejected a matching line %s", tt.expr, line)
+		}
+	}
+
+	for _, expr := range []string{`level:error AND`, `(level:error`, `msg:"open`, `data.uri:/[/`, `level:`, `)`} {
+		if _, err := logtool.ParseQuery(expr); err == nil {
+			t.Errorf("ParseQuery(%q) error = nil, want a syntax error", expr)
+		}
+	}
+}
+
+func TestSearchLogsQuery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".logs", "rest", "rest.jsonl")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{
+		`{"level":"info","time":"2024-05-01 10:00:00.000","msg":"health","uri":"/api/health","status":200}`,
+		`{"level":"error","time":"2024-05-01 10:00:01.000","msg":"upstream","uri":"/api/order/pay","status":502}`,
+		`{"level":"error","time":"2024-05-01 10:00:02.000","msg":"health","uri":"/api/order/health","status":503}`,
+		`{"level":"error","time":"2024-05-01 10:00:03.000","msg":"bad request","uri":"/api/order/pay","status":400}`,
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := logtool.SearchLogsPage(logtool.SearchOptions{
+		RootDir: dir,
+		Size:    10,
+		Query:   `level:error AND data.uri:/api/order* AND data.status>=500 AND NOT msg:"health"`,
+	})
+	if err != nil {
+		t.Fatalf("SearchLogsPage() error = %v", err)
+	}
+	if len(page.Records) != 1 || page.Records[0].LineNumber != 2 {
+		t.Fatalf("SearchLogsPage() = %+v, want only line 2", page.Records)
+	}
+
+	if _, err = logtool.SearchLogsPage(logtool.SearchOptions{RootDir: dir, Query: `level:(error`}); err == nil {
+		t.Fatal("SearchLogsPage() with a bad query error = nil")
+	}
+}
@@ -0,0 +1,141 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig-om/src/logtool"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedactRecord(t *testing.T) {
+	r, err := logtool.CompileRedaction([]logtool.RedactRule{
+		{Field: "*password*"},
+		{Path: "data.body.card.cvv"},
+		{Regexp: `\b(1\d{2})\d{4}(\d{4})\b`, Replace: "$1****$2"},
+	})
+	if err != nil {
+		t.Fatalf("CompileRedaction() error = %v", err)
+	}
+	rec := r.Record(&logtool.LogRecord{
+		Msg: "IN",
+		Data: map[string]string{
+			"password": "p1",
+			"phone":    "13812345678",
+			"body":     `{"user":"bob","newPassword":"p2","card":{"cvv":"123","no":"4111"},"tags":["<b>"]}`,
+		},
+	})
+	if rec.Data["password"] != "******" || rec.Data["phone"] != "138****5678" {
+		t.Errorf("Record() data = %v", rec.Data)
+	}
+	body := map[string]any{}
+	if err := json.Unmarshal([]byte(rec.Data["body"]), &body); err != nil {
+		t.Fatalf("Record() body = %s: %v", rec.Data["body"], err)
+	}
+	card := body["card"].(map[string]any)
+	if body["newPassword"] != "******" || card["cvv"] != "******" || card["no"] != "4111" || body["user"] != "bob" {
+		t.Errorf("Record() body = %s", rec.Data["body"])
+	}
+	if !strings.Contains(rec.Data["body"], `"<b>"`) {
+		t.Errorf("Record() body escaped HTML: %s", rec.Data["body"])
+	}
+
+	line := `{"level":"info","msg":"login 13812345678","password":"p1","n":12345678901234567890}` + "\n"
+	if got := r.Line(line); got != `{"level":"info","msg":"login 138****5678","n":12345678901234567890,"password":"******"}`+"\n" {
+		t.Errorf("Line(json) = %q", got)
+	}
+	if got := r.Line("2025-03-01 10:00:00 INFO user=bob password=abc phone=13812345678"); got != "2025-03-01 10:00:00 INFO user=bob password=****** phone=138****5678" {
+		t.Errorf("Line(text) = %q", got)
+	}
+	if got := r.Line(`{"level":"info","msg":"ok"}`); got != `{"level":"info","msg":"ok"}` {
+		t.Errorf("Line(untouched) = %q", got)
+	}
+
+	for _, rules := range [][]logtool.RedactRule{
+		{{Field: "a", Regexp: "b"}},
+		{{}},
+		{{Path: "data..x"}},
+		{{Regexp: "("}},
+		{{Regexp: ".*"}},
+	} {
+		if _, err := logtool.CompileRedaction(rules); err == nil {
+			t.Errorf("CompileRedaction(%+v) succeeded", rules)
+		}
+	}
+}
+
+func TestRedactSearch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".logs", "rest", "rest.jsonl")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	line := `{"level":"info","time":"2025-03-01 10:00:00.000","msg":"IN","token":"tk-secret","body":"{\"password\":\"pw-secret\",\"user\":\"bob\"}"}` + "\n"
+	if err := os.WriteFile(path, []byte(line), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/search/page", func(c *gin.Context) {
+		c.Set(logtool.RootDirKey, dir)
+	}, logtool.SearchPaged)
+	search := func(opts map[string]any) string {
+		body, _ := json.Marshal(opts)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/search/page", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	// the default rules mask token and password, also inside the logged body
+	body := search(map[string]any{})
+	if strings.Contains(body, "tk-secret") || strings.Contains(body, "pw-secret") || !strings.Contains(body, "bob") {
+		t.Errorf("search = %s", body)
+	}
+	// filters see the masked record, so they cannot probe a hidden value
+	if body = search(map[string]any{"keyword": "tk-secret"}); strings.Contains(body, "rest.jsonl") {
+		t.Errorf("search by a masked value = %s", body)
+	}
+	// unmasked needs logs:unmasked, which an anonymous request does not hold
+	if body = search(map[string]any{"unmasked": true}); strings.Contains(body, "tk-secret") {
+		t.Errorf("unmasked search without permission = %s", body)
+	}
+}
+
+func TestSearchLogsRootDirParam(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".logs", "rest", "rest.jsonl")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"level":"info","time":"2025-03-01 10:00:00.000","msg":"elsewhere-7f3a"}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/search/page", logtool.SearchPaged)
+	engine.GET("/export", logtool.Export)
+	body, _ := json.Marshal(map[string]any{"root_dir": dir, "rootDir": dir, "keyword": "elsewhere-7f3a"})
+	req := httptest.NewRequest(http.MethodPost, "/search/page?"+url.Values{"rootDir": {dir}}.Encode(), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if strings.Contains(w.Body.String(), "2025-03-01") {
+		t.Errorf("search read the rootDir of the request: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export?"+url.Values{"rootDir": {dir}, "keyword": {"elsewhere-7f3a"}}.Encode(), nil))
+	if strings.Contains(w.Body.String(), "2025-03-01") {
+		t.Errorf("export read the rootDir of the request: %s", w.Body.String())
+	}
+}
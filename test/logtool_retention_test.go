@@ -0,0 +1,100 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig-om/src/logtool"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogRetention(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(category, name string, daysAgo int, pad int) string {
+		path := filepath.Join(dir, ".logs", category, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		ts := now.Add(-time.Duration(daysAgo) * 24 * time.Hour).Format("2006-01-02 15:04:05.000")
+		line := fmt.Sprintf(`{"level":"info","time":"%s","msg":"%s %s"}`+"\n", ts, name, strings.Repeat("x", pad))
+		if err := os.WriteFile(path, []byte(line), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	active := write("rest", "rest.jsonl", 60, 0)
+	expired := write("rest", "rest-2024-01-01T00-00-00.000.jsonl", 40, 0)
+	stale := write("rest", "rest-2024-02-01T00-00-00.000.jsonl", 10, 0)
+	recent := write("rest", "rest-2024-03-01T00-00-00.000.jsonl", 2, 0)
+	big1 := write("commons", "commons-2024-01-01T00-00-00.000.jsonl", 3, 700*1024)
+	big2 := write("commons", "commons-2024-02-01T00-00-00.000.jsonl", 2, 700*1024)
+	write("commons", "commons.jsonl", 0, 0)
+
+	req := logtool.RetentionReq{RootDir: dir, Policies: []logtool.RetentionPolicy{
+		{Category: "*", MaxDays: 30, CompressDays: 7},
+		{Category: "commons", MaxSizeMB: 1},
+	}}
+	actions := func(result *logtool.RetentionResult) map[string]string {
+		out := map[string]string{}
+		for _, a := range result.Actions {
+			if a.Error != "" {
+				t.Errorf("ApplyRetention() %s %s failed: %s", a.Action, a.Path, a.Error)
+			}
+			out[a.Path] = a.Action
+		}
+		return out
+	}
+	want := map[string]string{expired: logtool.RetentionDelete, stale: logtool.RetentionCompress, big1: logtool.RetentionDelete}
+
+	preview, err := logtool.ApplyRetention(context.Background(), req, true)
+	if err != nil {
+		t.Fatalf("ApplyRetention(dry run) error = %v", err)
+	}
+	if got := actions(preview); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("ApplyRetention(dry run) = %v, want %v", got, want)
+	}
+	for _, path := range []string{expired, stale, big1} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("dry run touched %s: %v", path, err)
+		}
+	}
+
+	result, err := logtool.ApplyRetention(context.Background(), req, false)
+	if err != nil {
+		t.Fatalf("ApplyRetention() error = %v", err)
+	}
+	if got := actions(result); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("ApplyRetention() = %v, want %v", got, want)
+	}
+	for path, exists := range map[string]bool{active: true, expired: false, stale: false, recent: true, big1: false, big2: true, strings.TrimSuffix(stale, ".jsonl") + ".jsonl.gz": true} {
+		if _, err := os.Stat(path); (err == nil) != exists {
+			t.Errorf("after ApplyRetention() %s exists = %v, want %v", filepath.Base(path), err == nil, exists)
+		}
+	}
+
+	// The compressed file is still searchable.
+	page, err := logtool.SearchLogsPage(logtool.SearchOptions{RootDir: dir, Categories: []string{"rest"}, Keyword: "rest-2024-02-01", Size: 10})
+	if err != nil || len(page.Records) != 1 {
+		t.Errorf("SearchLogsPage() after compression = %v, %v", page, err)
+	}
+
+	usage, err := logtool.FetchUsage(dir)
+	if err != nil {
+		t.Fatalf("FetchUsage() error = %v", err)
+	}
+	if len(usage.Categories) != 2 || usage.Files != 5 {
+		t.Fatalf("FetchUsage() = %+v", usage)
+	}
+	rest := usage.Categories[1]
+	if rest.Category != "rest" || rest.Files != 3 || rest.Archives != 1 || rest.Oldest > rest.Newest || !strings.HasPrefix(rest.Oldest, now.Add(-60*24*time.Hour).Format("2006-01-02")) {
+		t.Errorf("FetchUsage() rest = %+v", rest)
+	}
+
+	if err := logtool.SetRetention(context.Background(), []logtool.RetentionPolicy{{Category: "rest", MaxDays: -1}}); err == nil {
+		t.Errorf("SetRetention() should reject negative limits")
+	}
+}
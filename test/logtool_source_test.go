@@ -0,0 +1,143 @@
+package test
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig-om/src/logtool"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogSources(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write(".logs/app/app.jsonl", `{"level":"info","time":"2025-03-01 10:00:00.000","msg":"app started"}`+"\n")
+	worker := write("worker.log", strings.Join([]string{
+		"2025-03-01 10:00:01 INFO worker started",
+		"2025-03-01 10:05:00 ERROR job 42 failed",
+		"    at job.run(job.go:12)",
+		"2025-03-01 11:00:00 WARN queue is slow",
+	}, "\n")+"\n")
+	write("svc.log", `time=2025-03-01T10:02:00 level=error msg="db timeout" trace=abc host=db1`+"\n")
+	write("nginx/access.log", strings.Join([]string{
+		`10.0.0.1 - - [01/Mar/2025:10:03:00 +0800] "GET /api/ping HTTP/1.1" 200 12 "-" "curl/8.0"`,
+		`10.0.0.2 - bob [01/Mar/2025:10:04:00 +0800] "POST /api/pay HTTP/1.1" 502 0 "-" "Mozilla/5.0"`,
+	}, "\n")+"\n")
+
+	for _, src := range []logtool.LogSource{
+		{Name: "test_worker", Path: filepath.Join(dir, "worker.log"), Parser: logtool.ParserText},
+		{Name: "test_svc", Path: filepath.Join(dir, "svc.log"), Parser: logtool.ParserLogfmt},
+		{Name: "test_nginx", Path: filepath.Join(dir, "nginx", "*.log"), Parser: logtool.ParserNginx},
+	} {
+		if err := logtool.RegisterSource(src); err != nil {
+			t.Fatalf("RegisterSource(%s) error = %v", src.Name, err)
+		}
+	}
+	if err := logtool.RegisterSource(logtool.LogSource{Name: "test_bad", Path: "x.log", Parser: "nope"}); err == nil {
+		t.Error("RegisterSource() with an unknown parser succeeded")
+	}
+
+	categories, e := logtool.FetchCategories(dir)
+	if e != nil {
+		t.Fatalf("FetchCategories() error = %v", e)
+	}
+	sort.Strings(categories)
+	if got := strings.Join(categories, ","); got != "app,test_nginx,test_svc,test_worker" {
+		t.Fatalf("FetchCategories() = %s", got)
+	}
+
+	search := func(opts logtool.SearchOptions) []logtool.MatchedRecord {
+		t.Helper()
+		opts.RootDir = dir
+		page, err := logtool.SearchLogsPageContext(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("SearchLogsPageContext(%+v) error = %v", opts, err)
+		}
+		return page.Records
+	}
+
+	errs := search(logtool.SearchOptions{Level: "error"})
+	msgs := make([]string, 0, len(errs))
+	for _, m := range errs {
+		msgs = append(msgs, m.Record.Msg)
+	}
+	sort.Strings(msgs)
+	if got := strings.Join(msgs, "|"); got != "ERROR job 42 failed|POST /api/pay HTTP/1.1|db timeout" {
+		t.Fatalf("error records = %s", got)
+	}
+
+	nginx := search(logtool.SearchOptions{Categories: []string{"test_nginx"}, Query: "status:502"})
+	if len(nginx) != 1 || nginx[0].Record.Data["remote_user"] != "bob" || nginx[0].Record.Data["uri"] != "/api/pay" {
+		t.Fatalf("nginx records = %+v", nginx)
+	}
+	svc := search(logtool.SearchOptions{Categories: []string{"test_svc"}, Query: "trace:abc"})
+	if len(svc) != 1 || svc[0].Record.Data["host"] != "db1" || svc[0].Record.Time != "2025-03-01 10:02:00.000" {
+		t.Fatalf("logfmt records = %+v", svc)
+	}
+
+	ranged := search(logtool.SearchOptions{Categories: []string{"test_worker"}, StartTime: "2025-03-01 10:01:00", EndTime: "2025-03-01 10:30:00"})
+	if len(ranged) != 1 || ranged[0].LineNumber != 2 || ranged[0].Record.Time != "2025-03-01 10:05:00.000" {
+		t.Fatalf("worker records in range = %+v", ranged)
+	}
+
+	lines, e := logtool.FetchContextLines(worker, 2, 1)
+	if e != nil {
+		t.Fatalf("FetchContextLines() error = %v", e)
+	}
+	if len(lines) != 3 || lines[1].Record.Level != "error" || lines[2].Record.Msg != "at job.run(job.go:12)" {
+		t.Fatalf("FetchContextLines() = %+v", lines)
+	}
+
+	// context reads are limited to log files and the files of registered sources
+	near := func(path string) string {
+		engine := gin.New()
+		engine.GET("/near", logtool.Near)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/near?"+url.Values{"path": {path}, "line": {"1"}, "range": {"1"}}.Encode(), nil))
+		return w.Body.String()
+	}
+	if body := near(worker); !strings.Contains(body, "worker started") {
+		t.Errorf("Near(source file) = %s", body)
+	}
+	if body := near(write("secret.txt", "2025-03-01 10:00:00 INFO secret\n")); strings.Contains(body, "secret") {
+		t.Errorf("Near(unregistered file) = %s", body)
+	}
+
+	registered := map[string]bool{}
+	for _, src := range logtool.Sources() {
+		registered[src.Name] = true
+	}
+	for _, name := range []string{"test_worker", "test_svc", "test_nginx"} {
+		if !registered[name] {
+			t.Errorf("Sources() misses %s", name)
+		}
+	}
+
+	// source files are followed by the monitor like the categories of .logs
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	wait := monitorStream(t, ctx, newMonitorServer(t, dir).URL, url.Values{"categories": {"test_worker"}})
+	f, err := os.OpenFile(worker, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(time.Now().Format("2006-01-02 15:04:05") + " INFO worker stopped\n")
+	_ = f.Close()
+	wait("INFO worker stopped")
+}
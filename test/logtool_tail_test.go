@@ -0,0 +1,152 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig-om/src/logtool"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func appendLogLines(t *testing.T, path string, msgs ...string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := ""
+	for _, m := range msgs {
+		data += fmt.Sprintf(`{"level":"info","time":"%s","msg":"%s"}`+"\n", time.Now().Format("2006-01-02 15:04:05.000"), m)
+	}
+	_, _ = f.WriteString(data)
+	_ = f.Close()
+}
+
+// monitorStream opens log/monitor on a test server and returns a function waiting for records with the given messages.
+func monitorStream(t *testing.T, ctx context.Context, srvURL string, params url.Values) func(want ...string) {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srvURL+"/monitor?"+params.Encode(), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+
+	events := make(chan string, 100)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data:"); ok {
+				events <- data
+			}
+		}
+		close(events)
+	}()
+	if data := <-events; !strings.Contains(data, "monitoring started") {
+		t.Fatalf("first event = %s", data)
+	}
+	return func(want ...string) {
+		t.Helper()
+		got := make([]string, 0)
+		for len(got) < len(want) {
+			select {
+			case data, ok := <-events:
+				if !ok {
+					t.Fatalf("stream closed, got %v, want %v", got, want)
+				}
+				if strings.HasPrefix(data, "{") {
+					got = append(got, data)
+				}
+			case <-ctx.Done():
+				t.Fatalf("timed out, got %v, want %v", got, want)
+			}
+		}
+		for i, w := range want {
+			if !strings.Contains(got[i], `"msg":"`+w+`"`) {
+				t.Fatalf("event %d = %s, want msg %s", i, got[i], w)
+			}
+		}
+	}
+}
+
+func newMonitorServer(t *testing.T, dir string) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/monitor", func(c *gin.Context) {
+		c.Set(logtool.RootDirKey, dir)
+	}, logtool.Monitor)
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMonitorLogsTail(t *testing.T) {
+	dir := t.TempDir()
+	restDir := filepath.Join(dir, ".logs", "rest")
+	if err := os.MkdirAll(restDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	live := filepath.Join(restDir, "rest.jsonl")
+	appendLogLines(t, live, "before")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	wait := monitorStream(t, ctx, newMonitorServer(t, dir).URL, url.Values{"query": {"NOT msg:skip"}})
+
+	// a burst in one write yields every line, filtered by the query
+	appendLogLines(t, live, "a1", "skip", "a2", "a3")
+	wait("a1", "a2", "a3")
+
+	// rotation: the old file is renamed and a new one created under the same name
+	if err := os.Rename(live, filepath.Join(restDir, "rest-2024-05-01T09-00-00.000.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+	appendLogLines(t, live, "b1", "b2")
+	wait("b1", "b2")
+
+	// truncation starts over from the beginning of the file
+	time.Sleep(50 * time.Millisecond)
+	if err := os.WriteFile(live, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	appendLogLines(t, live, "c1")
+	wait("c1")
+
+	// a category created after the stream started
+	if err := os.MkdirAll(filepath.Join(dir, ".logs", "jobs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	appendLogLines(t, filepath.Join(dir, ".logs", "jobs", "jobs.jsonl"), "d1")
+	wait("d1")
+}
+
+func TestMonitorLogsShared(t *testing.T) {
+	dir := t.TempDir()
+	for _, cat := range []string{"rest", "jobs"} {
+		if err := os.MkdirAll(filepath.Join(dir, ".logs", cat), 0755); err != nil {
+			t.Fatal(err)
+		}
+		appendLogLines(t, filepath.Join(dir, ".logs", cat, cat+".jsonl"), "before")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := newMonitorServer(t, dir)
+	all := monitorStream(t, ctx, srv.URL, url.Values{})
+	rest := monitorStream(t, ctx, srv.URL, url.Values{"categories": {"rest"}})
+	odd := monitorStream(t, ctx, srv.URL, url.Values{"keyword": {"odd"}})
+
+	appendLogLines(t, filepath.Join(dir, ".logs", "jobs", "jobs.jsonl"), "j-odd")
+	all("j-odd")
+	odd("j-odd")
+	appendLogLines(t, filepath.Join(dir, ".logs", "rest", "rest.jsonl"), "r-even", "r-odd")
+	all("r-even", "r-odd")
+	rest("r-even", "r-odd")
+	odd("r-odd")
+}
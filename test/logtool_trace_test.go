@@ -0,0 +1,112 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/jom-io/gorig-om/src/logtool"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTraceTimeline(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	const trace = "cov9a8b1e5g0a7h2s3t0"
+	write := func(cat string, lines ...string) {
+		path := filepath.Join(dir, ".logs", cat, cat+".jsonl")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		data := ""
+		for _, l := range lines {
+			data += l + "\n"
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec := func(ms int, msg, extra string) string {
+		ts := base.Add(time.Duration(ms) * time.Millisecond).Format("2006-01-02 15:04:05.000")
+		return fmt.Sprintf(`{"level":"info","time":"%s","msg":"%s","_trace_id_":"%s"%s}`, ts, msg, trace, extra)
+	}
+	write("rest",
+		rec(0, "IN", `,"method":"POST","uri":"/api/order"`),
+		rec(1000, "OUT", `,"status":200`),
+		`{"level":"info","time":"2024-05-01 10:00:00.500","msg":"other trace","_trace_id_":"x"}`,
+	)
+	write("invoke",
+		rec(100, "IN", `,"func":"pay"`),
+		rec(900, "OUT", ``),
+	)
+	write("sql", rec(150, "select", `,"sql":"select 1"`), rec(850, "update", `,"sql":"update t"`))
+
+	timeline, err := logtool.BuildTraceTimeline(context.Background(), logtool.TraceOptions{
+		TraceID:   trace,
+		StartTime: "2024-05-01 09:00:00",
+		EndTime:   "2024-05-01 11:00:00",
+		RootDir:   dir,
+	})
+	if err != nil {
+		t.Fatalf("BuildTraceTimeline() error = %v", err)
+	}
+	if len(timeline.Records) != 6 || timeline.DurationMs != 1000 || len(timeline.Categories) != 3 {
+		t.Fatalf("BuildTraceTimeline() records = %d, duration = %d, categories = %v", len(timeline.Records), timeline.DurationMs, timeline.Categories)
+	}
+	if timeline.Records[3].GapMs != 700 || timeline.Records[3].Category != "sql" {
+		t.Errorf("record 3 = %+v, want the update 700ms after the select", timeline.Records[3])
+	}
+
+	if len(timeline.Spans) != 1 {
+		t.Fatalf("BuildTraceTimeline() spans = %d, want one root", len(timeline.Spans))
+	}
+	root := timeline.Spans[0]
+	if root.Name != "POST /api/order" || root.Status != "200" || root.DurationMs != 1000 || len(root.Children) != 1 {
+		t.Fatalf("root span = %+v", root)
+	}
+	child := root.Children[0]
+	if child.Name != "pay" || child.DurationMs != 800 || child.SelfMs != 800 || root.SelfMs != 200 || len(child.Events) != 2 {
+		t.Fatalf("child span = %+v, root self = %d", child, root.SelfMs)
+	}
+	if timeline.Records[2].SpanID != child.ID {
+		t.Errorf("select record belongs to span %d, want %d", timeline.Records[2].SpanID, child.ID)
+	}
+
+	top := timeline.Hotspots[0]
+	if top.Kind != logtool.HotspotSpan || top.SpanID != child.ID || top.Share != 0.8 {
+		t.Errorf("top hotspot = %+v, want the invoke span", top)
+	}
+	if gap := timeline.Hotspots[1]; gap.Kind != logtool.HotspotGap || gap.From != 2 || gap.To != 3 {
+		t.Errorf("second hotspot = %+v, want the gap between the two queries", gap)
+	}
+
+	// records of a registered source are grouped under the source name, not its directory
+	srcDir := filepath.Join(dir, "ext")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	line := fmt.Sprintf("time=%s level=info msg=callback trace=%s\n", base.Add(500*time.Millisecond).Format("2006-01-02T15:04:05.000"), trace)
+	if err := os.WriteFile(filepath.Join(srcDir, "gateway.log"), []byte(line), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := logtool.RegisterSource(logtool.LogSource{Name: "test_trace_gateway", Path: filepath.Join(srcDir, "gateway.log"), Parser: logtool.ParserLogfmt}); err != nil {
+		t.Fatalf("RegisterSource() error = %v", err)
+	}
+	timeline, err = logtool.BuildTraceTimeline(context.Background(), logtool.TraceOptions{TraceID: trace, StartTime: "2024-05-01 09:00:00", EndTime: "2024-05-01 11:00:00", RootDir: dir})
+	if err != nil {
+		t.Fatalf("BuildTraceTimeline(source) error = %v", err)
+	}
+	found := false
+	for _, r := range timeline.Records {
+		if r.Record != nil && r.Record.Msg == "callback" {
+			found = true
+			if r.Category != "test_trace_gateway" {
+				t.Errorf("source record category = %q, want the source name", r.Category)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("BuildTraceTimeline(source) records = %d, categories = %v, want the gateway record", len(timeline.Records), timeline.Categories)
+	}
+}
@@ -0,0 +1,151 @@
+package test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig-om/src/omuser"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testIdP is a minimal OpenID provider: discovery, JWKS and a token endpoint checking PKCE.
+type testIdP struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]map[string]any // code -> claims, plus the PKCE challenge under "_challenge"
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{key: key, codes: make(map[string]map[string]any)}
+	mux := http.NewServeMux()
+	idp.Server = httptest.NewServer(mux)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		idp.mu.Lock()
+		claims, ok := idp.codes[r.PostForm.Get("code")]
+		delete(idp.codes, r.PostForm.Get("code"))
+		idp.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != claims["_challenge"] {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		delete(claims, "_challenge")
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(t, claims)})
+	})
+	return idp
+}
+
+func (idp *testIdP) sign(t *testing.T, claims map[string]any) string {
+	enc := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	input := enc(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"}) + "." + enc(claims)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// authorize plays the browser and the IdP login page: it returns a code for the start URL.
+func (idp *testIdP) authorize(t *testing.T, startURL, user string, groups []string) string {
+	u, err := url.Parse(startURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorization URL without PKCE: %s", startURL)
+	}
+	code := "code-" + user + "-" + q.Get("state")[:8]
+	idp.mu.Lock()
+	idp.codes[code] = map[string]any{
+		"iss": idp.URL, "aud": q.Get("client_id"), "sub": "id-" + user,
+		"preferred_username": user, "groups": groups, "nonce": q.Get("nonce"),
+		"iat": time.Now().Unix(), "exp": time.Now().Add(5 * time.Minute).Unix(),
+		"_challenge": q.Get("code_challenge"),
+	}
+	idp.mu.Unlock()
+	return code
+}
+
+func TestOIDCLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	idp := newTestIdP(t)
+	defer idp.Close()
+	omuser.SetOIDCConfig(omuser.OIDCConfig{
+		Issuer:      idp.URL,
+		ClientID:    "gorig-om",
+		RedirectURL: "https://panel.example.com/sso",
+		RoleMap:     map[string]omuser.Role{"ops": omuser.RoleOperator, "ops-admin": omuser.RoleAdmin},
+	})
+	defer omuser.SetOIDCConfig(omuser.OIDCConfig{})
+
+	ctx := context.Background()
+	user := "alice.sso@example.com"
+	defer func() {
+		_ = omuser.S().Delete(ctx, user)
+	}()
+	newCtx := func() *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/om/auth/oidc/connect", nil)
+		return c
+	}
+
+	start, err := omuser.OIDCStart(newCtx())
+	if err != nil {
+		t.Fatalf("OIDCStart() error = %v", err)
+	}
+	code := idp.authorize(t, start.URL, user, []string{"dev", "ops"})
+	c := newCtx()
+	_, token, err := omuser.OIDCCallback(c, omuser.OIDCCallbackReq{Code: code, State: start.State})
+	if err != nil || token == nil {
+		t.Fatalf("OIDCCallback() error = %v", err)
+	}
+	if role, ok := omuser.RoleOf(ctx, user); !ok || role != omuser.RoleOperator {
+		t.Errorf("RoleOf(%s) = %s, %t, want operator", user, role, ok)
+	}
+	if _, _, err = omuser.OIDCCallback(newCtx(), omuser.OIDCCallbackReq{Code: code, State: start.State}); err == nil {
+		t.Errorf("OIDCCallback() accepted a replayed state")
+	}
+
+	start, _ = omuser.OIDCStart(newCtx())
+	code = idp.authorize(t, start.URL, "bob.sso", []string{"dev"})
+	if _, _, err = omuser.OIDCCallback(newCtx(), omuser.OIDCCallbackReq{Code: code, State: start.State}); err == nil {
+		t.Errorf("OIDCCallback() let in a user outside the mapped groups")
+	}
+}
@@ -0,0 +1,395 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jom-io/gorig-om/src/mid"
+	"github.com/jom-io/gorig-om/src/omuser"
+	"github.com/jom-io/gorig/global/variable"
+	"github.com/jom-io/gorig/mid/tokenx"
+	"github.com/tidwall/gjson"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRolePerms(t *testing.T) {
+	cases := []struct {
+		role omuser.Role
+		perm omuser.Perm
+		want bool
+	}{
+		{omuser.RoleViewer, omuser.PermLogRead, true},
+		{omuser.RoleViewer, omuser.PermAppRestart, false},
+		{omuser.RoleOperator, omuser.PermAppRestart, true},
+		{omuser.RoleOperator, omuser.PermDeployStart, false},
+		{omuser.RoleDeployer, omuser.PermDeployStart, true},
+		{omuser.RoleDeployer, omuser.PermUserManage, false},
+		{omuser.RoleAdmin, omuser.PermUserManage, true},
+		{omuser.RoleDeployer, omuser.PermLogUnmasked, false},
+		{omuser.RoleAdmin, omuser.PermLogUnmasked, true},
+		{omuser.RoleDeployer, omuser.PermAuditManage, false},
+		{omuser.RoleAdmin, omuser.PermAuditManage, true},
+		{omuser.Role("unknown"), omuser.PermLogRead, false},
+	}
+	for _, c := range cases {
+		if got := c.role.Can(c.perm); got != c.want {
+			t.Errorf("%s.Can(%s) = %t, want %t", c.role, c.perm, got, c.want)
+		}
+	}
+	t.Logf("viewer perms: %v", omuser.PermsOf(omuser.RoleViewer))
+}
+
+func TestOMUserSaveVerify(t *testing.T) {
+	ctx := context.Background()
+	name := "om_test_user"
+	defer func() {
+		_ = omuser.S().Delete(ctx, name)
+	}()
+
+	if err := omuser.S().Save(ctx, omuser.SaveUserReq{Username: name, Password: "short", Role: omuser.RoleViewer}); err == nil {
+		t.Errorf("expected short password to be rejected")
+	}
+	if err := omuser.S().Save(ctx, omuser.SaveUserReq{Username: omuser.RootUser, Password: "long-enough-pwd", Role: omuser.RoleAdmin}); err == nil {
+		t.Errorf("expected root username to be rejected")
+	}
+	if err := omuser.S().Save(ctx, omuser.SaveUserReq{Username: name, Password: "long-enough-pwd", Role: omuser.RoleViewer}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if _, ok := omuser.S().Verify(ctx, name, "long-enough-pwd"); !ok {
+		t.Errorf("Verify() failed for correct password")
+	}
+	start := time.Now()
+	if _, ok := omuser.S().Verify(ctx, name, "wrong-password"); ok {
+		t.Errorf("Verify() passed for wrong password")
+	}
+	known := time.Since(start)
+	// an unknown account costs a bcrypt comparison too, so timing does not reveal names
+	start = time.Now()
+	if _, ok := omuser.S().Verify(ctx, "om_test_no_such_user", "wrong-password"); ok {
+		t.Errorf("Verify() passed for an unknown account")
+	}
+	if unknown := time.Since(start); unknown < known/4 {
+		t.Errorf("Verify() of an unknown account took %v, a wrong password %v", unknown, known)
+	}
+
+	if err := omuser.S().Save(ctx, omuser.SaveUserReq{Username: name, Role: omuser.RoleOperator, Disabled: true}); err != nil {
+		t.Fatalf("Save() update error = %v", err)
+	}
+	if _, ok := omuser.RoleOf(ctx, name); ok {
+		t.Errorf("RoleOf() should fail for a disabled account")
+	}
+	if role, ok := omuser.RoleOf(ctx, omuser.RootUser); !ok || role != omuser.RoleAdmin {
+		t.Errorf("RoleOf(root) = %s, %t", role, ok)
+	}
+}
+
+func TestOMSessionRevoke(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	name := "om_test_session"
+	if variable.OMKey == "" {
+		variable.OMKey = "om-test-key"
+		defer func() { variable.OMKey = "" }()
+	}
+	defer func() {
+		_ = omuser.S().Delete(ctx, name)
+	}()
+	if err := omuser.S().Save(ctx, omuser.SaveUserReq{Username: name, Password: "long-enough-pwd", Role: omuser.RoleViewer}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/om/auth/connect", nil)
+	token, err := omuser.LoginByUser(c, name, "long-enough-pwd", "")
+	if err != nil {
+		t.Fatalf("LoginByUser() error = %v", err)
+	}
+	claims, err := tokenx.Get(tokenx.Jwt, tokenx.Memory).Generator.Parse(*token)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !omuser.CheckSession(c, *token, claims) {
+		t.Fatalf("CheckSession() rejected a fresh session")
+	}
+	if omuser.CheckSession(c, *token+"x", claims) {
+		t.Errorf("CheckSession() accepted a token that does not match the session")
+	}
+
+	if err = omuser.S().Revoke(ctx, omuser.RevokeSessionReq{Username: name}); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if omuser.CheckSession(c, *token, claims) {
+		t.Errorf("CheckSession() accepted a revoked session")
+	}
+}
+
+func TestTotpCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA1 secret "12345678901234567890", truncated to 6 digits.
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for ts, want := range cases {
+		got, err := omuser.TotpCode(secret, ts/30)
+		if err != nil {
+			t.Fatalf("TotpCode() error = %v", err)
+		}
+		if got != want {
+			t.Errorf("TotpCode(%d) = %s, want %s", ts, got, want)
+		}
+	}
+}
+
+func TestTotpEnrollCheck(t *testing.T) {
+	ctx := context.Background()
+	name := "om_test_totp"
+	defer func() {
+		_ = omuser.S().TotpReset(ctx, name)
+	}()
+
+	enroll, err := omuser.S().TotpEnroll(ctx, name)
+	if err != nil {
+		t.Fatalf("TotpEnroll() error = %v", err)
+	}
+	if required, _ := omuser.S().TotpRequired(ctx, name); required {
+		t.Errorf("TotpRequired() before confirm = true")
+	}
+	code, _ := omuser.TotpCode(enroll.Secret, time.Now().Unix()/30)
+	recovery, err := omuser.S().TotpConfirm(ctx, name, code)
+	if err != nil {
+		t.Fatalf("TotpConfirm() error = %v", err)
+	}
+	if required, _ := omuser.S().TotpRequired(ctx, name); !required {
+		t.Errorf("TotpRequired() after confirm = false")
+	}
+	if ok, _ := omuser.S().CheckTotp(ctx, name, code); ok {
+		t.Errorf("CheckTotp() accepted a replayed code")
+	}
+	if ok, _ := omuser.S().CheckTotp(ctx, name, recovery[0]); !ok {
+		t.Errorf("CheckTotp() rejected a recovery code")
+	}
+	if ok, _ := omuser.S().CheckTotp(ctx, name, recovery[0]); ok {
+		t.Errorf("CheckTotp() accepted a used recovery code")
+	}
+	// concurrent checks spend a recovery code once
+	var wg sync.WaitGroup
+	var accepted atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := omuser.S().CheckTotp(ctx, name, recovery[1]); ok {
+				accepted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := accepted.Load(); n != 1 {
+		t.Errorf("CheckTotp() accepted one recovery code %d times", n)
+	}
+	status, _ := omuser.S().TotpStatus(ctx, name)
+	if status == nil || status.RecoveryLeft != len(recovery)-2 {
+		t.Errorf("TotpStatus() = %+v", status)
+	}
+}
+
+func TestTotpLoginLockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	name := "om_test_totp_login"
+	if variable.OMKey == "" {
+		variable.OMKey = "om-test-key"
+		defer func() { variable.OMKey = "" }()
+	}
+	defer func() {
+		_ = omuser.S().TotpReset(ctx, name)
+		_ = omuser.S().Delete(ctx, name)
+	}()
+	if err := omuser.S().Save(ctx, omuser.SaveUserReq{Username: name, Password: "long-enough-pwd", Role: omuser.RoleViewer}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	enroll, err := omuser.S().TotpEnroll(ctx, name)
+	if err != nil {
+		t.Fatalf("TotpEnroll() error = %v", err)
+	}
+	code, _ := omuser.TotpCode(enroll.Secret, time.Now().Unix()/30)
+	if _, err = omuser.S().TotpConfirm(ctx, name, code); err != nil {
+		t.Fatalf("TotpConfirm() error = %v", err)
+	}
+
+	// a right password without a code is still an attempt, so it cannot be tried forever;
+	// lockouts outlive the test run, so each run logs in from its own address
+	addr := fmt.Sprintf("198.51.100.%d:40000", time.Now().UnixNano()%250+1)
+	login := func() error {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/om/auth/connect", nil)
+		c.Request.RemoteAddr = addr
+		_, err := omuser.LoginByUser(c, name, "long-enough-pwd", "")
+		return err
+	}
+	if err := login(); err == nil || !strings.Contains(err.Error(), omuser.ErrTotpRequired) {
+		t.Fatalf("LoginByUser() without a code error = %v, want %s", err, omuser.ErrTotpRequired)
+	}
+	for i := 0; i < 4; i++ {
+		_ = login()
+	}
+	if err := login(); err == nil || !strings.Contains(err.Error(), "Connection rejected") {
+		t.Errorf("LoginByUser() after repeated missing codes error = %v, want the lockout", err)
+	}
+}
+
+func TestApiTokenScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	name := "om_test_ci"
+	defer func() {
+		_ = omuser.S().Delete(ctx, name)
+	}()
+	if err := omuser.S().Save(ctx, omuser.SaveUserReq{Username: name, Password: "long-enough-pwd", Role: omuser.RoleDeployer}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if _, err := omuser.S().CreateApiToken(ctx, name, omuser.RoleDeployer, omuser.CreateApiTokenReq{Name: "ci", Scopes: []omuser.Perm{omuser.PermUserManage}}); err == nil {
+		t.Errorf("CreateApiToken() allowed a scope above the owner's role")
+	}
+	out, err := omuser.S().CreateApiToken(ctx, name, omuser.RoleDeployer, omuser.CreateApiTokenReq{Name: "ci", Scopes: []omuser.Perm{omuser.PermDeployStart}, ExpireIn: 30})
+	if err != nil {
+		t.Fatalf("CreateApiToken() error = %v", err)
+	}
+
+	r := gin.New()
+	r.Use(mid.Sign())
+	r.POST("start", mid.Perm(omuser.PermDeployStart), func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("logs", mid.Perm(omuser.PermLogRead), func(c *gin.Context) { c.Status(http.StatusOK) })
+	call := func(method, path, token string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := call(http.MethodPost, "/start", out.Token); code != http.StatusOK {
+		t.Errorf("scoped call status = %d, want 200", code)
+	}
+	if code := call(http.MethodGet, "/logs", out.Token); code == http.StatusOK {
+		t.Errorf("call outside the token scopes was allowed")
+	}
+	if code := call(http.MethodPost, "/start", out.Token+"x"); code == http.StatusOK {
+		t.Errorf("call with a tampered token was allowed")
+	}
+	if err = omuser.S().RevokeApiToken(ctx, name, omuser.RoleDeployer, out.ID); err != nil {
+		t.Fatalf("RevokeApiToken() error = %v", err)
+	}
+	if code := call(http.MethodPost, "/start", out.Token); code == http.StatusOK {
+		t.Errorf("call with a revoked token was allowed")
+	}
+}
+
+func TestChallengeLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	if variable.OMKey == "" {
+		variable.OMKey = "om-test-key"
+		defer func() { variable.OMKey = "" }()
+	}
+	newCtx := func(ip string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/om/auth/connect", nil)
+		c.Request.RemoteAddr = ip + ":1234"
+		return c
+	}
+
+	ch, err := omuser.NewChallenge(newCtx("10.0.0.1"))
+	if err != nil {
+		t.Fatalf("NewChallenge() error = %v", err)
+	}
+	sign := omuser.ChallengeSign(variable.OMKey, ch.Nonce, omuser.RootUser)
+	if _, err = omuser.LoginByChallenge(newCtx("10.0.0.2"), ch.Nonce, sign, ""); err == nil {
+		t.Errorf("LoginByChallenge() accepted a nonce from another IP")
+	}
+	ch, _ = omuser.NewChallenge(newCtx("10.0.0.1"))
+	sign = omuser.ChallengeSign(variable.OMKey, ch.Nonce, omuser.RootUser)
+	forged := newCtx("10.0.0.2")
+	forged.Request.Header.Set("X-Forwarded-For", "10.0.0.1")
+	if _, err = omuser.LoginByChallenge(forged, ch.Nonce, sign, ""); err == nil {
+		t.Errorf("LoginByChallenge() took the IP from X-Forwarded-For of an untrusted peer")
+	}
+
+	ch, _ = omuser.NewChallenge(newCtx("10.0.0.1"))
+	sign = omuser.ChallengeSign(variable.OMKey, ch.Nonce, omuser.RootUser)
+	token, err := omuser.LoginByChallenge(newCtx("10.0.0.1"), ch.Nonce, sign, "")
+	if err != nil || token == nil {
+		t.Fatalf("LoginByChallenge() error = %v", err)
+	}
+	if _, err = omuser.LoginByChallenge(newCtx("10.0.0.1"), ch.Nonce, sign, ""); err == nil {
+		t.Errorf("LoginByChallenge() accepted a replayed nonce")
+	}
+}
+
+func TestStreamTicket(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	name := "om_test_ticket"
+	if variable.OMKey == "" {
+		variable.OMKey = "om-test-key"
+		defer func() { variable.OMKey = "" }()
+	}
+	defer func() {
+		_ = omuser.S().Delete(ctx, name)
+	}()
+	if err := omuser.S().Save(ctx, omuser.SaveUserReq{Username: name, Password: "long-enough-pwd", Role: omuser.RoleViewer}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/om/auth/connect", nil)
+	token, err := omuser.LoginByUser(c, name, "long-enough-pwd", "")
+	if err != nil {
+		t.Fatalf("LoginByUser() error = %v", err)
+	}
+
+	r := gin.New()
+	r.GET("/om/log/monitor", mid.SignStream(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/om/log/download", mid.SignStream(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/om/log/search", mid.Sign(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.POST("/om/auth/ticket", mid.Sign(), omuser.Ticket)
+	call := func(method, path, bearer string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+	mint := func(path string) string {
+		w := call(http.MethodPost, "/om/auth/ticket?path="+path, *token)
+		ticket := gjson.Get(w.Body.String(), "data.ticket").String()
+		if ticket == "" {
+			t.Fatalf("ticket response = %s", w.Body.String())
+		}
+		return ticket
+	}
+
+	if w := call(http.MethodGet, "/om/log/search?token="+*token, ""); w.Code == http.StatusOK {
+		t.Errorf("Sign() still accepts the token in the query string")
+	}
+	ticket := mint("/om/log/monitor")
+	if w := call(http.MethodGet, "/om/log/download?ticket="+ticket, ""); w.Code == http.StatusOK {
+		t.Errorf("ticket was accepted on another path")
+	}
+	ticket = mint("/om/log/monitor")
+	if w := call(http.MethodGet, "/om/log/monitor?ticket="+ticket, ""); w.Code != http.StatusOK {
+		t.Errorf("ticket was rejected, status %d", w.Code)
+	}
+	if w := call(http.MethodGet, "/om/log/monitor?ticket="+ticket, ""); w.Code == http.StatusOK {
+		t.Errorf("ticket was accepted twice")
+	}
+}